@@ -0,0 +1,295 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+// GlueNS is a nameserver of the delegation with the glue the parent sends for it and
+// the addresses that are authoritative for its name.
+type GlueNS struct {
+	Name        string
+	InBailiwick bool     // the name is in the zone, glue is required
+	Sibling     bool     // the name is in another zone of the same parent
+	Glue        []string // glue addresses sent by the parent servers
+	Auth        []string // authoritative A and AAAA records of the name
+	Error       string   `json:",omitempty"`
+}
+
+// Cycle is a dependency of the zone on itself: every nameserver is in a zone that
+// only has nameservers in this zone.
+type Cycle struct {
+	NS    string
+	Zone  string   // zone of NS
+	Names []string // nameservers of Zone
+}
+
+// ScanBailiwick collects the glue of every nameserver of the delegation, the
+// authoritative addresses of the nameservers and the zones they depend on.
+func (g *Glue) ScanBailiwick(domain string) {
+	log.Debugf("GLUE: bailiwick")
+	defer log.Debugf("GLUE: bailiwick exit")
+
+	domain = dns.Fqdn(domain)
+	parent := dns.Fqdn(getParentDomain(domain))
+	glue := make(map[string][]string)
+	names := make(map[string]bool)
+
+	for _, d := range g.Parent {
+		for _, rr := range d.NS {
+			names[strings.ToLower(rr.(*dns.NS).Ns)] = true
+		}
+
+		for _, rr := range d.Glue {
+			name := strings.ToLower(rr.Header().Name)
+
+			for _, ip := range extractIP([]dns.RR{rr}) {
+				if !hasValue(glue[name], ip.String()) {
+					glue[name] = append(glue[name], ip.String())
+				}
+			}
+		}
+	}
+
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+
+	sort.Strings(sorted)
+
+	for _, name := range sorted {
+		ns := GlueNS{
+			Name:        name,
+			InBailiwick: dns.IsSubDomain(domain, name),
+			Glue:        glue[name],
+		}
+		ns.Sibling = !ns.InBailiwick && dns.IsSubDomain(parent, name)
+
+		ips, err := g.authAddresses(name, ns.InBailiwick)
+		if err != nil {
+			ns.Error = err.Error()
+		}
+
+		for _, ip := range ips {
+			ns.Auth = append(ns.Auth, ip.String())
+		}
+
+		sort.Strings(ns.Glue)
+		sort.Strings(ns.Auth)
+
+		g.Bailiwick = append(g.Bailiwick, ns)
+	}
+
+	// glue for names that are not in the NS records is stale
+	g.Stale = nil
+
+	for name := range glue {
+		if !names[name] {
+			g.Stale = append(g.Stale, name)
+		}
+	}
+
+	sort.Strings(g.Stale)
+
+	g.Cycles = g.cycles(domain)
+}
+
+// authAddresses returns the authoritative A and AAAA records of name: from the
+// nameservers of the zone if name is in the zone, otherwise from the resolver.
+func (g *Glue) authAddresses(name string, inZone bool) ([]net.IP, error) {
+	var (
+		ips     []net.IP
+		lastErr error
+	)
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		if !inZone {
+			rrs, err := resolverLookup(g.s)(name, qtype)
+			if err != nil {
+				lastErr = err
+			}
+
+			ips = append(ips, extractIP(rrs)...)
+
+			continue
+		}
+
+	servers:
+		for _, ns := range g.NS {
+			for _, nsip := range ns.IP {
+				res, err := scan.Query(name, qtype, nsip.String(), false)
+				if err != nil && !strings.Contains(err.Error(), "NXDOMAIN") {
+					lastErr = err
+					continue
+				}
+
+				if err == nil && !res.Msg.Authoritative {
+					continue
+				}
+
+				if err == nil {
+					ips = append(ips, extractIP(extractRR(res.Msg.Answer, qtype))...)
+				}
+
+				lastErr = nil
+
+				break servers
+			}
+		}
+	}
+
+	if len(ips) == 0 && lastErr != nil {
+		return ips, lastErr
+	}
+
+	return ips, nil
+}
+
+// cycles returns the cyclic dependencies of domain: if no nameserver is in the zone
+// and the zones of all nameservers only have nameservers in domain, nothing can
+// resolve them.
+func (g *Glue) cycles(domain string) []Cycle {
+	var cycles []Cycle
+
+	if len(g.Bailiwick) == 0 {
+		return cycles
+	}
+
+	zones := make(map[string][]string)
+
+	for _, ns := range g.Bailiwick {
+		if ns.InBailiwick {
+			return nil
+		}
+
+		zone, err := zoneOf(g.s, ns.Name)
+		if err != nil {
+			// the zone can't be found, a cycle is one reason
+			zone = dns.Fqdn(getParentDomain(ns.Name))
+		}
+
+		// ask the parent of the zone, the resolver can't resolve a zone in a cycle
+		if _, ok := zones[zone]; !ok {
+			delegations, _ := parentDelegations(g.s, zone)
+			for _, d := range delegations {
+				for _, rr := range d.NS {
+					if name := strings.ToLower(rr.(*dns.NS).Ns); !hasValue(zones[zone], name) {
+						zones[zone] = append(zones[zone], name)
+					}
+				}
+			}
+		}
+
+		names := zones[zone]
+		if len(names) == 0 {
+			return nil
+		}
+
+		for _, name := range names {
+			if !dns.IsSubDomain(domain, name) {
+				return nil
+			}
+		}
+
+		cycles = append(cycles, Cycle{NS: ns.Name, Zone: zone, Names: names})
+	}
+
+	return cycles
+}
+
+// BailiwickValues reports missing, unnecessary, stale and wrong glue and cyclic
+// dependencies.
+func (g *Glue) BailiwickValues(domain string) []ReportResult {
+	var results []ReportResult
+
+	for _, ns := range g.Bailiwick {
+		switch {
+		case ns.InBailiwick && len(ns.Glue) == 0:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: NS %s is in the zone and needs glue, but the parent sends none.", ns.Name),
+				Status: false, Name: "GlueRequired",
+			})
+		case !ns.InBailiwick && !ns.Sibling && len(ns.Glue) > 0:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: NS %s is out of bailiwick, its glue %v is unnecessary and ignored by resolvers.", ns.Name, ns.Glue),
+				Status: false, Name: "GlueUnnecessary",
+			})
+		}
+
+		if len(ns.Glue) == 0 {
+			continue
+		}
+
+		if ns.Error != "" {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : Authoritative addresses of NS %s can't be checked against the glue: %s", ns.Name, ns.Error),
+				Status: false, Name: "GlueMatch",
+			})
+
+			continue
+		}
+
+		var wrong, missing []string
+
+		for _, ip := range ns.Glue {
+			if !hasValue(ns.Auth, ip) {
+				wrong = append(wrong, ip)
+			}
+		}
+
+		for _, ip := range ns.Auth {
+			if !hasValue(ns.Glue, ip) {
+				missing = append(missing, ip)
+			}
+		}
+
+		if len(wrong) > 0 {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Glue %v for NS %s doesn't match its authoritative addresses %v.", wrong, ns.Name, ns.Auth),
+				Status: false, Name: "GlueMatch",
+			})
+		}
+
+		if len(missing) > 0 && ns.InBailiwick {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: NS %s has addresses %v that the parent has no glue for.", ns.Name, missing),
+				Status: false, Name: "GlueMatch",
+			})
+		}
+	}
+
+	for _, name := range g.Stale {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: Parent sends glue for %s, which is not a NS of the zone. The glue is stale.", name),
+			Status: false, Name: "GlueStale",
+		})
+	}
+
+	if len(g.Cycles) > 0 {
+		res := ReportResult{
+			Result: fmt.Sprintf("FAIL: Cyclic dependency: all nameservers of %s are in zones that only have nameservers in it, they can't be resolved", dns.Fqdn(domain)),
+			Status: false, Name: "GlueCycle",
+		}
+
+		for _, c := range g.Cycles {
+			res.Records = append(res.Records, fmt.Sprintf("%s in %s: %s", c.NS, c.Zone, strings.Join(c.Names, " ")))
+		}
+
+		results = append(results, res)
+	}
+
+	if len(results) == 0 && len(g.Bailiwick) > 0 {
+		results = append(results, ReportResult{
+			Result: "OK  : Glue is present for in-bailiwick nameservers and matches their authoritative addresses.",
+			Status: true, Name: "Bailiwick",
+		})
+	}
+
+	return results
+}
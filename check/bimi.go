@@ -0,0 +1,549 @@
+package check
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	bimiMaxSVGSize = 32 * 1024 // recommended maximum size of the logo
+	bimiMaxVMCSize = 64 * 1024
+)
+
+var (
+	// id-kp-BrandIndicatorforMessageIdentification
+	oidBIMIUsage = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 31}
+	// id-pe-logotype (RFC 3709)
+	oidLogotype = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 12}
+)
+
+// svgTinyPSForbidden are the elements that are not allowed in the SVG Tiny Portable/Secure
+// profile: scripts, animation, interactivity and embedded or external content.
+var svgTinyPSForbidden = map[string]bool{
+	"script":           true,
+	"animate":          true,
+	"animateColor":     true,
+	"animateMotion":    true,
+	"animateTransform": true,
+	"set":              true,
+	"a":                true,
+	"foreignObject":    true,
+	"image":            true,
+	"video":            true,
+	"audio":            true,
+	"iframe":           true,
+	"handler":          true,
+	"listener":         true,
+	"discard":          true,
+}
+
+// BIMIRecord is a parsed BIMI assertion record.
+type BIMIRecord struct {
+	Text      string
+	Version   string
+	Location  string // l=, URL of the SVG logo
+	Authority string // a=, URL of the VMC
+}
+
+// BIMIVMC is the Verified Mark Certificate found at the a= URL.
+type BIMIVMC struct {
+	Subject   string
+	Issuer    string
+	NotBefore time.Time
+	NotAfter  time.Time
+	DNSNames  []string
+	BIMIUsage bool // has the BIMI extended key usage
+	Logotype  bool // has the logotype extension with the embedded logo
+	Chain     int  // number of certificates in the file
+}
+
+// BIMIData has the BIMI records of the default selector and the validation of the
+// logo and the VMC they point to.
+type BIMIData struct {
+	Domain    string
+	TXT       []dns.RR
+	SVGSize   int      `json:",omitempty"`
+	SVGErrors []string `json:",omitempty"` // SVG Tiny PS violations
+	SVGError  string   `json:",omitempty"` // fetch error
+	VMC       *BIMIVMC `json:",omitempty"`
+	VMCError  string   `json:",omitempty"`
+}
+
+// ParseBIMI parses a default._bimi TXT record.
+func ParseBIMI(record string) (*BIMIRecord, error) {
+	rec := &BIMIRecord{Text: record}
+
+	tags := tagList(record)
+	if len(tags) == 0 || tags[0][0] != "v" || tags[0][1] != "BIMI1" {
+		return rec, fmt.Errorf("record does not start with v=BIMI1")
+	}
+
+	rec.Version = tags[0][1]
+	seen := make(map[string]bool)
+
+	for _, tag := range tags[1:] {
+		if seen[tag[0]] {
+			return rec, fmt.Errorf("tag %s appears more than once", tag[0])
+		}
+
+		seen[tag[0]] = true
+
+		switch tag[0] {
+		case "l":
+			rec.Location = tag[1]
+		case "a":
+			rec.Authority = tag[1]
+		}
+	}
+
+	if !seen["l"] {
+		return rec, fmt.Errorf("no l= tag found")
+	}
+
+	for _, u := range []string{rec.Location, rec.Authority} {
+		if u == "" {
+			continue
+		}
+
+		if p, err := url.Parse(u); err != nil || p.Scheme != "https" || p.Host == "" {
+			return rec, fmt.Errorf("invalid URI %s, must be https", u)
+		}
+	}
+
+	if rec.Location != "" && !strings.HasSuffix(strings.ToLower(rec.Location), ".svg") {
+		return rec, fmt.Errorf("l=%s does not point to an SVG file", rec.Location)
+	}
+
+	return rec, nil
+}
+
+// FetchBIMIAsset fetches the logo or the VMC at u with client, reading at most max
+// bytes. It returns the body and the content type.
+func FetchBIMIAsset(client *http.Client, u string, max int64) ([]byte, string, error) {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Get(u)
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("HTTP status %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if int64(len(body)) > max {
+		return nil, "", fmt.Errorf("larger than %d bytes", max)
+	}
+
+	ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+
+	return body, ct, nil
+}
+
+// ValidateSVGTinyPS checks the constraints of the SVG Tiny Portable/Secure profile
+// that BIMI requires and returns the violations.
+func ValidateSVGTinyPS(data []byte) []string {
+	var (
+		problems []string
+		root     bool
+		title    bool
+		depth    int
+	)
+
+	d := xml.NewDecoder(bytes.NewReader(data))
+
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return append(problems, fmt.Sprintf("invalid XML: %s", err))
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+
+			if !root {
+				root = true
+				problems = append(problems, svgRootProblems(t)...)
+
+				continue
+			}
+
+			if t.Name.Local == "title" && depth == 2 {
+				title = true
+			}
+
+			if svgTinyPSForbidden[t.Name.Local] {
+				problems = append(problems, fmt.Sprintf("<%s> is not allowed", t.Name.Local))
+			}
+
+			problems = append(problems, svgAttrProblems(t)...)
+		case xml.EndElement:
+			depth--
+		case xml.Directive:
+			if bytes.Contains(bytes.ToUpper(t), []byte("ENTITY")) {
+				problems = append(problems, "entity declarations are not allowed")
+			}
+		}
+	}
+
+	if !root {
+		return append(problems, "no <svg> element found")
+	}
+
+	if !title {
+		problems = append(problems, "no <title> element found, it is required")
+	}
+
+	return problems
+}
+
+// svgRootProblems checks the attributes of the root element.
+func svgRootProblems(t xml.StartElement) []string {
+	var problems []string
+
+	if t.Name.Local != "svg" {
+		return []string{fmt.Sprintf("root element is <%s>, not <svg>", t.Name.Local)}
+	}
+
+	attrs := make(map[string]string)
+	for _, a := range t.Attr {
+		if a.Name.Space == "" {
+			attrs[a.Name.Local] = a.Value
+		}
+	}
+
+	if t.Name.Space != "http://www.w3.org/2000/svg" {
+		problems = append(problems, "missing SVG namespace xmlns=\"http://www.w3.org/2000/svg\"")
+	}
+
+	if attrs["baseProfile"] != "tiny-ps" {
+		problems = append(problems, fmt.Sprintf("baseProfile is %q, must be \"tiny-ps\"", attrs["baseProfile"]))
+	}
+
+	if attrs["version"] != "1.2" {
+		problems = append(problems, fmt.Sprintf("version is %q, must be \"1.2\"", attrs["version"]))
+	}
+
+	for _, name := range []string{"x", "y"} {
+		if _, ok := attrs[name]; ok {
+			problems = append(problems, fmt.Sprintf("%s= attribute is not allowed on <svg>", name))
+		}
+	}
+
+	var x, y, w, h float64
+	if n, _ := fmt.Sscan(strings.ReplaceAll(attrs["viewBox"], ",", " "), &x, &y, &w, &h); n != 4 {
+		problems = append(problems, "no valid viewBox attribute")
+	} else if w != h {
+		problems = append(problems, fmt.Sprintf("viewBox %s is not square", attrs["viewBox"]))
+	}
+
+	return append(problems, svgAttrProblems(t)...)
+}
+
+// svgAttrProblems checks for event handlers and external references.
+func svgAttrProblems(t xml.StartElement) []string {
+	var problems []string
+
+	for _, a := range t.Attr {
+		switch {
+		case strings.HasPrefix(a.Name.Local, "on"):
+			problems = append(problems, fmt.Sprintf("event attribute %s on <%s> is not allowed", a.Name.Local, t.Name.Local))
+		case a.Name.Local == "href" && !strings.HasPrefix(a.Value, "#"):
+			problems = append(problems, fmt.Sprintf("external reference %s on <%s> is not allowed", a.Value, t.Name.Local))
+		}
+	}
+
+	return problems
+}
+
+// ParseVMC parses a PEM file with a Verified Mark Certificate followed by its chain.
+func ParseVMC(data []byte) (*BIMIVMC, error) {
+	var certs []*x509.Certificate
+
+	for {
+		var block *pem.Block
+
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		certs = append(certs, cert)
+	}
+
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found")
+	}
+
+	leaf := certs[0]
+	vmc := &BIMIVMC{
+		Subject:   leaf.Subject.String(),
+		Issuer:    leaf.Issuer.String(),
+		NotBefore: leaf.NotBefore,
+		NotAfter:  leaf.NotAfter,
+		DNSNames:  leaf.DNSNames,
+		Chain:     len(certs),
+	}
+
+	for _, oid := range leaf.UnknownExtKeyUsage {
+		if oid.Equal(oidBIMIUsage) {
+			vmc.BIMIUsage = true
+		}
+	}
+
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidLogotype) {
+			vmc.Logotype = true
+		}
+	}
+
+	return vmc, nil
+}
+
+// Scan fetches and validates the logo and the VMC of the BIMI record.
+func (d *BIMIData) Scan(client *http.Client) {
+	bimi := recordsWithVersion(d.TXT, "BIMI1")
+	if len(bimi) != 1 {
+		return
+	}
+
+	rec, err := ParseBIMI(txtString(bimi[0]))
+	if err != nil {
+		return
+	}
+
+	if rec.Location != "" {
+		svg, ct, err := FetchBIMIAsset(client, rec.Location, bimiMaxSVGSize)
+
+		switch {
+		case err != nil:
+			d.SVGError = err.Error()
+		case ct != "image/svg+xml":
+			d.SVGError = fmt.Sprintf("content type %q, must be image/svg+xml", ct)
+		default:
+			d.SVGSize = len(svg)
+			d.SVGErrors = ValidateSVGTinyPS(svg)
+		}
+	}
+
+	if rec.Authority != "" {
+		data, _, err := FetchBIMIAsset(client, rec.Authority, bimiMaxVMCSize)
+		if err == nil {
+			d.VMC, err = ParseVMC(data)
+		}
+
+		if err != nil {
+			d.VMCError = err.Error()
+		}
+	}
+}
+
+// Values reports on the BIMI record, its assets and the DMARC policy of the domain
+// that BIMI requires.
+func (d *BIMIData) Values(dmarc *DMARCRecord) []ReportResult {
+	var results []ReportResult
+
+	bimi := recordsWithVersion(d.TXT, "BIMI1")
+
+	switch len(bimi) {
+	case 0:
+		return append(results, ReportResult{
+			Result: "INFO: No BIMI record found.",
+			Status: true, Name: "BIMI",
+		})
+	case 1:
+	default:
+		return append(results, ReportResult{
+			Result: "FAIL: Multiple BIMI records found, receivers will ignore them.",
+			Status: false, Name: "BIMISyntax",
+		})
+	}
+
+	records := []string{bimi[0].String()}
+
+	rec, err := ParseBIMI(txtString(bimi[0]))
+	if err != nil {
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: BIMI syntax error: %s", err),
+			Status: false, Records: records, Name: "BIMISyntax",
+		})
+	}
+
+	if rec.Location == "" && rec.Authority == "" {
+		return append(results, ReportResult{
+			Result: "INFO: BIMI record declines to publish a logo.",
+			Status: true, Records: records, Name: "BIMI",
+		})
+	}
+
+	results = append(results, ReportResult{
+		Result: "OK  : BIMI record found.",
+		Status: true, Records: records, Name: "BIMI",
+	})
+
+	results = append(results, bimiDMARCValues(dmarc)...)
+	results = append(results, d.svgValues(rec)...)
+
+	return append(results, d.vmcValues(rec)...)
+}
+
+// bimiDMARCValues checks that DMARC is enforced, receivers don't show a logo otherwise.
+func bimiDMARCValues(dmarc *DMARCRecord) []ReportResult {
+	switch {
+	case dmarc == nil:
+		return []ReportResult{{
+			Result: "FAIL: BIMI requires a valid DMARC record with p=quarantine or p=reject.",
+			Status: false, Name: "BIMIDMARC",
+		}}
+	case dmarc.Policy != "quarantine" && dmarc.Policy != "reject":
+		return []ReportResult{{
+			Result: fmt.Sprintf("FAIL: DMARC policy is p=%s, BIMI requires p=quarantine or p=reject.", dmarc.Policy),
+			Status: false, Name: "BIMIDMARC",
+		}}
+	case dmarc.SubdomainPolicy == "none":
+		return []ReportResult{{
+			Result: "FAIL: DMARC subdomain policy is sp=none, BIMI requires sp=quarantine or sp=reject.",
+			Status: false, Name: "BIMIDMARC",
+		}}
+	case dmarc.Pct != 100:
+		return []ReportResult{{
+			Result: fmt.Sprintf("FAIL: DMARC pct=%d, BIMI requires the policy to apply to all mail (pct=100).", dmarc.Pct),
+			Status: false, Name: "BIMIDMARC",
+		}}
+	}
+
+	return []ReportResult{{
+		Result: fmt.Sprintf("OK  : DMARC policy p=%s with pct=100 is enforced as BIMI requires.", dmarc.Policy),
+		Status: true, Name: "BIMIDMARC",
+	}}
+}
+
+func (d *BIMIData) svgValues(rec *BIMIRecord) []ReportResult {
+	switch {
+	case rec.Location == "":
+		return []ReportResult{{
+			Result: "WARN: BIMI record has no logo location (l=).",
+			Status: false, Name: "BIMILogo",
+		}}
+	case d.SVGError != "":
+		return []ReportResult{{
+			Result: fmt.Sprintf("FAIL: BIMI logo %s failed: %s", rec.Location, d.SVGError),
+			Status: false, Name: "BIMILogo",
+		}}
+	case d.SVGSize == 0:
+		// not fetched
+		return nil
+	case len(d.SVGErrors) > 0:
+		return []ReportResult{{
+			Result: fmt.Sprintf("FAIL: BIMI logo %s is not a valid SVG Tiny PS image.", rec.Location),
+			Status: false, Records: d.SVGErrors, Name: "BIMILogo",
+		}}
+	}
+
+	return []ReportResult{{
+		Result: fmt.Sprintf("OK  : BIMI logo %s is a valid SVG Tiny PS image (%d bytes).", rec.Location, d.SVGSize),
+		Status: true, Name: "BIMILogo",
+	}}
+}
+
+func (d *BIMIData) vmcValues(rec *BIMIRecord) []ReportResult {
+	var results []ReportResult
+
+	switch {
+	case rec.Authority == "":
+		return append(results, ReportResult{
+			Result: "WARN: BIMI record has no VMC (a=), most mailbox providers only show logos with a VMC.",
+			Status: false, Name: "BIMIVMC",
+		})
+	case d.VMCError != "":
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: BIMI VMC %s failed: %s", rec.Authority, d.VMCError),
+			Status: false, Name: "BIMIVMC",
+		})
+	case d.VMC == nil:
+		// not fetched
+		return results
+	}
+
+	vmc := d.VMC
+	records := []string{"Subject: " + vmc.Subject, "Issuer: " + vmc.Issuer, "DNS: " + strings.Join(vmc.DNSNames, ", ")}
+	now := time.Now()
+
+	results = append(results, ReportResult{
+		Result: fmt.Sprintf("OK  : BIMI VMC found, valid until %s.", vmc.NotAfter.Format("2006-01-02")),
+		Status: true, Records: records, Name: "BIMIVMC",
+	})
+
+	if now.Before(vmc.NotBefore) || now.After(vmc.NotAfter) {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: BIMI VMC is not valid now, it is valid from %s until %s.", vmc.NotBefore.Format("2006-01-02"), vmc.NotAfter.Format("2006-01-02")),
+			Status: false, Name: "BIMIVMC",
+		})
+	}
+
+	if !vmc.BIMIUsage {
+		results = append(results, ReportResult{
+			Result: "FAIL: BIMI VMC doesn't have the BIMI extended key usage.",
+			Status: false, Name: "BIMIVMC",
+		})
+	}
+
+	if !vmc.Logotype {
+		results = append(results, ReportResult{
+			Result: "FAIL: BIMI VMC doesn't have an embedded logo (logotype extension).",
+			Status: false, Name: "BIMIVMC",
+		})
+	}
+
+	domain := strings.ToLower(strings.TrimSuffix(d.Domain, "."))
+	if domain != "" && !hasValue(vmc.DNSNames, domain) && !hasValue(vmc.DNSNames, "default._bimi."+domain) {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: BIMI VMC is not issued for %s.", domain),
+			Status: false, Name: "BIMIVMC",
+		})
+	}
+
+	if vmc.Chain < 2 {
+		results = append(results, ReportResult{
+			Result: "WARN: BIMI VMC file doesn't include the issuer chain.",
+			Status: false, Name: "BIMIVMC",
+		})
+	}
+
+	return results
+}
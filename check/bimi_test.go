@@ -0,0 +1,286 @@
+package check
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const testSVG = `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 100 100"><title>Example</title><circle cx="50" cy="50" r="40"/></svg>`
+
+func TestParseBIMI(t *testing.T) {
+	tests := []struct {
+		record    string
+		location  string
+		authority string
+		err       string
+	}{
+		{record: "v=BIMI1; l=https://example.com/logo.svg", location: "https://example.com/logo.svg"},
+		{record: "v=BIMI1; l=https://example.com/logo.svg; a=https://example.com/vmc.pem", location: "https://example.com/logo.svg", authority: "https://example.com/vmc.pem"},
+		{record: "v=BIMI1; l=; a=;"},
+		{record: "v=BIMI1;", err: "no l= tag found"},
+		{record: "l=https://example.com/logo.svg; v=BIMI1", err: "does not start with v=BIMI1"},
+		{record: "v=BIMI1; l=http://example.com/logo.svg", err: "must be https"},
+		{record: "v=BIMI1; l=https://example.com/logo.png", err: "not point to an SVG file"},
+		{record: "v=BIMI1; l=https://example.com/logo.svg; a=ftp://example.com/vmc.pem", err: "must be https"},
+		{record: "v=BIMI1; l=https://example.com/a.svg; l=https://example.com/b.svg", err: "more than once"},
+	}
+
+	for _, tt := range tests {
+		rec, err := ParseBIMI(tt.record)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseBIMI(%q) error = %v, want %q", tt.record, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil || rec.Location != tt.location || rec.Authority != tt.authority {
+			t.Errorf("ParseBIMI(%q) = %+v, %v", tt.record, rec, err)
+		}
+	}
+}
+
+func TestValidateSVGTinyPS(t *testing.T) {
+	tests := []struct {
+		name string
+		svg  string
+		want []string
+	}{
+		{name: "valid", svg: testSVG},
+		{
+			name: "wrong profile",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg" version="1.1" baseProfile="full" viewBox="0 0 100 50"><title>x</title></svg>`,
+			want: []string{`baseProfile is "full"`, `version is "1.1"`, "not square"},
+		},
+		{
+			name: "no namespace, position and no title",
+			svg:  `<svg version="1.2" baseProfile="tiny-ps" x="0" viewBox="0 0 10 10"></svg>`,
+			want: []string{"missing SVG namespace", "x= attribute", "no <title>"},
+		},
+		{
+			name: "nested title",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><g><title>x</title></g></svg>`,
+			want: []string{"no <title>"},
+		},
+		{
+			name: "script and events",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1" onload="x()"><title>x</title><script>x()</script></svg>`,
+			want: []string{"event attribute onload", "<script> is not allowed"},
+		},
+		{
+			name: "external reference",
+			svg:  `<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title><use xlink:href="https://example.com/x.svg#a"/><use xlink:href="#a"/></svg>`,
+			want: []string{"external reference https://example.com/x.svg#a"},
+		},
+		{
+			name: "entity",
+			svg:  `<!DOCTYPE svg [<!ENTITY x "y">]><svg xmlns="http://www.w3.org/2000/svg" version="1.2" baseProfile="tiny-ps" viewBox="0 0 1 1"><title>x</title></svg>`,
+			want: []string{"entity declarations"},
+		},
+		{name: "not svg", svg: `<html></html>`, want: []string{"root element is <html>", "no <title>"}},
+		{name: "not xml", svg: `<svg`, want: []string{"invalid XML"}},
+		{name: "empty", svg: ``, want: []string{"no <svg> element"}},
+	}
+
+	for _, tt := range tests {
+		problems := ValidateSVGTinyPS([]byte(tt.svg))
+
+		if len(problems) != len(tt.want) {
+			t.Errorf("%s: problems %q, want %q", tt.name, problems, tt.want)
+			continue
+		}
+
+		for i, p := range problems {
+			if !strings.Contains(p, tt.want[i]) {
+				t.Errorf("%s: problem %q, want %q", tt.name, p, tt.want[i])
+			}
+		}
+	}
+}
+
+// testVMC returns a PEM file with a mark certificate for domain and its issuer.
+func testVMC(t *testing.T, domain string, bimi bool) []byte {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test mark CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, _ := x509.ParseCertificate(caDER)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	if bimi {
+		tmpl.UnknownExtKeyUsage = []asn1.ObjectIdentifier{oidBIMIUsage}
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidLogotype, Value: []byte{0x30, 0x00}}}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	return append(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})...)
+}
+
+func TestParseVMC(t *testing.T) {
+	vmc, err := ParseVMC(testVMC(t, "example.com", true))
+	if err != nil || !vmc.BIMIUsage || !vmc.Logotype || vmc.Chain != 2 || !hasValue(vmc.DNSNames, "example.com") {
+		t.Errorf("ParseVMC = %+v, %v", vmc, err)
+	}
+
+	vmc, err = ParseVMC(testVMC(t, "example.com", false))
+	if err != nil || vmc.BIMIUsage || vmc.Logotype {
+		t.Errorf("ParseVMC without BIMI extensions = %+v, %v", vmc, err)
+	}
+
+	if _, err := ParseVMC([]byte("not a certificate")); err == nil || !strings.Contains(err.Error(), "no PEM certificate") {
+		t.Errorf("ParseVMC without PEM error = %v", err)
+	}
+}
+
+func TestBIMIDMARCValues(t *testing.T) {
+	tests := []struct {
+		record string
+		want   string
+	}{
+		{"v=DMARC1; p=reject", "OK  : DMARC policy p=reject"},
+		{"v=DMARC1; p=quarantine; sp=reject", "OK  : DMARC policy p=quarantine"},
+		{"v=DMARC1; p=none", "FAIL: DMARC policy is p=none"},
+		{"v=DMARC1; p=reject; sp=none", "FAIL: DMARC subdomain policy is sp=none"},
+		{"v=DMARC1; p=reject; pct=50", "FAIL: DMARC pct=50"},
+	}
+
+	for _, tt := range tests {
+		rec, err := ParseDMARC(tt.record)
+		if err != nil {
+			t.Fatalf("ParseDMARC(%q): %s", tt.record, err)
+		}
+
+		results := bimiDMARCValues(rec)
+		if len(results) != 1 || !strings.HasPrefix(results[0].Result, tt.want) {
+			t.Errorf("bimiDMARCValues(%q) = %v, want %q", tt.record, results, tt.want)
+		}
+	}
+
+	if results := bimiDMARCValues(nil); len(results) != 1 || !strings.HasPrefix(results[0].Result, "FAIL: BIMI requires a valid DMARC record") {
+		t.Errorf("bimiDMARCValues(nil) = %v", results)
+	}
+}
+
+func TestBIMIScan(t *testing.T) {
+	vmc := testVMC(t, "example.com", true)
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/logo.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			_, _ = w.Write([]byte(testSVG))
+		case "/text.svg":
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte(testSVG))
+		case "/large.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			_, _ = w.Write(make([]byte, bimiMaxSVGSize+1))
+		case "/vmc.pem":
+			_, _ = w.Write(vmc)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name   string
+		record string
+		want   []string
+	}{
+		{
+			name:   "logo and VMC",
+			record: "v=BIMI1; l=https://bimi.example.com/logo.svg; a=https://bimi.example.com/vmc.pem",
+			want:   []string{"OK  : BIMI logo https://bimi.example.com/logo.svg is a valid SVG Tiny PS image", "OK  : BIMI VMC found"},
+		},
+		{
+			name:   "wrong content type",
+			record: "v=BIMI1; l=https://bimi.example.com/text.svg",
+			want:   []string{`FAIL: BIMI logo https://bimi.example.com/text.svg failed: content type "text/plain"`, "WARN: BIMI record has no VMC"},
+		},
+		{
+			name:   "too large",
+			record: "v=BIMI1; l=https://bimi.example.com/large.svg",
+			want:   []string{"FAIL: BIMI logo https://bimi.example.com/large.svg failed: larger than"},
+		},
+		{
+			name:   "missing VMC",
+			record: "v=BIMI1; l=https://bimi.example.com/logo.svg; a=https://bimi.example.com/missing.pem",
+			want:   []string{"FAIL: BIMI VMC https://bimi.example.com/missing.pem failed: HTTP status 404"},
+		},
+	}
+
+	dmarc, _ := ParseDMARC("v=DMARC1; p=reject")
+
+	for _, tt := range tests {
+		rr, err := dns.NewRR(`default._bimi.example.com. 300 IN TXT "` + tt.record + `"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d := &BIMIData{Domain: "example.com.", TXT: []dns.RR{rr}}
+		d.Scan(policyClient(srv))
+
+		results := d.Values(dmarc)
+
+		for _, want := range tt.want {
+			found := false
+
+			for _, res := range results {
+				if strings.HasPrefix(res.Result, want) {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("%s: no result %q in %v", tt.name, want, results)
+			}
+		}
+	}
+}
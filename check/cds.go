@@ -0,0 +1,376 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// CDSCheck checks the CDS/CDNSKEY records used for automated DS maintenance (RFC 7344/8078).
+type CDSCheck struct {
+	NS       []structs.NSData
+	CDS      []CDSData
+	Keys     []dns.RR // DNSKEY records of the domain on all nameservers
+	ParentDS []dns.RR // DS rrset at the parent
+	Report
+	s *scan.Scan
+}
+
+type CDSData struct {
+	Name         string
+	IP           string
+	CDS          []dns.RR
+	CDSSig       []dns.RR
+	CDNSKEY      []dns.RR
+	CDNSKEYSig   []dns.RR
+	DNSKEY       []dns.RR
+	CDSError     string `json:",omitempty"`
+	CDNSKEYError string `json:",omitempty"`
+	DNSKEYError  string `json:",omitempty"`
+}
+
+func NewCDS(s *scan.Scan, ns []structs.NSData) *CDSCheck {
+	c := &CDSCheck{
+		s:  s,
+		NS: ns,
+	}
+
+	return c
+}
+
+func (c *CDSCheck) Scan(domain string) {
+	log.Debugf("CDS: scan")
+	defer log.Debugf("CDS: scan exit")
+
+	for _, ns := range c.NS {
+		for _, nsip := range ns.IP {
+			data := CDSData{Name: ns.Name, IP: nsip.String()}
+
+			res, err := scan.Query(domain, dns.TypeCDS, nsip.String(), true)
+			if err != nil {
+				data.CDSError = err.Error()
+			} else {
+				data.CDS = extractRRMsg(res.Msg, dns.TypeCDS)
+				data.CDSSig = extractRRMsg(res.Msg, dns.TypeRRSIG)
+			}
+
+			res, err = scan.Query(domain, dns.TypeCDNSKEY, nsip.String(), true)
+			if err != nil {
+				data.CDNSKEYError = err.Error()
+			} else {
+				data.CDNSKEY = extractRRMsg(res.Msg, dns.TypeCDNSKEY)
+				data.CDNSKEYSig = extractRRMsg(res.Msg, dns.TypeRRSIG)
+			}
+
+			res, err = scan.Query(domain, dns.TypeDNSKEY, nsip.String(), true)
+			if err != nil {
+				data.DNSKEYError = err.Error()
+			} else {
+				data.DNSKEY = extractRRMsg(res.Msg, dns.TypeDNSKEY)
+			}
+
+			for _, rr := range data.DNSKEY {
+				if !containsRR(c.Keys, rr) {
+					c.Keys = append(c.Keys, rr)
+				}
+			}
+
+			c.CDS = append(c.CDS, data)
+		}
+	}
+
+	c.ParentDS = getParentDS(c.s, domain)
+}
+
+// isDeleteCDS returns true for the CDS "0 0 0 00" delete sentinel (RFC 8078 4).
+func isDeleteCDS(cds *dns.CDS) bool {
+	return cds.KeyTag == 0 && cds.Algorithm == 0 && cds.DigestType == 0 && strings.Trim(cds.Digest, "0") == ""
+}
+
+// isDeleteCDNSKEY returns true for the CDNSKEY "0 3 0 AA==" delete sentinel (RFC 8078 4).
+func isDeleteCDNSKEY(key *dns.CDNSKEY) bool {
+	return key.Flags == 0 && key.Protocol == 3 && key.Algorithm == 0 && key.PublicKey == "AA=="
+}
+
+// rrset returns the records of type t the server sent and the error of the query.
+func (d CDSData) rrset(t uint16) ([]dns.RR, string) {
+	switch t {
+	case dns.TypeCDS:
+		return d.CDS, d.CDSError
+	case dns.TypeCDNSKEY:
+		return d.CDNSKEY, d.CDNSKEYError
+	default:
+		return d.DNSKEY, d.DNSKEYError
+	}
+}
+
+// containsRR returns true if rrset has a record equal to rr, ignoring the TTL.
+func containsRR(rrset []dns.RR, rr dns.RR) bool {
+	for _, r := range rrset {
+		if dns.IsDuplicate(r, rr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func dsString(keyTag uint16, algorithm, digestType uint8, digest string) string {
+	return fmt.Sprintf("%d %d %d %s", keyTag, algorithm, digestType, strings.ToLower(digest))
+}
+
+func (c *CDSCheck) Identical() []ReportResult {
+	var results []ReportResult
+
+	for _, t := range []uint16{dns.TypeCDS, dns.TypeCDNSKEY, dns.TypeDNSKEY} {
+		m := make(map[string][]string)
+
+		for _, data := range c.CDS {
+			rrset, err := data.rrset(t)
+			if err != "" {
+				continue
+			}
+
+			rrstr := []string{}
+
+			for _, rr := range rrset {
+				rrstr = append(rrstr, rr.String()+"\n\t ")
+			}
+
+			sort.Strings(rrstr)
+			m[strings.Join(rrstr, "")] = append(m[strings.Join(rrstr, "")], data.Name+"("+data.IP+")")
+		}
+
+		res := ReportResult{Name: dns.TypeToString[t] + "Identical"}
+
+		if len(m) > 1 {
+			res.Result = fmt.Sprintf("FAIL: %s not identical", dns.TypeToString[t])
+
+			for k, v := range m {
+				res.Result += fmt.Sprintf("\t %s\n\t %s\n", v, k)
+			}
+		} else {
+			res.Result = fmt.Sprintf("OK  : %s of all nameservers are identical", dns.TypeToString[t])
+			res.Status = true
+		}
+
+		results = append(results, res)
+	}
+
+	return results
+}
+
+func (c *CDSCheck) CheckSigned() []ReportResult {
+	var results []ReportResult
+
+	ok := true
+
+	// without a DS at the parent there's no chain of trust yet, the CDS is signed
+	// by the zone's own keys for the initial enrollment (RFC 8078 3)
+	bootstrap := len(c.ParentDS) == 0
+
+	keyset := "the current DS set"
+	if bootstrap {
+		keyset = "the DNSKEY set"
+	}
+
+	for _, data := range c.CDS {
+		// the signatures must validate with the keys this server publishes
+		keys := keysInDS(data.DNSKEY, c.ParentDS)
+		if bootstrap {
+			keys = dnskeys(data.DNSKEY)
+		}
+
+		if len(data.CDS) > 0 {
+			if _, valid := verifySigs(keys, data.CDS, data.CDSSig); !valid {
+				results = append(results, ReportResult{
+					Result: fmt.Sprintf("FAIL: CDS on %s (%s) is not signed by a key in %s.", data.Name, data.IP, keyset),
+					Status: false, Name: "CDSSigned",
+				})
+				ok = false
+			}
+		}
+
+		if len(data.CDNSKEY) > 0 {
+			if _, valid := verifySigs(keys, data.CDNSKEY, data.CDNSKEYSig); !valid {
+				results = append(results, ReportResult{
+					Result: fmt.Sprintf("FAIL: CDNSKEY on %s (%s) is not signed by a key in %s.", data.Name, data.IP, keyset),
+					Status: false, Name: "CDSSigned",
+				})
+				ok = false
+			}
+		}
+	}
+
+	switch {
+	case ok && bootstrap:
+		results = append(results, ReportResult{
+			Result: "INFO: CDS/CDNSKEY are signed by a key in the DNSKEY set, no DS at parent (bootstrap/enrollment).",
+			Status: true, Name: "CDSSigned",
+		})
+	case ok:
+		results = append(results, ReportResult{
+			Result: "OK  : CDS/CDNSKEY are signed by a key in the current DS set.",
+			Status: true, Name: "CDSSigned",
+		})
+	}
+
+	return results
+}
+
+func (c *CDSCheck) Values() []ReportResult {
+	var (
+		results []ReportResult
+		cds     []dns.RR
+		cdnskey []dns.RR
+	)
+
+	for _, data := range c.CDS {
+		if len(data.CDS) > 0 && cds == nil {
+			cds = data.CDS
+		}
+
+		if len(data.CDNSKEY) > 0 && cdnskey == nil {
+			cdnskey = data.CDNSKEY
+		}
+	}
+
+	if len(cds) == 0 && len(cdnskey) == 0 {
+		return []ReportResult{{
+			Result: "INFO: No CDS/CDNSKEY records found.",
+			Status: true, Name: "CDS",
+		}}
+	}
+
+	records := []string{}
+	for _, rr := range append(cds, cdnskey...) {
+		records = append(records, rr.String())
+	}
+
+	results = append(results, ReportResult{
+		Result: "OK  : CDS/CDNSKEY records found.",
+		Status: true, Records: records, Name: "CDS",
+	})
+
+	results = append(results, c.Identical()...)
+
+	for _, rr := range cds {
+		if isDeleteCDS(rr.(*dns.CDS)) {
+			return append(results, ReportResult{
+				Result: "WARN: CDS delete sentinel found. The parent will remove all DS records and DNSSEC will be disabled.",
+				Status: false, Name: "CDSDelete",
+			})
+		}
+	}
+
+	for _, rr := range cdnskey {
+		if isDeleteCDNSKEY(rr.(*dns.CDNSKEY)) {
+			return append(results, ReportResult{
+				Result: "WARN: CDNSKEY delete sentinel found. The parent will remove all DS records and DNSSEC will be disabled.",
+				Status: false, Name: "CDSDelete",
+			})
+		}
+	}
+
+	results = append(results, c.CheckSigned()...)
+	results = append(results, c.comparePending(cds)...)
+	results = append(results, c.compareCDNSKEY(cds, cdnskey)...)
+
+	return results
+}
+
+// comparePending compares the CDS set with the DS set at the parent.
+func (c *CDSCheck) comparePending(cds []dns.RR) []ReportResult {
+	if len(cds) == 0 {
+		return []ReportResult{}
+	}
+
+	m := make(map[string]int)
+
+	for _, rr := range c.ParentDS {
+		if ds, ok := rr.(*dns.DS); ok {
+			m[dsString(ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)]--
+		}
+	}
+
+	for _, rr := range cds {
+		ds := rr.(*dns.CDS)
+		m[dsString(ds.KeyTag, ds.Algorithm, ds.DigestType, ds.Digest)]++
+	}
+
+	var add, remove []string
+
+	for k, v := range m {
+		switch {
+		case v > 0:
+			add = append(add, k)
+		case v < 0:
+			remove = append(remove, k)
+		}
+	}
+
+	sort.Strings(add)
+	sort.Strings(remove)
+
+	if len(add) == 0 && len(remove) == 0 {
+		return []ReportResult{{
+			Result: "OK  : CDS matches the DS records at the parent.",
+			Status: true, Name: "CDSPending",
+		}}
+	}
+
+	return []ReportResult{{
+		Result: fmt.Sprintf("INFO: CDS differs from the DS records at the parent, rollover pending (add %v, remove %v).", add, remove),
+		Status: true, Name: "CDSPending",
+	}}
+}
+
+// compareCDNSKEY checks that CDS and CDNSKEY describe the same keys (RFC 7344 4).
+func (c *CDSCheck) compareCDNSKEY(cds, cdnskey []dns.RR) []ReportResult {
+	if len(cds) == 0 || len(cdnskey) == 0 {
+		return []ReportResult{}
+	}
+
+	var missing []uint16
+
+	for _, rr := range cdnskey {
+		key := rr.(*dns.CDNSKEY)
+		found := false
+
+		for _, crr := range cds {
+			if dsMatchesKey(&crr.(*dns.CDS).DS, &key.DNSKEY) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			missing = append(missing, key.KeyTag())
+		}
+	}
+
+	if len(missing) > 0 {
+		return []ReportResult{{
+			Result: fmt.Sprintf("WARN: CDNSKEY records with keytag %v have no matching CDS record.", missing),
+			Status: false, Name: "CDNSKEYMatch",
+		}}
+	}
+
+	return []ReportResult{{
+		Result: "OK  : CDS and CDNSKEY records match.",
+		Status: true, Name: "CDNSKEYMatch",
+	}}
+}
+
+func (c *CDSCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "CDS"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
@@ -0,0 +1,378 @@
+package check
+
+import (
+	"crypto/x509"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// TLSA certificate usages (RFC 6698 2.1.1).
+const (
+	tlsaPKIXTA = 0
+	tlsaPKIXEE = 1
+	tlsaDANETA = 2
+	tlsaDANEEE = 3
+)
+
+var tlsaUsages = map[uint8]string{
+	tlsaPKIXTA: "PKIX-TA",
+	tlsaPKIXEE: "PKIX-EE",
+	tlsaDANETA: "DANE-TA",
+	tlsaDANEEE: "DANE-EE",
+}
+
+type DANECheck struct {
+	NS       []structs.NSData
+	MX       []DANEData
+	MXSecure bool // MX rrset is DNSSEC secure
+	Probe    bool // connect to the MX servers to verify their certificates
+	Dialer   Dialer
+	MXScan   *MXCheck // shared MX scan, nil scans the MX records again
+	Report
+	s      *scan.Scan
+	lookup func(name string, qtype uint16) (*dns.Msg, error)
+}
+
+// DANEData has the TLSA records of an MX host and the verification of the certificate
+// of every address of the host.
+type DANEData struct {
+	MX     string
+	TLSA   []dns.RR
+	Secure bool // TLSA rrset is DNSSEC secure
+	Error  string
+	Probes []DANEProbe
+}
+
+type DANEProbe struct {
+	SMTPProbe
+	Matched     []string // TLSA records that match the certificate chain
+	VerifyError string   `json:",omitempty"`
+}
+
+func NewDANE(s *scan.Scan, ns []structs.NSData, probe bool) *DANECheck {
+	c := &DANECheck{
+		s:      s,
+		NS:     ns,
+		Probe:  probe,
+		Dialer: defaultDialer(),
+	}
+
+	c.lookup = func(name string, qtype uint16) (*dns.Msg, error) {
+		res, err := scan.Query(name, qtype, s.Resolver(), true)
+		if err != nil {
+			return nil, err
+		}
+
+		return res.Msg, nil
+	}
+
+	return c
+}
+
+func (c *DANECheck) Scan(domain string) {
+	log.Debugf("DANE: scan")
+	defer log.Debugf("DANE: scan exit")
+
+	mx := sharedMX(c.s, c.NS, c.MXScan, domain)
+
+	msg, err := c.lookup(dns.Fqdn(domain), dns.TypeMX)
+	if err == nil {
+		c.MXSecure = msg.AuthenticatedData
+	}
+
+	var names []string
+
+	for name := range mx.MXIP {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		data := DANEData{MX: name}
+
+		msg, err := c.lookup("_25._tcp."+dns.Fqdn(name), dns.TypeTLSA)
+		if err != nil {
+			if !strings.Contains(err.Error(), "NXDOMAIN") {
+				data.Error = err.Error()
+			}
+
+			c.MX = append(c.MX, data)
+
+			continue
+		}
+
+		data.TLSA = extractRR(msg.Answer, dns.TypeTLSA)
+		data.Secure = msg.AuthenticatedData
+
+		if c.Probe && len(data.TLSA) > 0 {
+			for _, ip := range mx.MXIP[name] {
+				p := DANEProbe{SMTPProbe: probeSMTP(c.Dialer, ip, name)}
+				if p.TLS {
+					p.Matched, p.VerifyError = verifyTLSA(data.TLSA, p.Certs, name)
+				}
+
+				data.Probes = append(data.Probes, p)
+			}
+		}
+
+		c.MX = append(c.MX, data)
+	}
+}
+
+// tlsaMatches returns true if the selector and matching type of t match cert.
+func tlsaMatches(t *dns.TLSA, cert *x509.Certificate) bool {
+	data, err := dns.CertificateToDANE(t.Selector, t.MatchingType, cert)
+
+	return err == nil && strings.EqualFold(data, t.Certificate)
+}
+
+// tlsaString is a short form of a TLSA record for messages.
+func tlsaString(t *dns.TLSA) string {
+	data := t.Certificate
+	if len(data) > 16 {
+		data = data[:16] + "..."
+	}
+
+	return fmt.Sprintf("%d %d %d %s", t.Usage, t.Selector, t.MatchingType, data)
+}
+
+// verifyTLSA verifies the certificate chain certs of host against the TLSA records
+// (RFC 7672 3.1) and returns the records that match.
+func verifyTLSA(tlsa []dns.RR, certs []*x509.Certificate, host string) ([]string, string) {
+	var (
+		matched []string
+		errs    []string
+	)
+
+	if len(certs) == 0 {
+		return nil, "no certificate presented"
+	}
+
+	host = strings.TrimSuffix(host, ".")
+	leaf := certs[0]
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	for _, rr := range tlsa {
+		t := rr.(*dns.TLSA)
+
+		switch t.Usage {
+		case tlsaDANEEE:
+			// only the key matters, no name or expiry checks
+			if tlsaMatches(t, leaf) {
+				matched = append(matched, tlsaString(t))
+			}
+		case tlsaDANETA:
+			for _, ta := range certs {
+				if !tlsaMatches(t, ta) {
+					continue
+				}
+
+				roots := x509.NewCertPool()
+				roots.AddCert(ta)
+
+				_, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Roots: roots, Intermediates: intermediates, CurrentTime: time.Now()})
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("%s: %s", tlsaString(t), err))
+					continue
+				}
+
+				matched = append(matched, tlsaString(t))
+
+				break
+			}
+		case tlsaPKIXTA, tlsaPKIXEE:
+			chains, err := leaf.Verify(x509.VerifyOptions{DNSName: host, Intermediates: intermediates})
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s: %s", tlsaString(t), err))
+				continue
+			}
+
+			if t.Usage == tlsaPKIXEE {
+				if tlsaMatches(t, leaf) {
+					matched = append(matched, tlsaString(t))
+				}
+
+				continue
+			}
+
+		chain:
+			for _, chain := range chains {
+				for _, cert := range chain[1:] {
+					if tlsaMatches(t, cert) {
+						matched = append(matched, tlsaString(t))
+						break chain
+					}
+				}
+			}
+		default:
+			errs = append(errs, fmt.Sprintf("%s: unknown usage %d", tlsaString(t), t.Usage))
+		}
+	}
+
+	if len(matched) == 0 && len(errs) == 0 {
+		errs = append(errs, "no TLSA record matches the certificate chain")
+	}
+
+	return matched, strings.Join(errs, ", ")
+}
+
+func (c *DANECheck) Values() []ReportResult {
+	var (
+		results []ReportResult
+		without []string
+	)
+
+	dane := false
+
+	for _, data := range c.MX {
+		if len(data.TLSA) > 0 {
+			dane = true
+		} else if data.Error == "" {
+			without = append(without, data.MX)
+		}
+	}
+
+	for _, data := range c.MX {
+		if data.Error != "" {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : TLSA lookup for %s failed: %s", data.MX, data.Error),
+				Status: false, Name: "TLSA",
+			})
+		}
+	}
+
+	if !dane {
+		return append(results, ReportResult{
+			Result: "INFO: No TLSA records found for your MX records, DANE is not used.",
+			Status: true, Name: "TLSA",
+		})
+	}
+
+	if !c.MXSecure {
+		results = append(results, ReportResult{
+			Result: "FAIL: Your MX records are not DNSSEC secure, senders ignore the TLSA records of your MX hosts (RFC7672 2.2.1).",
+			Status: false, Name: "DANESecure",
+		})
+	}
+
+	if len(without) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: MX %v have no TLSA records, senders don't require DANE for them.", without),
+			Status: false, Name: "TLSA",
+		})
+	}
+
+	for _, data := range c.MX {
+		if len(data.TLSA) == 0 {
+			continue
+		}
+
+		results = append(results, c.mxValues(data)...)
+	}
+
+	return results
+}
+
+func (c *DANECheck) mxValues(data DANEData) []ReportResult {
+	var results []ReportResult
+
+	records := []string{}
+	for _, rr := range data.TLSA {
+		records = append(records, rr.String())
+	}
+
+	if data.Secure {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : DNSSEC secure TLSA records found for %s", data.MX),
+			Status: true, Records: records, Name: "TLSA",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: TLSA records for %s are not DNSSEC secure, senders ignore them.", data.MX),
+			Status: false, Records: records, Name: "DANESecure",
+		})
+	}
+
+	for _, rr := range data.TLSA {
+		t := rr.(*dns.TLSA)
+
+		switch {
+		case t.Usage == tlsaPKIXTA || t.Usage == tlsaPKIXEE:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: TLSA %s for %s uses %s, senders should not use PKIX usages for SMTP (RFC7672 3.1.3).", tlsaString(t), data.MX, tlsaUsages[t.Usage]),
+				Status: false, Name: "TLSAUsage",
+			})
+		case t.Usage == tlsaDANEEE && t.Selector == 0:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: TLSA %s for %s matches the full certificate, a renewal with the same key breaks it. Use selector 1 (SPKI).", tlsaString(t), data.MX),
+				Status: false, Name: "TLSARollover",
+			})
+		}
+	}
+
+	if len(data.TLSA) == 1 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: Only 1 TLSA record for %s: publish a TLSA record for the next key before a rollover or delivery fails (RFC7671 8.1).", data.MX),
+			Status: false, Name: "TLSARollover",
+		})
+	}
+
+	for _, p := range data.Probes {
+		switch {
+		case p.ConnectError != "":
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : DANE verification of %s (%s) failed, port 25 is not reachable: %s", data.MX, p.Addr, p.ConnectError),
+				Status: false, Name: "DANEVerify",
+			})
+		case p.Error != "" && !p.TLS:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : DANE verification of %s (%s) failed: %s", data.MX, p.Addr, p.Error),
+				Status: false, Name: "DANEVerify",
+			})
+		case !p.StartTLS:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: %s (%s) doesn't offer STARTTLS, DANE senders won't deliver.", data.MX, p.Addr),
+				Status: false, Name: "DANEVerify",
+			})
+		case len(p.Matched) == 0:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Certificate of %s (%s) doesn't match the TLSA records: %s", data.MX, p.Addr, p.VerifyError),
+				Status: false, Name: "DANEVerify",
+			})
+		default:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("OK  : Certificate of %s (%s) matches TLSA %s", data.MX, p.Addr, strings.Join(p.Matched, ", ")),
+				Status: true, Name: "DANEVerify",
+			})
+
+			if len(p.Matched) == len(data.TLSA) && len(data.TLSA) > 1 {
+				results = append(results, ReportResult{
+					Result: fmt.Sprintf("WARN: All TLSA records for %s match the current certificate, there is no record for the next key (RFC7671 8.1).", data.MX),
+					Status: false, Name: "TLSARollover",
+				})
+			}
+		}
+	}
+
+	return results
+}
+
+func (c *DANECheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "DANE"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
@@ -0,0 +1,239 @@
+package check
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testChain returns a CA and a leaf certificate for host signed by it.
+func testChain(t *testing.T, host string) (*x509.Certificate, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	leaf, _ := x509.ParseCertificate(der)
+
+	return ca, tls.Certificate{Certificate: [][]byte{der, caDER}, PrivateKey: key, Leaf: leaf}
+}
+
+// testTLSA returns a TLSA record of cert.
+func testTLSA(t *testing.T, usage, selector, matching int, cert *x509.Certificate) dns.RR {
+	t.Helper()
+
+	rr := &dns.TLSA{Hdr: dns.RR_Header{Name: "_25._tcp.mx.example.com.", Rrtype: dns.TypeTLSA, Class: dns.ClassINET, Ttl: 300}}
+	if err := rr.Sign(usage, selector, matching, cert); err != nil {
+		t.Fatal(err)
+	}
+
+	return rr
+}
+
+// fakeSMTP is a Dialer that connects to an SMTP server in the test instead of port 25.
+type fakeSMTP struct {
+	banner string           // greeting, empty closes the connection without one
+	cert   *tls.Certificate // offered after STARTTLS, nil doesn't advertise STARTTLS
+	err    error            // returned by DialContext
+}
+
+func (f *fakeSMTP) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+
+	client, server := net.Pipe()
+
+	go f.serve(server)
+
+	return client, nil
+}
+
+func (f *fakeSMTP) serve(conn net.Conn) {
+	defer conn.Close()
+
+	if f.banner == "" {
+		return
+	}
+
+	tp := textproto.NewConn(conn)
+	_ = tp.PrintfLine("220 %s", f.banner)
+
+	if _, err := tp.ReadLine(); err != nil {
+		return
+	}
+
+	if f.cert == nil {
+		_ = tp.PrintfLine("250 hello")
+		_, _ = tp.ReadLine()
+
+		return
+	}
+
+	_ = tp.PrintfLine("250-hello")
+	_ = tp.PrintfLine("250 STARTTLS")
+
+	if _, err := tp.ReadLine(); err != nil {
+		return
+	}
+
+	_ = tp.PrintfLine("220 ready")
+
+	tc := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*f.cert}})
+	if err := tc.Handshake(); err != nil {
+		return
+	}
+
+	_, _ = textproto.NewConn(tc).ReadLine()
+}
+
+func TestVerifyTLSA(t *testing.T) {
+	ca, cert := testChain(t, "mx.example.com")
+	other, _ := testChain(t, "mx.example.com")
+
+	chain := []*x509.Certificate{cert.Leaf, ca}
+
+	tests := []struct {
+		name    string
+		tlsa    []dns.RR
+		certs   []*x509.Certificate
+		host    string
+		matched int
+		err     string
+	}{
+		{name: "DANE-EE SPKI", tlsa: []dns.RR{testTLSA(t, 3, 1, 1, cert.Leaf)}, certs: chain, host: "mx.example.com.", matched: 1},
+		{name: "DANE-EE full cert SHA-512", tlsa: []dns.RR{testTLSA(t, 3, 0, 2, cert.Leaf)}, certs: chain, host: "mx.example.com", matched: 1},
+		{name: "DANE-EE ignores the name", tlsa: []dns.RR{testTLSA(t, 3, 1, 1, cert.Leaf)}, certs: chain, host: "other.example.com", matched: 1},
+		{name: "DANE-EE other key", tlsa: []dns.RR{testTLSA(t, 3, 1, 1, other)}, certs: chain, host: "mx.example.com", err: "no TLSA record matches"},
+		{name: "DANE-TA", tlsa: []dns.RR{testTLSA(t, 2, 0, 1, ca)}, certs: chain, host: "mx.example.com", matched: 1},
+		{name: "DANE-TA wrong name", tlsa: []dns.RR{testTLSA(t, 2, 0, 1, ca)}, certs: chain, host: "other.example.com", err: "other.example.com"},
+		{name: "DANE-TA other CA", tlsa: []dns.RR{testTLSA(t, 2, 0, 1, other)}, certs: chain, host: "mx.example.com", err: "no TLSA record matches"},
+		{name: "PKIX-EE untrusted", tlsa: []dns.RR{testTLSA(t, 1, 1, 1, cert.Leaf)}, certs: chain, host: "mx.example.com", err: "unknown authority"},
+		{name: "one of two", tlsa: []dns.RR{testTLSA(t, 3, 1, 1, other), testTLSA(t, 3, 1, 1, cert.Leaf)}, certs: chain, host: "mx.example.com", matched: 1},
+		{name: "no certificate", tlsa: []dns.RR{testTLSA(t, 3, 1, 1, cert.Leaf)}, host: "mx.example.com", err: "no certificate presented"},
+	}
+
+	for _, tt := range tests {
+		matched, err := verifyTLSA(tt.tlsa, tt.certs, tt.host)
+
+		if len(matched) != tt.matched {
+			t.Errorf("%s: matched %v, want %d (%s)", tt.name, matched, tt.matched, err)
+		}
+
+		if tt.err != "" && !strings.Contains(err, tt.err) {
+			t.Errorf("%s: error %q, want %q", tt.name, err, tt.err)
+		}
+
+		if tt.err == "" && err != "" {
+			t.Errorf("%s: unexpected error %q", tt.name, err)
+		}
+	}
+}
+
+func TestDANEScan(t *testing.T) {
+	_, cert := testChain(t, "mx.example.com")
+	_, other := testChain(t, "mx.example.com")
+
+	tlsa := testTLSA(t, 3, 1, 1, cert.Leaf)
+
+	// the MX scan is shared, a scanned MXCheck is not scanned again
+	mx := &MXCheck{
+		MXIP:    map[string][]net.IP{"mx.example.com.": {net.ParseIP("192.0.2.25")}},
+		scanned: "example.com",
+	}
+
+	lookup := func(name string, qtype uint16) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.AuthenticatedData = true
+
+		if qtype == dns.TypeTLSA && name == "_25._tcp.mx.example.com." {
+			m.Answer = []dns.RR{tlsa}
+		}
+
+		return m, nil
+	}
+
+	tests := []struct {
+		name   string
+		dialer *fakeSMTP
+		want   string
+	}{
+		{"match", &fakeSMTP{banner: "mx.example.com ESMTP", cert: &cert}, "OK  : Certificate of mx.example.com."},
+		{"other key", &fakeSMTP{banner: "mx.example.com ESMTP", cert: &other}, "FAIL: Certificate of mx.example.com."},
+		{"no STARTTLS", &fakeSMTP{banner: "mx.example.com ESMTP"}, "FAIL: mx.example.com. (192.0.2.25:25) doesn't offer STARTTLS"},
+		{"unreachable", &fakeSMTP{err: errors.New("connection refused")}, "ERR : DANE verification of mx.example.com. (192.0.2.25:25) failed, port 25 is not reachable"},
+	}
+
+	for _, tt := range tests {
+		c := &DANECheck{Probe: true, Dialer: tt.dialer, MXScan: mx, lookup: lookup}
+		c.Scan("example.com")
+
+		if len(c.MX) != 1 || len(c.MX[0].TLSA) != 1 || !c.MX[0].Secure || !c.MXSecure {
+			t.Fatalf("%s: scan = %+v", tt.name, c.MX)
+		}
+
+		found := false
+
+		for _, res := range c.Values() {
+			if strings.HasPrefix(res.Result, tt.want) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("%s: no result %q in %v", tt.name, tt.want, c.Values())
+		}
+	}
+}
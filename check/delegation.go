@@ -0,0 +1,229 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+// Delegation is the referral for a zone as one parent nameserver ip sends it.
+type Delegation struct {
+	Name  string // parent nameserver
+	IP    string
+	NS    []dns.RR
+	Glue  []dns.RR // A and AAAA records in the additional section
+	Error string   `json:",omitempty"`
+}
+
+// parentDelegations asks every IPv4 and IPv6 address of every parent nameserver for
+// the NS records of domain.
+func parentDelegations(s *scan.Scan, domain string) ([]Delegation, error) {
+	var delegations []Delegation
+
+	parent := getParentDomain(dns.Fqdn(domain))
+	log.Debugf("Finding NS of parent: %s", parent)
+
+	nsdata, err := s.FindNS(parent)
+	if err != nil {
+		return delegations, err
+	}
+
+	for _, ns := range nsdata {
+		for _, nsip := range ns.IP {
+			d := Delegation{Name: ns.Name, IP: nsip.String()}
+
+			log.Debugf("Asking parent %s (%s) NS of %s", nsip, parent, domain)
+
+			res, err := scan.Query(dns.Fqdn(domain), dns.TypeNS, nsip.String(), true)
+			if err != nil {
+				d.Error = err.Error()
+				delegations = append(delegations, d)
+
+				continue
+			}
+
+			d.NS = extractRR(append(res.Msg.Answer, res.Msg.Ns...), dns.TypeNS)
+			d.Glue = extractRR(res.Msg.Extra, dns.TypeA, dns.TypeAAAA)
+			delegations = append(delegations, d)
+		}
+	}
+
+	return delegations, nil
+}
+
+// DelegationScan asks the parent nameservers for the delegation of a domain once, so
+// the checks that need it can share the answers.
+type DelegationScan struct {
+	s           *scan.Scan
+	domain      string
+	delegations []Delegation
+	err         error
+}
+
+func NewDelegationScan(s *scan.Scan) *DelegationScan {
+	return &DelegationScan{s: s}
+}
+
+// Delegations returns the delegations of domain, the parent is only asked the first
+// time.
+func (p *DelegationScan) Delegations(domain string) ([]Delegation, error) {
+	if p.domain != dns.Fqdn(domain) {
+		p.domain = dns.Fqdn(domain)
+		p.delegations, p.err = parentDelegations(p.s, domain)
+	}
+
+	return p.delegations, p.err
+}
+
+// sharedDelegations returns the delegations of domain from p, or asks the parent if
+// p is nil.
+func sharedDelegations(s *scan.Scan, p *DelegationScan, domain string) ([]Delegation, error) {
+	if p == nil {
+		return parentDelegations(s, domain)
+	}
+
+	return p.Delegations(domain)
+}
+
+// server is the name and ip of the parent server for messages.
+func (d Delegation) server() string {
+	return fmt.Sprintf("%s(%s)", d.Name, d.IP)
+}
+
+// nsKey returns the NS names of the delegation, sorted.
+func (d Delegation) nsKey() string {
+	var names []string
+
+	for _, rr := range d.NS {
+		names = append(names, strings.ToLower(rr.(*dns.NS).Ns))
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, " ")
+}
+
+// glueKey returns the glue of the delegation as "name ip", sorted.
+func (d Delegation) glueKey() string {
+	var glue []string
+
+	for _, rr := range d.Glue {
+		for _, ip := range extractIP([]dns.RR{rr}) {
+			glue = append(glue, strings.ToLower(rr.Header().Name)+" "+ip.String())
+		}
+	}
+
+	sort.Strings(glue)
+
+	return strings.Join(glue, ", ")
+}
+
+// ttlKey returns the TTLs of the NS and glue records of the delegation.
+func (d Delegation) ttlKey() string {
+	ttls := make(map[string]bool)
+
+	for _, rr := range append(append([]dns.RR{}, d.NS...), d.Glue...) {
+		ttls[fmt.Sprintf("%s %d", dns.TypeToString[rr.Header().Rrtype], rr.Header().Ttl)] = true
+	}
+
+	var keys []string
+	for k := range ttls {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	return strings.Join(keys, ", ")
+}
+
+// divergence groups the parent servers by key and returns a result if they don't
+// all agree.
+func divergence(delegations []Delegation, key func(Delegation) string, name, what string) ReportResult {
+	var keys []string
+
+	m := make(map[string][]string)
+
+	for _, d := range delegations {
+		if d.Error != "" {
+			continue
+		}
+
+		k := key(d)
+		if m[k] == nil {
+			keys = append(keys, k)
+		}
+
+		m[k] = append(m[k], d.server())
+	}
+
+	if len(m) > 1 {
+		res := ReportResult{Result: fmt.Sprintf("FAIL: Parent nameservers send different %s", what), Status: false, Name: name}
+
+		for _, k := range keys {
+			value := k
+			if value == "" {
+				value = "none"
+			}
+
+			res.Records = append(res.Records, fmt.Sprintf("%v: %s", m[k], value))
+		}
+
+		return res
+	}
+
+	return ReportResult{Result: fmt.Sprintf("OK  : All parent nameservers send the same %s", what), Status: true, Name: name}
+}
+
+// delegationValues reports parent servers that failed and inconsistencies between
+// the NS records, glue and TTLs they send.
+func delegationValues(delegations []Delegation) []ReportResult {
+	var (
+		results []ReportResult
+		failed6 []string
+		ok6     bool
+	)
+
+	for _, d := range delegations {
+		v6 := net.ParseIP(d.IP).To4() == nil
+
+		switch {
+		case d.Error != "" && v6:
+			failed6 = append(failed6, d.server())
+		case d.Error != "":
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : Parent nameserver %s failed: %s", d.server(), d.Error),
+				Status: false, Name: "ParentServer",
+			})
+		case len(d.NS) == 0:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Parent nameserver %s sends no NS records for the delegation.", d.server()),
+				Status: false, Name: "ParentServer",
+			})
+		case v6:
+			ok6 = true
+		}
+	}
+
+	switch {
+	case len(failed6) > 0 && !ok6:
+		results = append(results, ReportResult{
+			Result: "WARN: None of the parent nameservers answered over IPv6, is IPv6 available?",
+			Status: false, Records: failed6, Name: "ParentServer",
+		})
+	case len(failed6) > 0:
+		results = append(results, ReportResult{
+			Result: "ERR : Parent nameservers failed over IPv6.",
+			Status: false, Records: failed6, Name: "ParentServer",
+		})
+	}
+
+	results = append(results, divergence(delegations, Delegation.nsKey, "ParentNS", "NS records"))
+	results = append(results, divergence(delegations, Delegation.glueKey, "ParentGlue", "glue records"))
+	results = append(results, divergence(delegations, Delegation.ttlKey, "ParentTTL", "TTLs for NS and glue records"))
+
+	return results
+}
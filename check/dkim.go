@@ -0,0 +1,350 @@
+package check
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// DKIMSelectors are the selectors that are tried by default. Selectors can't be
+// enumerated, these are the defaults of common mail providers and software.
+var DKIMSelectors = []string{
+	"default", "dkim", "mail", "email", "smtp", "key1", "key2", "k1", "k2", "k3",
+	"s1", "s2", "s1024", "s2048", "selector1", "selector2", "google", "googleapps",
+	"dk", "api", "cm", "mesmtp", "mandrill", "mailjet", "mxvault", "pm", "fm1", "fm2",
+	"fm3", "protonmail", "protonmail2", "protonmail3", "zendesk1", "zendesk2",
+	"everlytickey1", "everlytickey2", "sig1", "mailo", "hs1", "hs2", "smtpapi",
+}
+
+const (
+	dkimMinBits         = 1024 // RFC 8301 3.2, shorter keys must not be used
+	dkimRecommendedBits = 2048
+)
+
+type DKIMCheck struct {
+	NS        []structs.NSData
+	Selectors []string
+	Keys      []DKIMKey
+	Report
+	s      *scan.Scan
+	lookup func(name string, qtype uint16) ([]dns.RR, error)
+}
+
+// DKIMKey is a parsed DKIM key record (RFC 6376 3.6.1).
+type DKIMKey struct {
+	Selector string
+	Name     string
+	CNAME    string `json:",omitempty"` // target if the selector is delegated with a CNAME
+	Record   string
+	KeyType  string
+	Bits     int
+	Hash     []string
+	Services []string
+	Flags    []string
+	Revoked  bool
+	Dangling bool
+	Error    string `json:",omitempty"`
+}
+
+// NewDKIM returns a DKIM checker that tries DKIMSelectors and the extra selectors.
+func NewDKIM(s *scan.Scan, ns []structs.NSData, selectors ...string) *DKIMCheck {
+	c := &DKIMCheck{
+		s:  s,
+		NS: ns,
+	}
+
+	seen := make(map[string]bool)
+
+	for _, sel := range append(append([]string{}, DKIMSelectors...), selectors...) {
+		sel = strings.ToLower(strings.TrimSpace(sel))
+		if sel != "" && !seen[sel] {
+			seen[sel] = true
+			c.Selectors = append(c.Selectors, sel)
+		}
+	}
+
+	if s != nil {
+		c.lookup = resolverLookup(s)
+	}
+
+	return c
+}
+
+func (c *DKIMCheck) Scan(domain string) {
+	log.Debugf("DKIM: scan")
+	defer log.Debugf("DKIM: scan exit")
+
+	for _, sel := range c.Selectors {
+		name := sel + "._domainkey." + dns.Fqdn(domain)
+
+		txt, err := c.lookup(name, dns.TypeTXT)
+		if err != nil {
+			c.Report.Result = append(c.Report.Result, ReportResult{Result: fmt.Sprintf("ERR : DKIM scan failed for %s: %s", name, err)})
+			continue
+		}
+
+		cname, _ := c.lookup(name, dns.TypeCNAME)
+
+		key := DKIMKey{Selector: sel, Name: name}
+		if len(cname) > 0 {
+			key.CNAME = cname[0].(*dns.CNAME).Target
+		}
+
+		var records []string
+
+		for _, rr := range txt {
+			if isDKIM(txtString(rr)) {
+				records = append(records, txtString(rr))
+			}
+		}
+
+		switch {
+		case len(records) == 0 && key.CNAME == "":
+			continue
+		case len(records) == 0:
+			key.Dangling = true
+		case len(records) > 1:
+			key.Record = strings.Join(records, " | ")
+			key.Error = "multiple key records"
+		default:
+			key.parse(records[0])
+		}
+
+		c.Keys = append(c.Keys, key)
+	}
+}
+
+// isDKIM returns true if the TXT record looks like a DKIM key record: it starts with
+// v=DKIM1 or has a p= tag.
+func isDKIM(txt string) bool {
+	for i, tag := range strings.Split(txt, ";") {
+		kv := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+
+		switch strings.TrimSpace(kv[0]) {
+		case "v":
+			return i == 0 && len(kv) == 2 && strings.TrimSpace(kv[1]) == "DKIM1"
+		case "p":
+			return true
+		}
+	}
+
+	return false
+}
+
+// parse parses the tags of a DKIM key record and the public key.
+func (k *DKIMKey) parse(record string) {
+	k.Record = record
+	k.KeyType = "rsa"
+	k.Services = []string{"*"}
+
+	var (
+		p     string
+		found bool
+	)
+
+	for i, tag := range strings.Split(record, ";") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			k.Error = fmt.Sprintf("invalid tag %s", tag)
+			return
+		}
+
+		name, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch name {
+		case "v":
+			if i != 0 || value != "DKIM1" {
+				k.Error = fmt.Sprintf("v=%s must be the first tag and DKIM1", value)
+				return
+			}
+		case "k":
+			k.KeyType = value
+		case "h":
+			k.Hash = splitColon(value)
+		case "s":
+			k.Services = splitColon(value)
+		case "t":
+			k.Flags = splitColon(value)
+		case "p":
+			p, found = strings.Join(strings.Fields(value), ""), true
+		}
+	}
+
+	if !found {
+		k.Error = "no p= tag found"
+		return
+	}
+
+	if p == "" {
+		k.Revoked = true
+		return
+	}
+
+	der, err := base64.StdEncoding.DecodeString(p)
+	if err != nil {
+		k.Error = fmt.Sprintf("p= is not valid base64: %s", err)
+		return
+	}
+
+	switch k.KeyType {
+	case "rsa":
+		pub, err := x509.ParsePKIXPublicKey(der)
+		if err != nil {
+			// some signers publish a PKCS#1 RSAPublicKey instead of SubjectPublicKeyInfo
+			rsaPub, err2 := x509.ParsePKCS1PublicKey(der)
+			if err2 != nil {
+				k.Error = fmt.Sprintf("invalid RSA public key: %s", err)
+				return
+			}
+
+			pub = rsaPub
+		}
+
+		rsaPub, ok := pub.(*rsa.PublicKey)
+		if !ok {
+			k.Error = fmt.Sprintf("k=rsa but p= is a %T", pub)
+			return
+		}
+
+		k.Bits = rsaPub.N.BitLen()
+	case "ed25519":
+		if len(der) != ed25519.PublicKeySize {
+			k.Error = fmt.Sprintf("invalid ed25519 public key length %d", len(der))
+			return
+		}
+
+		k.Bits = 256
+	default:
+		k.Error = fmt.Sprintf("unknown key type k=%s", k.KeyType)
+	}
+}
+
+func splitColon(value string) []string {
+	var out []string
+
+	for _, v := range strings.Split(value, ":") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+
+	return out
+}
+
+func (c *DKIMCheck) Values() []ReportResult {
+	var results []ReportResult
+
+	if len(c.Keys) == 0 {
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("INFO: No DKIM keys found for the %d common selectors. Use -dkim to add your selectors.", len(c.Selectors)),
+			Status: true, Name: "DKIM",
+		})
+	}
+
+	sort.Slice(c.Keys, func(i, j int) bool { return c.Keys[i].Selector < c.Keys[j].Selector })
+
+	for _, k := range c.Keys {
+		var records []string
+		if k.Record != "" {
+			records = []string{fmt.Sprintf("%s TXT %q", k.Name, k.Record)}
+		}
+
+		if k.CNAME != "" {
+			records = append([]string{fmt.Sprintf("%s CNAME %s", k.Name, k.CNAME)}, records...)
+		}
+
+		switch {
+		case k.Dangling:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: DKIM selector %s is a CNAME to %s which has no DKIM key.", k.Selector, k.CNAME),
+				Status: false, Records: records, Name: "DKIMDangling",
+			})
+
+			continue
+		case k.Error != "":
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: DKIM selector %s has an invalid key record: %s", k.Selector, k.Error),
+				Status: false, Records: records, Name: "DKIMSyntax",
+			})
+
+			continue
+		case k.Revoked:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: DKIM selector %s is revoked (empty p=).", k.Selector),
+				Status: false, Records: records, Name: "DKIMRevoked",
+			})
+
+			continue
+		}
+
+		if k.KeyType == "rsa" {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("OK  : DKIM selector %s has a %d bits RSA key.", k.Selector, k.Bits),
+				Status: true, Records: records, Name: "DKIM",
+			})
+		} else {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("OK  : DKIM selector %s has an %s key.", k.Selector, k.KeyType),
+				Status: true, Records: records, Name: "DKIM",
+			})
+		}
+
+		switch {
+		case k.KeyType == "rsa" && k.Bits < dkimMinBits:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: DKIM selector %s has a %d bits RSA key, receivers ignore keys shorter than %d bits (RFC8301 3.2).", k.Selector, k.Bits, dkimMinBits),
+				Status: false, Name: "DKIMKeySize",
+			})
+		case k.KeyType == "rsa" && k.Bits < dkimRecommendedBits:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: DKIM selector %s has a %d bits RSA key, %d bits is recommended.", k.Selector, k.Bits, dkimRecommendedBits),
+				Status: false, Name: "DKIMKeySize",
+			})
+		}
+
+		if hasValue(k.Flags, "y") {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: DKIM selector %s is in testing mode (t=y), receivers treat signed mail as unsigned.", k.Selector),
+				Status: false, Name: "DKIMTesting",
+			})
+		}
+
+		if len(k.Hash) > 0 && !hasValue(k.Hash, "sha256") {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: DKIM selector %s only allows h=%s, sha1 must not be used (RFC8301 3.1).", k.Selector, strings.Join(k.Hash, ":")),
+				Status: false, Name: "DKIMHash",
+			})
+		}
+
+		if !hasValue(k.Services, "*") && !hasValue(k.Services, "email") {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: DKIM selector %s can't be used for email (s=%s).", k.Selector, strings.Join(k.Services, ":")),
+				Status: false, Name: "DKIMService",
+			})
+		}
+	}
+
+	return results
+}
+
+func (c *DKIMCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "DKIM"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
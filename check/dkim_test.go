@@ -0,0 +1,145 @@
+package check
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+// testRSAKey returns the base64 SubjectPublicKeyInfo of a new RSA key of bits.
+func testRSAKey(t *testing.T, bits int) string {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func TestDKIMKeyParse(t *testing.T) {
+	rsa1024 := testRSAKey(t, 1024)
+	rsa2048 := testRSAKey(t, 2048)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pkcs1 := base64.StdEncoding.EncodeToString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ed := base64.StdEncoding.EncodeToString(edPub)
+
+	tests := []struct {
+		record   string
+		keyType  string
+		bits     int
+		flags    []string
+		services []string
+		revoked  bool
+		err      string
+	}{
+		{record: "v=DKIM1; k=rsa; p=" + rsa2048, keyType: "rsa", bits: 2048, services: []string{"*"}},
+		{record: "p=" + rsa1024, keyType: "rsa", bits: 1024, services: []string{"*"}},
+		{record: "v=DKIM1; p=" + rsa1024[:40] + " " + rsa1024[40:], keyType: "rsa", bits: 1024, services: []string{"*"}},
+		{record: "v=DKIM1; p=" + pkcs1, keyType: "rsa", bits: 1024, services: []string{"*"}},
+		{record: "v=DKIM1; k=ed25519; p=" + ed, keyType: "ed25519", bits: 256, services: []string{"*"}},
+		{record: "v=DKIM1; t=y:s; s=email; p=" + rsa2048, keyType: "rsa", bits: 2048, flags: []string{"y", "s"}, services: []string{"email"}},
+		{record: "v=DKIM1; p=", keyType: "rsa", services: []string{"*"}, revoked: true},
+		{record: "v=DKIM1; k=rsa", err: "no p= tag found"},
+		{record: "k=rsa; v=DKIM1; p=" + rsa2048, err: "must be the first tag"},
+		{record: "v=DKIM2; p=" + rsa2048, err: "must be the first tag"},
+		{record: "v=DKIM1; p=!!!", err: "not valid base64"},
+		{record: "v=DKIM1; k=rsa; p=" + ed, err: "invalid RSA public key"},
+		{record: "v=DKIM1; k=ed25519; p=" + rsa2048, err: "invalid ed25519 public key length"},
+		{record: "v=DKIM1; k=dsa; p=" + rsa2048, err: "unknown key type k=dsa"},
+		{record: "v=DKIM1; foo; p=" + rsa2048, err: "invalid tag foo"},
+	}
+
+	for _, tt := range tests {
+		var k DKIMKey
+
+		k.parse(tt.record)
+
+		if tt.err != "" {
+			if !strings.Contains(k.Error, tt.err) {
+				t.Errorf("parse(%.40q) error %q, want %q", tt.record, k.Error, tt.err)
+			}
+
+			continue
+		}
+
+		if k.Error != "" || k.KeyType != tt.keyType || k.Bits != tt.bits || k.Revoked != tt.revoked {
+			t.Errorf("parse(%.40q) = %s %d bits revoked %v (%s), want %s %d bits revoked %v", tt.record, k.KeyType, k.Bits, k.Revoked, k.Error, tt.keyType, tt.bits, tt.revoked)
+		}
+
+		if strings.Join(k.Flags, ":") != strings.Join(tt.flags, ":") || strings.Join(k.Services, ":") != strings.Join(tt.services, ":") {
+			t.Errorf("parse(%.40q) flags %v services %v, want %v %v", tt.record, k.Flags, k.Services, tt.flags, tt.services)
+		}
+	}
+}
+
+func TestDKIMScan(t *testing.T) {
+	key := testRSAKey(t, 2048)
+
+	c := &DKIMCheck{
+		Selectors: []string{"valid", "dangling", "delegated", "missing", "other"},
+		lookup: fakeLookup(t,
+			`valid._domainkey.example.com. 300 IN TXT "v=DKIM1; p=`+key+`"`,
+			`dangling._domainkey.example.com. 300 IN CNAME dkim.example.net.`,
+			`delegated._domainkey.example.com. 300 IN CNAME dkim.example.org.`,
+			`delegated._domainkey.example.com. 300 IN TXT "v=DKIM1; p=`+key+`"`,
+			`other._domainkey.example.com. 300 IN TXT "google-site-verification=abc"`,
+		),
+	}
+
+	c.Scan("example.com")
+
+	keys := make(map[string]DKIMKey)
+	for _, k := range c.Keys {
+		keys[k.Selector] = k
+	}
+
+	if len(c.Keys) != 3 {
+		t.Fatalf("keys = %+v, want valid, dangling and delegated", c.Keys)
+	}
+
+	if k := keys["valid"]; k.Dangling || k.Bits != 2048 || k.CNAME != "" {
+		t.Errorf("valid = %+v", k)
+	}
+
+	if k := keys["dangling"]; !k.Dangling || k.CNAME != "dkim.example.net." {
+		t.Errorf("dangling = %+v", k)
+	}
+
+	if k := keys["delegated"]; k.Dangling || k.Bits != 2048 || k.CNAME != "dkim.example.org." {
+		t.Errorf("delegated = %+v", k)
+	}
+
+	found := false
+
+	for _, res := range c.Values() {
+		if strings.HasPrefix(res.Result, "FAIL: DKIM selector dangling is a CNAME to dkim.example.net. which has no DKIM key.") {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Errorf("no dangling result in %v", c.Values())
+	}
+}
@@ -0,0 +1,434 @@
+package check
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+// maxDMARCWalk is the maximum number of parents that are asked for a DMARC record.
+const maxDMARCWalk = 8
+
+// DMARCRecord is a parsed DMARC record (RFC 7489 6.3). Tags that are not in the
+// record have their default value.
+type DMARCRecord struct {
+	Text            string
+	Policy          string
+	SubdomainPolicy string
+	Pct             int
+	ADKIM           string
+	ASPF            string
+	RUA             []DMARCURI
+	RUF             []DMARCURI
+	FO              []string
+	RF              []string
+	RI              int
+	Warnings        []string // problems that don't invalidate the record
+}
+
+// DMARCURI is a report destination of the rua or ruf tag.
+type DMARCURI struct {
+	URI     string
+	Address string // mailbox of a mailto URI
+	Host    string
+	Limit   string `json:",omitempty"` // maximum report size
+}
+
+// DMARCAuth is the result of the external destination verification of a report URI
+// (RFC 7489 7.1).
+type DMARCAuth struct {
+	URI        string
+	Query      string
+	Authorized bool
+	Error      string `json:",omitempty"`
+}
+
+// DMARC looks up DMARC records.
+type DMARC struct {
+	Lookup func(name string, qtype uint16) ([]dns.RR, error)
+}
+
+func NewDMARC(s *scan.Scan) *DMARC {
+	return &DMARC{Lookup: resolverLookup(s)}
+}
+
+// isDMARC returns true if the TXT record starts with v=DMARC1.
+func isDMARC(txt string) bool {
+	tag := strings.SplitN(txt, ";", 2)[0]
+
+	return strings.EqualFold(strings.ReplaceAll(tag, " ", ""), "v=DMARC1")
+}
+
+// dmarcRecords returns the TXT records that are DMARC records.
+func dmarcRecords(txt []dns.RR) []dns.RR {
+	dmarc := []dns.RR{}
+
+	for _, rr := range txt {
+		if isDMARC(txtString(rr)) {
+			dmarc = append(dmarc, rr)
+		}
+	}
+
+	return dmarc
+}
+
+// ParseDMARC parses a DMARC record. It returns an error if receivers would discard
+// the record.
+func ParseDMARC(record string) (*DMARCRecord, error) {
+	rec := &DMARCRecord{
+		Text:  record,
+		Pct:   100,
+		ADKIM: "r",
+		ASPF:  "r",
+		FO:    []string{"0"},
+		RF:    []string{"afrf"},
+		RI:    86400,
+	}
+
+	tags := strings.Split(record, ";")
+	if !isDMARC(record) {
+		return rec, fmt.Errorf("record does not start with v=DMARC1")
+	}
+
+	seen := make(map[string]bool)
+
+	for _, tag := range tags[1:] {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			return rec, fmt.Errorf("invalid tag %s", tag)
+		}
+
+		name, value := strings.ToLower(strings.TrimSpace(kv[0])), strings.TrimSpace(kv[1])
+
+		if seen[name] {
+			return rec, fmt.Errorf("tag %s appears more than once", name)
+		}
+
+		seen[name] = true
+
+		if err := rec.parseTag(name, value); err != nil {
+			return rec, err
+		}
+	}
+
+	if rec.Policy == "" {
+		if len(rec.RUA) == 0 {
+			return rec, fmt.Errorf("no p= tag found")
+		}
+
+		// RFC 7489 6.6.3: a record without p= but with a valid rua= is treated as p=none
+		rec.Policy = "none"
+		rec.Warnings = append(rec.Warnings, "no p= tag found, receivers treat the record as p=none")
+	}
+
+	if rec.SubdomainPolicy == "" {
+		rec.SubdomainPolicy = rec.Policy
+	}
+
+	return rec, nil
+}
+
+func (rec *DMARCRecord) parseTag(name, value string) error {
+	var err error
+
+	switch name {
+	case "v":
+		return fmt.Errorf("v=%s is not the first tag", value)
+	case "p", "sp":
+		value = strings.ToLower(value)
+		switch value {
+		case "none", "quarantine", "reject":
+		default:
+			return fmt.Errorf("invalid policy %s=%s", name, value)
+		}
+
+		if name == "p" {
+			rec.Policy = value
+		} else {
+			rec.SubdomainPolicy = value
+		}
+	case "pct":
+		rec.Pct, err = strconv.Atoi(value)
+		if err != nil || rec.Pct < 0 || rec.Pct > 100 {
+			return fmt.Errorf("invalid pct=%s, must be between 0 and 100", value)
+		}
+	case "adkim", "aspf":
+		value = strings.ToLower(value)
+		if value != "r" && value != "s" {
+			return fmt.Errorf("invalid alignment %s=%s, must be r or s", name, value)
+		}
+
+		if name == "adkim" {
+			rec.ADKIM = value
+		} else {
+			rec.ASPF = value
+		}
+	case "rua", "ruf":
+		uris, err := parseDMARCURIs(value)
+		if err != nil {
+			return fmt.Errorf("%s=%s: %s", name, value, err)
+		}
+
+		for _, u := range uris {
+			if u.Address == "" {
+				rec.Warnings = append(rec.Warnings, fmt.Sprintf("%s=%s is not a mailto URI, receivers are only required to support mailto", name, u.URI))
+			}
+		}
+
+		if name == "rua" {
+			rec.RUA = uris
+		} else {
+			rec.RUF = uris
+		}
+	case "fo":
+		rec.FO = strings.Split(value, ":")
+		for _, fo := range rec.FO {
+			switch strings.TrimSpace(fo) {
+			case "0", "1", "d", "s":
+			default:
+				return fmt.Errorf("invalid fo=%s", value)
+			}
+		}
+	case "rf":
+		rec.RF = strings.Split(strings.ToLower(value), ":")
+		for _, rf := range rec.RF {
+			if strings.TrimSpace(rf) != "afrf" {
+				rec.Warnings = append(rec.Warnings, fmt.Sprintf("unknown report format rf=%s", rf))
+			}
+		}
+	case "ri":
+		ri, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid ri=%s", value)
+		}
+
+		rec.RI = int(ri)
+	default:
+		rec.Warnings = append(rec.Warnings, fmt.Sprintf("unknown tag %s is ignored", name))
+	}
+
+	return nil
+}
+
+// parseDMARCURIs parses a comma separated list of DMARC URIs (RFC 7489 6.2).
+func parseDMARCURIs(value string) ([]DMARCURI, error) {
+	var uris []DMARCURI
+
+	for _, raw := range strings.Split(value, ",") {
+		raw = strings.TrimSpace(raw)
+		u := DMARCURI{URI: raw}
+
+		if i := strings.LastIndexByte(raw, '!'); i >= 0 {
+			u.URI, u.Limit = raw[:i], raw[i+1:]
+
+			limit := strings.TrimRight(strings.ToLower(u.Limit), "kmgt")
+			if len(u.Limit)-len(limit) > 1 {
+				return nil, fmt.Errorf("invalid size limit %s", u.Limit)
+			}
+
+			if _, err := strconv.ParseUint(limit, 10, 64); err != nil {
+				return nil, fmt.Errorf("invalid size limit %s", u.Limit)
+			}
+		}
+
+		parsed, err := url.Parse(u.URI)
+		if err != nil || parsed.Scheme == "" {
+			return nil, fmt.Errorf("invalid URI %s", u.URI)
+		}
+
+		if strings.EqualFold(parsed.Scheme, "mailto") {
+			u.Address = parsed.Opaque
+
+			i := strings.LastIndexByte(u.Address, '@')
+			if i <= 0 || i == len(u.Address)-1 {
+				return nil, fmt.Errorf("invalid mailto address %s", u.URI)
+			}
+
+			u.Host = dns.Fqdn(strings.ToLower(u.Address[i+1:]))
+		} else {
+			u.Host = dns.Fqdn(strings.ToLower(parsed.Hostname()))
+		}
+
+		uris = append(uris, u)
+	}
+
+	return uris, nil
+}
+
+// records returns the DMARC records at _dmarc.domain.
+func (d *DMARC) records(domain string) ([]dns.RR, error) {
+	txt, err := d.Lookup("_dmarc."+dns.Fqdn(domain), dns.TypeTXT)
+	if err != nil {
+		return nil, err
+	}
+
+	return dmarcRecords(txt), nil
+}
+
+// OrgDomain returns the organizational domain of domain. Without a public suffix
+// list this is the parent with the fewest labels (but at least 2) that has a DMARC
+// record, like the tree walk of DMARCbis. If no parent has a record domain is returned.
+func (d *DMARC) OrgDomain(domain string) (string, []dns.RR) {
+	org, rrset := dns.Fqdn(domain), []dns.RR(nil)
+
+	parent := getParentDomain(dns.Fqdn(domain))
+	for i := 0; i < maxDMARCWalk && dns.CountLabel(parent) >= 2; i++ {
+		if records, err := d.records(parent); err == nil && len(records) > 0 {
+			org, rrset = parent, records
+		}
+
+		parent = getParentDomain(parent)
+	}
+
+	return org, rrset
+}
+
+// Authorized checks if the host of u accepts reports for domain (RFC 7489 7.1).
+func (d *DMARC) Authorized(domain string, u DMARCURI) DMARCAuth {
+	auth := DMARCAuth{URI: u.URI, Query: dns.Fqdn(domain) + "_report._dmarc." + u.Host}
+
+	txt, err := d.Lookup(auth.Query, dns.TypeTXT)
+	if err != nil {
+		auth.Error = err.Error()
+		return auth
+	}
+
+	auth.Authorized = len(dmarcRecords(txt)) > 0
+
+	return auth
+}
+
+// dmarcValues reports on the DMARC records of rrset, found at domain.
+func dmarcValues(domain string, rrset []dns.RR, auths []DMARCAuth) []ReportResult {
+	var results []ReportResult
+
+	// other TXT records at _dmarc are ignored (RFC7489 6.6.3)
+	valid := dmarcRecords(rrset)
+
+	records := []string{}
+	for _, rr := range valid {
+		records = append(records, rr.String())
+	}
+
+	results = append(results, ReportResult{Status: true, Records: records})
+
+	if len(valid) > 1 {
+		return append(results, ReportResult{
+			Result: "FAIL: Multiple DMARC records found, receivers will ignore them (RFC7489 6.6.3).",
+			Status: false, Name: "DMARCSyntax",
+		})
+	}
+
+	if len(valid) == 0 {
+		return results
+	}
+
+	rec, err := ParseDMARC(txtString(valid[0]))
+	if err != nil {
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: DMARC syntax error: %s", err),
+			Status: false, Name: "DMARCSyntax",
+		})
+	}
+
+	for _, warning := range rec.Warnings {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: DMARC %s.", warning),
+			Status: false, Name: "DMARCSyntax",
+		})
+	}
+
+	switch rec.Policy {
+	case "none":
+		results = append(results, ReportResult{
+			Result: "WARN: DMARC with monitoring policy found.",
+			Status: false, Name: "DMARCPolicy",
+		})
+	case "quarantine":
+		results = append(results, ReportResult{
+			Result: "WARN: DMARC with quarantine policy found.",
+			Status: false, Name: "DMARCPolicy",
+		})
+	case "reject":
+		results = append(results, ReportResult{
+			Result: "OK  : DMARC with reject policy.",
+			Status: true, Name: "DMARCPolicy",
+		})
+	}
+
+	if policyLevel(rec.SubdomainPolicy) < policyLevel(rec.Policy) {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: DMARC subdomain policy sp=%s is weaker than p=%s.", rec.SubdomainPolicy, rec.Policy),
+			Status: false, Name: "DMARCPolicy",
+		})
+	}
+
+	if rec.Pct < 100 && rec.Policy != "none" {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: DMARC policy is only applied to %d%% of the failing mail (pct=%d).", rec.Pct, rec.Pct),
+			Status: false, Name: "DMARCPolicy",
+		})
+	}
+
+	results = append(results, ReportResult{
+		Result: fmt.Sprintf("INFO: DMARC alignment DKIM %s, SPF %s.", alignment(rec.ADKIM), alignment(rec.ASPF)),
+		Status: true, Name: "DMARCAlignment",
+	})
+
+	if len(rec.RUA) == 0 {
+		results = append(results, ReportResult{
+			Result: "WARN: DMARC record has no rua= tag, you won't receive aggregate reports.",
+			Status: false, Name: "DMARCReport",
+		})
+	}
+
+	for _, auth := range auths {
+		switch {
+		case auth.Error != "":
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : DMARC external report authorization for %s failed: %s", auth.URI, auth.Error),
+				Status: false, Name: "DMARCReport",
+			})
+		case auth.Authorized:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("OK  : DMARC reports to %s are authorized by %s.", auth.URI, auth.Query),
+				Status: true, Name: "DMARCReport",
+			})
+		default:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: DMARC reports to %s are not authorized, receivers will not send reports for %s: no v=DMARC1 record at %s (RFC7489 7.1)", auth.URI, domain, auth.Query),
+				Status: false, Name: "DMARCReport",
+			})
+		}
+	}
+
+	return results
+}
+
+func policyLevel(policy string) int {
+	switch policy {
+	case "quarantine":
+		return 1
+	case "reject":
+		return 2
+	default:
+		return 0
+	}
+}
+
+func alignment(mode string) string {
+	if mode == "s" {
+		return "strict"
+	}
+
+	return "relaxed"
+}
@@ -0,0 +1,130 @@
+package check
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseDMARC(t *testing.T) {
+	tests := []struct {
+		record   string
+		policy   string
+		sp       string
+		pct      int
+		rua      []string
+		warnings int
+		err      string
+	}{
+		{record: "v=DMARC1; p=reject", policy: "reject", sp: "reject", pct: 100},
+		{record: "v=DMARC1;p=quarantine;sp=none;pct=50", policy: "quarantine", sp: "none", pct: 50},
+		{record: "V = DMARC1; P=Reject; adkim=s; aspf=s", policy: "reject", sp: "reject", pct: 100},
+		{record: "v=DMARC1; p=none; rua=mailto:dmarc@example.com,mailto:agg@example.net!10m", policy: "none", sp: "none", pct: 100, rua: []string{"example.com.", "example.net."}},
+		{record: "v=DMARC1; rua=mailto:dmarc@example.com", policy: "none", sp: "none", pct: 100, rua: []string{"example.com."}, warnings: 1},
+		{record: "v=DMARC1; p=none; rua=https://example.com/report", policy: "none", sp: "none", pct: 100, rua: []string{"example.com."}, warnings: 1},
+		{record: "v=DMARC1; p=none; foo=bar", policy: "none", sp: "none", pct: 100, warnings: 1},
+		{record: "v=DMARC1; p=none; rf=iodef", policy: "none", sp: "none", pct: 100, warnings: 1},
+		{record: "p=reject; v=DMARC1", err: "does not start with v=DMARC1"},
+		{record: "v=spf1 -all", err: "does not start with v=DMARC1"},
+		{record: "v=DMARC1", err: "no p= tag"},
+		{record: "v=DMARC1; p=block", err: "invalid policy"},
+		{record: "v=DMARC1; p=none; pct=101", err: "invalid pct"},
+		{record: "v=DMARC1; p=none; adkim=x", err: "invalid alignment"},
+		{record: "v=DMARC1; p=none; p=reject", err: "more than once"},
+		{record: "v=DMARC1; p=none; rua=mailto:nobody", err: "invalid mailto"},
+		{record: "v=DMARC1; p=none; rua=mailto:a@example.com!10x", err: "invalid size limit"},
+		{record: "v=DMARC1; p=none; fo=2", err: "invalid fo"},
+		{record: "v=DMARC1; p=none; broken", err: "invalid tag"},
+	}
+
+	for _, tt := range tests {
+		rec, err := ParseDMARC(tt.record)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseDMARC(%q) error = %v, want %q", tt.record, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseDMARC(%q) error = %s", tt.record, err)
+			continue
+		}
+
+		if rec.Policy != tt.policy || rec.SubdomainPolicy != tt.sp || rec.Pct != tt.pct {
+			t.Errorf("ParseDMARC(%q) = p=%s sp=%s pct=%d, want p=%s sp=%s pct=%d", tt.record, rec.Policy, rec.SubdomainPolicy, rec.Pct, tt.policy, tt.sp, tt.pct)
+		}
+
+		var hosts []string
+		for _, u := range rec.RUA {
+			hosts = append(hosts, u.Host)
+		}
+
+		if strings.Join(hosts, " ") != strings.Join(tt.rua, " ") {
+			t.Errorf("ParseDMARC(%q) rua hosts = %v, want %v", tt.record, hosts, tt.rua)
+		}
+
+		if len(rec.Warnings) != tt.warnings {
+			t.Errorf("ParseDMARC(%q) warnings = %v, want %d", tt.record, rec.Warnings, tt.warnings)
+		}
+	}
+}
+
+func TestDMARCValuesIgnoresOtherTXT(t *testing.T) {
+	var rrset []dns.RR
+
+	for _, s := range []string{
+		`_dmarc.example.com. 300 IN TXT "v=DMARC1; p=reject"`,
+		`_dmarc.example.com. 300 IN TXT "some-verification=1234"`,
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rrset = append(rrset, rr)
+	}
+
+	for _, res := range dmarcValues("example.com.", rrset, nil) {
+		if strings.HasPrefix(res.Result, "FAIL") {
+			t.Errorf("dmarcValues reported %q for a TXT record that is not DMARC", res.Result)
+		}
+
+		if res.Records != nil && len(res.Records) != 1 {
+			t.Errorf("dmarcValues records = %v, want only the DMARC record", res.Records)
+		}
+	}
+}
+
+func TestDMARCLookup(t *testing.T) {
+	d := &DMARC{Lookup: fakeLookup(t,
+		`_dmarc.example.com. 300 IN TXT "v=DMARC1; p=reject"`,
+		`example.com._report._dmarc.reports.example.net. 300 IN TXT "v=DMARC1"`,
+	)}
+
+	if org, rrset := d.OrgDomain("mail.sub.example.com"); org != "example.com." || len(rrset) != 1 {
+		t.Errorf("OrgDomain = %s %v, want example.com. with 1 record", org, rrset)
+	}
+
+	if org, rrset := d.OrgDomain("example.org"); org != "example.org." || rrset != nil {
+		t.Errorf("OrgDomain = %s %v, want example.org. without records", org, rrset)
+	}
+
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"reports.example.net.", true},
+		{"other.example.net.", false},
+	}
+
+	for _, tt := range tests {
+		auth := d.Authorized("example.com", DMARCURI{URI: "mailto:dmarc@" + tt.host, Host: tt.host})
+		if auth.Authorized != tt.want {
+			t.Errorf("Authorized(%s) = %v (%s), want %v", tt.host, auth.Authorized, auth.Query, tt.want)
+		}
+	}
+}
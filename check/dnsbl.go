@@ -0,0 +1,297 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// DNSBL is a DNS based blocklist (RFC 5782).
+type DNSBL struct {
+	Zone    string
+	IPv6    bool              // the list has IPv6 entries
+	Codes   map[string]string // meaning of the return codes
+	Refused map[string]string // return codes that mean the query was refused, not that the ip is listed
+}
+
+// DNSBLs is the default list of blocklists.
+var DNSBLs = []DNSBL{
+	{
+		Zone: "zen.spamhaus.org",
+		IPv6: true,
+		Codes: map[string]string{
+			"127.0.0.2":  "SBL spam source",
+			"127.0.0.3":  "SBL CSS spam source",
+			"127.0.0.4":  "XBL exploited host",
+			"127.0.0.5":  "XBL exploited host",
+			"127.0.0.6":  "XBL exploited host",
+			"127.0.0.7":  "XBL exploited host",
+			"127.0.0.9":  "SBL DROP",
+			"127.0.0.10": "PBL ISP maintained, not expected to send mail directly",
+			"127.0.0.11": "PBL Spamhaus maintained, not expected to send mail directly",
+		},
+		Refused: map[string]string{
+			"127.255.255.252": "typo in the DNSBL name",
+			"127.255.255.254": "query via a public or open resolver",
+			"127.255.255.255": "excessive number of queries",
+		},
+	},
+	{
+		Zone:  "bl.spamcop.net",
+		Codes: map[string]string{"127.0.0.2": "listed"},
+	},
+	{
+		Zone:  "b.barracudacentral.org",
+		Codes: map[string]string{"127.0.0.2": "listed"},
+	},
+	{
+		Zone:  "psbl.surriel.com",
+		Codes: map[string]string{"127.0.0.2": "listed"},
+	},
+	{
+		Zone: "bl.mailspike.net",
+		IPv6: true,
+		Codes: map[string]string{
+			"127.0.0.2":  "listed",
+			"127.0.0.10": "worst reputation",
+			"127.0.0.11": "very bad reputation",
+			"127.0.0.12": "bad reputation",
+		},
+	},
+}
+
+type DNSBLCheck struct {
+	NS      []structs.NSData
+	Lists   []DNSBL
+	SPF     bool // also check the ips authorized by SPF
+	Results []DNSBLResult
+	MXScan  *MXCheck // shared MX scan, nil scans the MX records again
+	Report
+	s      *scan.Scan
+	lookup func(name string, qtype uint16) ([]dns.RR, error)
+}
+
+type DNSBLResult struct {
+	Host    string // MX name or SPF
+	IP      string
+	Zone    string
+	Query   string
+	Listed  bool
+	Codes   []string
+	Meaning []string
+	Refused string `json:",omitempty"`
+	Reason  string `json:",omitempty"` // TXT record of the listing
+	Error   string `json:",omitempty"`
+}
+
+// NewDNSBL returns a DNSBL checker for the given zones. Zones that are in DNSBLs use
+// its return codes, if zones is empty DNSBLs is used.
+func NewDNSBL(s *scan.Scan, ns []structs.NSData, spf bool, zones ...string) *DNSBLCheck {
+	c := &DNSBLCheck{
+		s:     s,
+		NS:    ns,
+		SPF:   spf,
+		Lists: DNSBLs,
+	}
+
+	if len(zones) > 0 && strings.Join(zones, "") != "" {
+		c.Lists = nil
+
+	zone:
+		for _, zone := range zones {
+			zone = strings.TrimSuffix(strings.TrimSpace(zone), ".")
+			if zone == "" {
+				continue
+			}
+
+			for _, list := range DNSBLs {
+				if strings.EqualFold(list.Zone, zone) {
+					c.Lists = append(c.Lists, list)
+					continue zone
+				}
+			}
+
+			c.Lists = append(c.Lists, DNSBL{Zone: zone, IPv6: true})
+		}
+	}
+
+	if s != nil {
+		c.lookup = resolverLookup(s)
+	}
+
+	return c
+}
+
+// dnsblQuery returns the name to query for ip in zone: the reversed octets for IPv4
+// and the reversed nibbles for IPv6 (RFC 5782 2.1, 2.4).
+func dnsblQuery(ip net.IP, zone string) string {
+	rev, _ := dns.ReverseAddr(ip.String())
+
+	if ip.To4() != nil {
+		rev = strings.TrimSuffix(rev, "in-addr.arpa.")
+	} else {
+		rev = strings.TrimSuffix(rev, "ip6.arpa.")
+	}
+
+	return rev + dns.Fqdn(zone)
+}
+
+func (c *DNSBLCheck) Scan(domain string) {
+	log.Debugf("DNSBL: scan")
+	defer log.Debugf("DNSBL: scan exit")
+
+	type host struct {
+		name string
+		ip   net.IP
+	}
+
+	var hosts []host
+
+	mx := sharedMX(c.s, c.NS, c.MXScan, domain)
+
+	var names []string
+
+	for name := range mx.MXIP {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		for _, ip := range mx.MXIP[name] {
+			if !seen[ip.String()] {
+				seen[ip.String()] = true
+				hosts = append(hosts, host{name, ip})
+			}
+		}
+	}
+
+	if c.SPF {
+		// only single ips, a range can't be looked up
+		for _, entry := range NewSPF(c.s).Expand(domain).Flatten() {
+			ip := net.ParseIP(entry)
+			if ip != nil && !seen[ip.String()] {
+				seen[ip.String()] = true
+				hosts = append(hosts, host{"SPF", ip})
+			}
+		}
+	}
+
+	for _, h := range hosts {
+		for _, list := range c.Lists {
+			if h.ip.To4() == nil && !list.IPv6 {
+				continue
+			}
+
+			c.Results = append(c.Results, c.query(list, h.name, h.ip))
+		}
+	}
+}
+
+// query looks up ip in list and interprets the return codes.
+func (c *DNSBLCheck) query(list DNSBL, host string, ip net.IP) DNSBLResult {
+	res := DNSBLResult{Host: host, IP: ip.String(), Zone: list.Zone, Query: dnsblQuery(ip, list.Zone)}
+
+	rrs, err := c.lookup(res.Query, dns.TypeA)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	for _, addr := range extractIP(rrs) {
+		code := addr.String()
+
+		if reason, ok := list.Refused[code]; ok {
+			res.Refused = fmt.Sprintf("%s (%s)", reason, code)
+			return res
+		}
+
+		if !addr.IsLoopback() || addr.To4() == nil {
+			res.Error = fmt.Sprintf("invalid return code %s, not in 127.0.0.0/8", code)
+			return res
+		}
+
+		meaning, ok := list.Codes[code]
+		if !ok {
+			meaning = "listed"
+		}
+
+		res.Listed = true
+		res.Codes = append(res.Codes, code)
+		res.Meaning = append(res.Meaning, meaning)
+	}
+
+	if res.Listed {
+		if txt, err := c.lookup(res.Query, dns.TypeTXT); err == nil && len(txt) > 0 {
+			res.Reason = txtString(txt[0])
+		}
+	}
+
+	return res
+}
+
+func (c *DNSBLCheck) Values() []ReportResult {
+	var results []ReportResult
+
+	refused := make(map[string]bool)
+	checked := make(map[string]bool)
+
+	for _, r := range c.Results {
+		checked[r.IP] = true
+
+		switch {
+		case r.Error != "":
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : DNSBL lookup of %s on %s failed: %s", r.IP, r.Zone, r.Error),
+				Status: false, Name: "DNSBL",
+			})
+		case r.Refused != "" && !refused[r.Zone]:
+			refused[r.Zone] = true
+
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: %s refused the query: %s. The results of this list are unknown.", r.Zone, r.Refused),
+				Status: false, Name: "DNSBLRefused",
+			})
+		case r.Listed:
+			var records []string
+			if r.Reason != "" {
+				records = []string{r.Reason}
+			}
+
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: %s (%s) is listed on %s: %s", r.IP, r.Host, r.Zone, strings.Join(r.Meaning, ", ")),
+				Status: false, Records: records, Name: "DNSBL",
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		zones := []string{}
+		for _, list := range c.Lists {
+			zones = append(zones, list.Zone)
+		}
+
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : None of your %d ips are listed on %s", len(checked), strings.Join(zones, ", ")),
+			Status: true, Name: "DNSBL",
+		})
+	}
+
+	return results
+}
+
+func (c *DNSBLCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "DNSBL"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
@@ -0,0 +1,107 @@
+package check
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestDNSBLQuery(t *testing.T) {
+	tests := []struct {
+		ip   string
+		zone string
+		want string
+	}{
+		{"192.0.2.99", "zen.spamhaus.org", "99.2.0.192.zen.spamhaus.org."},
+		{"127.0.0.2", "bl.example.", "2.0.0.127.bl.example."},
+		{"2001:db8:1:2::10", "zen.spamhaus.org", "0.1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.2.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2.zen.spamhaus.org."},
+		{"::ffff:192.0.2.1", "bl.example", "1.2.0.192.bl.example."},
+	}
+
+	for _, tt := range tests {
+		if got := dnsblQuery(net.ParseIP(tt.ip), tt.zone); got != tt.want {
+			t.Errorf("dnsblQuery(%s, %s) = %s, want %s", tt.ip, tt.zone, got, tt.want)
+		}
+	}
+}
+
+func TestNewDNSBLZones(t *testing.T) {
+	c := NewDNSBL(nil, nil, false, "ZEN.spamhaus.org.", " bl.example ", "")
+	if len(c.Lists) != 2 || c.Lists[0].Codes == nil || c.Lists[1].Zone != "bl.example" || !c.Lists[1].IPv6 {
+		t.Errorf("NewDNSBL lists = %+v", c.Lists)
+	}
+
+	if c := NewDNSBL(nil, nil, false, ""); len(c.Lists) != len(DNSBLs) {
+		t.Errorf("NewDNSBL without zones has %d lists, want %d", len(c.Lists), len(DNSBLs))
+	}
+}
+
+func TestDNSBLScan(t *testing.T) {
+	list := DNSBL{
+		Zone:    "bl.example",
+		Codes:   map[string]string{"127.0.0.2": "spam source"},
+		Refused: map[string]string{"127.255.255.254": "open resolver"},
+	}
+
+	// the MX scan is shared, a scanned MXCheck is not scanned again
+	mx := &MXCheck{
+		MXIP: map[string][]net.IP{
+			"mx1.example.com.": {net.ParseIP("192.0.2.1"), net.ParseIP("2001:db8::1")},
+			"mx2.example.com.": {net.ParseIP("192.0.2.2"), net.ParseIP("192.0.2.1")},
+			"mx3.example.com.": {net.ParseIP("192.0.2.3")},
+			"mx4.example.com.": {net.ParseIP("192.0.2.4")},
+		},
+		scanned: "example.com",
+	}
+
+	c := &DNSBLCheck{
+		Lists:  []DNSBL{list},
+		MXScan: mx,
+		lookup: fakeLookup(t,
+			`2.2.0.192.bl.example. 300 IN A 127.0.0.2`,
+			`2.2.0.192.bl.example. 300 IN TXT "listed for spam"`,
+			`3.2.0.192.bl.example. 300 IN A 127.255.255.254`,
+			`4.2.0.192.bl.example. 300 IN A 192.0.2.100`,
+		),
+	}
+
+	c.Scan("example.com")
+
+	// the IPv6 address is skipped, the list has no IPv6 entries, and 192.0.2.1 is
+	// only looked up once
+	if len(c.Results) != 4 {
+		t.Fatalf("results = %+v, want 4", c.Results)
+	}
+
+	want := map[string]string{
+		"192.0.2.2": "FAIL: 192.0.2.2 (mx2.example.com.) is listed on bl.example: spam source",
+		"192.0.2.3": "WARN: bl.example refused the query: open resolver (127.255.255.254).",
+		"192.0.2.4": "ERR : DNSBL lookup of 192.0.2.4 on bl.example failed: invalid return code 192.0.2.100",
+	}
+
+	for _, r := range c.Results {
+		if r.IP == "192.0.2.2" && (r.Reason != "listed for spam" || len(r.Codes) != 1) {
+			t.Errorf("listing of 192.0.2.2 = %+v", r)
+		}
+	}
+
+	results := c.Values()
+
+	for ip, prefix := range want {
+		found := false
+
+		for _, res := range results {
+			if strings.HasPrefix(res.Result, prefix) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("no result %q for %s in %v", prefix, ip, results)
+		}
+	}
+
+	if len(results) != 3 {
+		t.Errorf("results = %v, want 3", results)
+	}
+}
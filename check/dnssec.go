@@ -1,22 +1,30 @@
 package check
 
 import (
+	"fmt"
+	"strings"
+
 	"github.com/42wim/dt/scan"
 	"github.com/42wim/dt/structs"
 )
 
 type DNSSECCheck struct {
-	NS     []structs.NSData
-	DNSSEC []DNSSECCheckData
+	NS         []structs.NSData
+	DNSSEC     []DNSSECCheckData
+	ChainValid bool
+	ChainError string `json:",omitempty"`
 	Report
 	s *scan.Scan
 }
 
 type DNSSECCheckData struct {
-	Name  string
-	IP    string
-	Error string
-	Valid bool
+	Zone        string
+	Name        string
+	IP          string
+	Type        string
+	Error       string
+	Valid       bool
+	Unreachable bool
 }
 
 func NewDNSSEC(s *scan.Scan, ns []structs.NSData) *DNSSECCheck {
@@ -32,13 +40,19 @@ func (c *DNSSECCheck) Scan(domain string) {
 	log.Debugf("DNSSEC: scan")
 	defer log.Debugf("DNSSEC: scan exit")
 
-	_, err := c.s.ValidateChain(domain)
+	for _, res := range c.s.ValidateChainResults(domain) {
+		c.DNSSEC = append(c.DNSSEC, DNSSECCheckData{
+			Zone: res.Zone, Name: res.Name, IP: res.IP, Type: res.Type,
+			Error: res.Error, Valid: res.Valid, Unreachable: res.Unreachable,
+		})
+	}
+
+	valid, err := c.s.ValidateChain(domain)
 	if err != nil {
-		c.DNSSEC = append(c.DNSSEC, DNSSECCheckData{Error: err.Error()})
-		return
+		c.ChainError = err.Error()
 	}
 
-	c.DNSSEC = append(c.DNSSEC, DNSSECCheckData{Valid: true})
+	c.ChainValid = valid
 }
 
 func (c *DNSSECCheck) Values() []ReportResult {
@@ -46,21 +60,63 @@ func (c *DNSSECCheck) Values() []ReportResult {
 
 	for _, res := range c.DNSSEC {
 		if res.Valid {
+			continue
+		}
+
+		switch {
+		case res.Unreachable:
 			results = append(results, ReportResult{
-				Result: "OK  : DNSKEY validated. Chain validated",
-				Status: true, Name: "DNSSEC",
+				Result: fmt.Sprintf("ERR : %s (%s) didn't answer the %s query for %s, it is skipped: %s", res.Name, res.IP, res.Type, res.Zone, res.Error),
+				Status: false, Name: "DNSSEC",
+			})
+		case res.Type == "DNSKEY":
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: %s (%s) serves bogus or missing DNSKEY for %s: %s", res.Name, res.IP, res.Zone, res.Error),
+				Status: false, Name: "DNSSEC",
 			})
-		} else {
+		default:
 			results = append(results, ReportResult{
-				Result: "FAIL: " + res.Error,
+				Result: fmt.Sprintf("FAIL: parent %s (%s) has bogus or missing DS for %s: %s", res.Name, res.IP, res.Zone, res.Error),
 				Status: false, Name: "DNSSEC",
 			})
 		}
 	}
 
+	switch {
+	case len(c.DNSSEC) == 0:
+		results = append(results, ReportResult{
+			Result: "FAIL: validateChain failed. Run with -debug or use graph for more information",
+			Status: false, Name: "DNSSEC",
+		})
+	case !c.ChainValid && allUnreachable(results):
+		// every failure is an unreachable server, but a zone is left without answers
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: %s", c.ChainError),
+			Status: false, Name: "DNSSEC",
+		})
+	}
+
+	if c.ChainValid {
+		results = append(results, ReportResult{
+			Result: "OK  : DNSKEY validated. Chain validated",
+			Status: true, Name: "DNSSEC",
+		})
+	}
+
 	return results
 }
 
+// allUnreachable returns true if results only has unreachable server errors.
+func allUnreachable(results []ReportResult) bool {
+	for _, res := range results {
+		if !strings.HasPrefix(res.Result, "ERR : ") {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (c *DNSSECCheck) CreateReport(domain string) Report {
 	c.Scan(domain)
 
@@ -0,0 +1,98 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+// FCrDNS is the forward-confirmed reverse DNS result of an ip of a host.
+type FCrDNS struct {
+	Host      string
+	IP        string
+	PTR       []string
+	Confirmed []string // PTR names that resolve back to the ip
+	Error     string   `json:",omitempty"`
+}
+
+// fcrdns looks up the PTR records of ip and checks which of the names resolve back
+// to ip with an A (IPv4) or AAAA (IPv6) record.
+func fcrdns(s *scan.Scan, host string, ip net.IP) FCrDNS {
+	res := FCrDNS{Host: host, IP: ip.String()}
+
+	rev, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	ptr, _, err := scan.QueryRRset(rev, dns.TypePTR, s.Resolver(), true)
+	if err != nil {
+		if !strings.Contains(err.Error(), "NXDOMAIN") && !strings.Contains(err.Error(), "no rr for") {
+			res.Error = err.Error()
+		}
+
+		return res
+	}
+
+	qtype := dns.TypeA
+	if ip.To4() == nil {
+		qtype = dns.TypeAAAA
+	}
+
+	for _, rr := range ptr {
+		name := rr.(*dns.PTR).Ptr
+		res.PTR = append(res.PTR, name)
+
+		addrs, _, err := scan.QueryRRset(name, qtype, s.Resolver(), true)
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range extractIP(addrs) {
+			if addr.Equal(ip) {
+				res.Confirmed = append(res.Confirmed, name)
+				break
+			}
+		}
+	}
+
+	return res
+}
+
+// fcrdnsValues reports the FCrDNS results of the ips of kind (MX or NS).
+func fcrdnsValues(kind string, results []FCrDNS) []ReportResult {
+	rep := []ReportResult{}
+
+	for _, r := range results {
+		switch {
+		case r.Error != "":
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("ERR : Reverse PTR lookup for %s %s (%s) failed: %s", kind, r.Host, r.IP, r.Error),
+				Status: false, Name: "Reverse",
+			})
+		case len(r.PTR) == 0:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: Reverse PTR lookup for %s %s (%s) failed.", kind, r.Host, r.IP),
+				Status: false, Name: "Reverse",
+			})
+		case len(r.Confirmed) == 0:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: PTR %v of %s %s (%s) doesn't resolve back to %s (FCrDNS).", r.PTR, kind, r.Host, r.IP, r.IP),
+				Status: false, Name: "FCrDNS",
+			})
+		}
+	}
+
+	if len(rep) == 0 {
+		rep = append(rep, ReportResult{
+			Result: fmt.Sprintf("OK  : All %s ips have reverse PTR records that resolve back to them (FCrDNS)", kind),
+			Status: true, Name: "FCrDNS",
+		})
+	}
+
+	return rep
+}
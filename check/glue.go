@@ -10,7 +10,13 @@ import (
 )
 
 type Glue struct {
-	NS []structs.NSData
+	NS        []structs.NSData
+	Parent    []Delegation // delegation as sent by every parent nameserver
+	Bailiwick []GlueNS
+	Stale     []string // glue for names that are not NS of the zone
+	Cycles    []Cycle
+	// ParentScan has the delegations of the parent, nil asks the parent again
+	ParentScan *DelegationScan
 	Report
 	s *scan.Scan
 }
@@ -25,17 +31,17 @@ func NewGlue(s *scan.Scan, ns []structs.NSData) *Glue {
 }
 
 func (g *Glue) Scan(domain string) {
-}
+	log.Debugf("GLUE: scan")
+	defer log.Debugf("GLUE: scan exit")
+
+	var err error
 
-func (g *Glue) CheckParent(domain string) (bool, []string, error) {
-	parentGlue, err := g.getParentGlue(domain)
+	g.Parent, err = sharedDelegations(g.s, g.ParentScan, domain)
 	if err != nil {
-		return false, []string{}, err
+		log.Debugf("GLUE: parent delegations of %s: %s", domain, err)
 	}
 
-	ok, res := g.Compare(parentGlue)
-
-	return ok, res, nil
+	g.ScanBailiwick(domain)
 }
 
 func (g *Glue) CheckSelf(domain string) (bool, []string, error) {
@@ -44,38 +50,30 @@ func (g *Glue) CheckSelf(domain string) (bool, []string, error) {
 		return false, []string{}, err
 	}
 
-	ok, res := g.Compare(selfGlue)
+	ok, res := g.Compare(domain, selfGlue)
 
 	return ok, res, nil
 }
 
 func (g *Glue) CreateReport(domain string) Report {
-	res := ReportResult{}
+	g.Scan(domain)
+
 	rep := Report{}
 
+	// the glue of the parent is checked per nameserver in BailiwickValues
+	if !g.parentAnswered() {
+		rep.Result = append(rep.Result, ReportResult{
+			Result: fmt.Sprintf("ERR : CheckParentGlue test failed: no parent nameserver of %s answered", dns.Fqdn(getParentDomain(domain))),
+			Status: false, Name: "Parent",
+		})
+	}
+
 	var (
 		missed []string
 		err    error
 	)
 
-	res.Status, missed, err = g.CheckParent(domain)
-
-	res.Name = "Parent"
-	if err != nil {
-		res.Error = err.Error()
-	}
-
-	if !res.Status {
-		res.Result = fmt.Sprintf("WARN: no glue records found for %s in NS of parent %s", missed, dns.Fqdn(getParentDomain(domain)))
-		res.Name = "Parent"
-	}
-
-	if res.Error != "" {
-		res.Result = fmt.Sprintf("ERR : CheckParentGlue test failed: %s", res.Error)
-	}
-
-	rep.Result = append(rep.Result, res)
-	res = ReportResult{Result: fmt.Sprintf("OK  : glue records found for all nameservers in NS record of %s", dns.Fqdn(domain))}
+	res := ReportResult{Result: fmt.Sprintf("OK  : glue records found for all in-bailiwick nameservers in NS record of %s", dns.Fqdn(domain))}
 	res.Status, missed, err = g.CheckSelf(domain)
 	res.Name = "Self"
 
@@ -93,17 +91,23 @@ func (g *Glue) CreateReport(domain string) Report {
 	}
 
 	rep.Result = append(rep.Result, res)
+	rep.Result = append(rep.Result, delegationValues(g.Parent)...)
+	rep.Result = append(rep.Result, g.BailiwickValues(domain)...)
 	rep.Type = "GLUE"
 	g.Report = rep
 
 	return rep
 }
 
-func (g *Glue) Compare(parentGlue []net.IP) (bool, []string) {
+// Compare returns the ips of the in-bailiwick nameservers of domain that are missing
+// from glue. Out-of-bailiwick nameservers need no glue.
+func (g *Glue) Compare(domain string, glue []net.IP) (bool, []string) {
 	var NSips []net.IP
 
 	for _, data := range g.NS {
-		NSips = append(NSips, data.IP...)
+		if dns.IsSubDomain(dns.Fqdn(domain), dns.Fqdn(data.Name)) {
+			NSips = append(NSips, data.IP...)
+		}
 	}
 
 	m := make(map[string]bool)
@@ -114,7 +118,7 @@ func (g *Glue) Compare(parentGlue []net.IP) (bool, []string) {
 		m[ip.String()] = false
 	}
 
-	for _, ip := range parentGlue {
+	for _, ip := range glue {
 		m[ip.String()] = true
 	}
 
@@ -131,27 +135,45 @@ func (g *Glue) Compare(parentGlue []net.IP) (bool, []string) {
 	return false, ips
 }
 
-func (g *Glue) getParentGlue(domain string) ([]net.IP, error) {
-	// TODO ask every parent
-	log.Debugf("Finding NS of parent: %s", dns.Fqdn(getParentDomain(domain)))
-
-	var ips []net.IP
-
-	nsdata, err := g.s.FindNS(getParentDomain(domain))
-	if err != nil {
-		return ips, err
+// parentAnswered reports whether a parent nameserver sent the delegation.
+func (g *Glue) parentAnswered() bool {
+	for _, d := range g.Parent {
+		if d.Error == "" {
+			return true
+		}
 	}
-	// asking parent about NS
-	log.Debugf("Asking parent %s (%s) NS of %s", nsdata[0].Info[0].IP.String(), getParentDomain(domain), domain)
 
-	return g.getGlueIPs(domain, nsdata[0].Info[0].IP.String())
+	return false
 }
 
 func (g *Glue) getSelfGlue(domain string) ([]net.IP, error) {
-	// TODO all NS
-	log.Debugf("Asking self %s (%s) NS of %s", g.NS[0].IP[0].String(), domain, domain)
+	var (
+		ips     []net.IP
+		lastErr error
+	)
+
+	answered := false
+
+	for _, ns := range g.NS {
+		for _, nsip := range ns.IP {
+			log.Debugf("Asking self %s (%s) NS of %s", nsip, domain, domain)
+
+			glue, err := g.getGlueIPs(domain, nsip.String())
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			answered = true
+			ips = append(ips, glue...)
+		}
+	}
+
+	if !answered && lastErr != nil {
+		return ips, lastErr
+	}
 
-	return g.getGlueIPs(domain, g.NS[0].IP[0].String())
+	return ips, nil
 }
 
 func (g *Glue) getGlueIPs(domain string, server string) ([]net.IP, error) {
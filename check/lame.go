@@ -0,0 +1,265 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// Lame delegation states of a nameserver.
+const (
+	LameOK       = "authoritative"
+	LameTimeout  = "timeout"
+	LameRefused  = "REFUSED"
+	LameServfail = "SERVFAIL"
+	LameNoAuth   = "not authoritative"
+	LameUpward   = "upward referral"
+	LameNoSOA    = "no SOA"
+	LameNoIP     = "no address"
+	LameError    = "error"
+)
+
+type LameCheck struct {
+	NS      []structs.NSData
+	Servers []LameServer
+	// ParentScan has the delegations of the parent, nil asks the parent again
+	ParentScan *DelegationScan
+	Report
+	s *scan.Scan
+}
+
+// LameServer is the answer of a nameserver ip to a SOA query for the zone.
+type LameServer struct {
+	Name   string
+	IP     string
+	Parent bool // listed at the parent
+	Zone   bool // listed in the zone
+	State  string
+	Rtt    time.Duration
+	Error  string `json:",omitempty"`
+}
+
+func NewLame(s *scan.Scan, ns []structs.NSData) *LameCheck {
+	c := &LameCheck{
+		s:  s,
+		NS: ns,
+	}
+
+	return c
+}
+
+// lameState asks server for the SOA of zone and returns the lame delegation state.
+func lameState(zone, server string) (string, time.Duration, error) {
+	res, err := scan.Query(zone, dns.TypeSOA, server, false)
+
+	var netErr net.Error
+
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return LameTimeout, 0, err
+	case err != nil && strings.Contains(err.Error(), "REFUSED"):
+		return LameRefused, res.Rtt, err
+	case err != nil && strings.Contains(err.Error(), "SERVFAIL"):
+		return LameServfail, res.Rtt, err
+	case err != nil:
+		return LameError, res.Rtt, err
+	}
+
+	msg := res.Msg
+	soa := extractRR(msg.Answer, dns.TypeSOA)
+
+	switch {
+	case len(soa) > 0 && msg.Authoritative:
+		return LameOK, res.Rtt, nil
+	case len(soa) > 0:
+		return LameNoAuth, res.Rtt, nil
+	}
+
+	for _, rr := range extractRR(msg.Ns, dns.TypeNS) {
+		if !dns.IsSubDomain(dns.Fqdn(zone), rr.Header().Name) {
+			return LameUpward, res.Rtt, fmt.Errorf("referral to %s", rr.Header().Name)
+		}
+	}
+
+	if !msg.Authoritative {
+		return LameNoAuth, res.Rtt, nil
+	}
+
+	return LameNoSOA, res.Rtt, nil
+}
+
+// parentNS returns the NS names of the first parent server that sent them.
+func parentNS(delegations []Delegation) []string {
+	var names []string
+
+	for _, d := range delegations {
+		for _, rr := range d.NS {
+			names = append(names, strings.ToLower(rr.(*dns.NS).Ns))
+		}
+
+		if len(names) > 0 {
+			return names
+		}
+	}
+
+	return names
+}
+
+// Scan asks every ip of every nameserver listed at the parent or in the zone for the
+// SOA of domain.
+func (c *LameCheck) Scan(domain string) {
+	log.Debugf("Lame: scan")
+	defer log.Debugf("Lame: scan exit")
+
+	domain = dns.Fqdn(domain)
+	ips := make(map[string][]net.IP)
+	zone := make(map[string]bool)
+
+	for _, ns := range c.NS {
+		name := strings.ToLower(ns.Name)
+		ips[name] = ns.IP
+		zone[name] = true
+	}
+
+	parent := make(map[string]bool)
+	delegations, err := sharedDelegations(c.s, c.ParentScan, domain)
+	if err != nil {
+		log.Debugf("Lame: parent delegations of %s: %s", domain, err)
+	}
+
+	for _, name := range parentNS(delegations) {
+		parent[name] = true
+	}
+
+	var names []string
+
+	for name := range zone {
+		names = append(names, name)
+	}
+
+	for name := range parent {
+		if !zone[name] {
+			names = append(names, name)
+
+			for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+				rrs, err := resolverLookup(c.s)(name, qtype)
+				if err == nil {
+					ips[name] = append(ips[name], c.s.UsableIPs(extractIP(rrs))...)
+				}
+			}
+		}
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		if len(ips[name]) == 0 {
+			c.Servers = append(c.Servers, LameServer{Name: name, Parent: parent[name], Zone: zone[name], State: LameNoIP})
+			continue
+		}
+
+		for _, ip := range ips[name] {
+			srv := LameServer{Name: name, IP: ip.String(), Parent: parent[name], Zone: zone[name]}
+
+			var err error
+
+			srv.State, srv.Rtt, err = lameState(domain, ip.String())
+			if err != nil {
+				srv.Error = err.Error()
+			}
+
+			c.Servers = append(c.Servers, srv)
+		}
+	}
+}
+
+func (c *LameCheck) Values() []ReportResult {
+	var (
+		results []ReportResult
+		failed6 []string
+		ok6     bool
+	)
+
+	for _, srv := range c.Servers {
+		v6 := srv.IP != "" && net.ParseIP(srv.IP).To4() == nil
+
+		listed := "in the zone"
+
+		switch {
+		case srv.Parent && srv.Zone:
+			listed = "at the parent and in the zone"
+		case srv.Parent:
+			listed = "at the parent"
+		}
+
+		switch srv.State {
+		case LameOK:
+			ok6 = ok6 || v6
+			continue
+		case LameNoIP:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: %s listed %s has no address, the delegation is lame.", srv.Name, listed),
+				Status: false, Name: "Lame",
+			})
+		case LameTimeout, LameError:
+			// not answering is a transport failure, not a lame delegation
+			if v6 {
+				failed6 = append(failed6, fmt.Sprintf("%s (%s): %s", srv.Name, srv.IP, srv.Error))
+				continue
+			}
+
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : %s (%s) listed %s doesn't answer: %s", srv.Name, srv.IP, listed, srv.Error),
+				Status: false, Name: "Lame",
+			})
+		case LameUpward:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: %s (%s) listed %s returns an upward referral (%s), it doesn't serve the zone.", srv.Name, srv.IP, listed, srv.Error),
+				Status: false, Name: "Lame",
+			})
+		default:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: %s (%s) listed %s is lame: %s.", srv.Name, srv.IP, listed, srv.State),
+				Status: false, Name: "Lame",
+			})
+		}
+	}
+
+	switch {
+	case len(failed6) > 0 && !ok6:
+		results = append(results, ReportResult{
+			Result: "WARN: None of the nameservers answered over IPv6, is IPv6 available?",
+			Status: false, Records: failed6, Name: "Lame",
+		})
+	case len(failed6) > 0:
+		results = append(results, ReportResult{
+			Result: "ERR : Nameservers failed over IPv6.",
+			Status: false, Records: failed6, Name: "Lame",
+		})
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : All %d nameserver ips listed at the parent or in the zone answer authoritatively.", len(c.Servers)),
+			Status: true, Name: "Lame",
+		})
+	}
+
+	return results
+}
+
+func (c *LameCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "Lame"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
@@ -0,0 +1,282 @@
+package check
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// lint runs the checks that don't need network access on the records of a zone file.
+type lint struct {
+	*zoneData
+}
+
+// ParseZone reads a zone in master file format.
+func ParseZone(r io.Reader, origin, file string) ([]dns.RR, error) {
+	var rrs []dns.RR
+
+	if origin != "" {
+		origin = dns.Fqdn(origin)
+	}
+
+	zp := dns.NewZoneParser(r, origin, file)
+
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		rrs = append(rrs, rr)
+	}
+
+	if err := zp.Err(); err != nil {
+		return rrs, err
+	}
+
+	return rrs, nil
+}
+
+// Lint checks the records of a zone offline and returns the same report as a live run.
+// If origin is empty the owner of the SOA record is used.
+func Lint(origin string, rrs []dns.RR) *DomainReport {
+	if origin == "" {
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == dns.TypeSOA {
+				origin = rr.Header().Name
+				break
+			}
+		}
+	}
+
+	l := &lint{newZoneData(origin, rrs)}
+
+	return &DomainReport{
+		Name:      l.origin,
+		Timestamp: time.Now(),
+		Report: []Report{
+			{Type: "SOA", Result: l.soa()},
+			{Type: "NS", Result: l.ns()},
+			{Type: "MX", Result: l.mx()},
+			{Type: "Web", Result: l.web()},
+			{Type: "Spam", Result: l.spam()},
+			{Type: "DNSSEC", Result: append(l.verifySigs(), l.verifyNSEC()...)},
+			{Type: "TTL", Result: l.ttl()},
+		},
+	}
+}
+
+func (l *lint) lookup(name string, qtype uint16) []dns.RR {
+	return l.rrsets[rrsetKey{strings.ToLower(dns.Fqdn(name)), qtype}]
+}
+
+func (l *lint) inZone(name string) bool {
+	return dns.IsSubDomain(l.origin, strings.ToLower(dns.Fqdn(name)))
+}
+
+func (l *lint) soa() []ReportResult {
+	rrset := l.lookup(l.origin, dns.TypeSOA)
+	if len(rrset) != 1 {
+		return []ReportResult{{
+			Result: fmt.Sprintf("FAIL: Expected 1 SOA record at %s, found %d.", l.origin, len(rrset)),
+			Status: false, Name: "SOA",
+		}}
+	}
+
+	soa := rrset[0].(*dns.SOA)
+	c := &SOACheck{Domain: l.origin, SOA: []SOAData{{Name: "zone file", SOA: soa}}, Timers: SOATimerLimits}
+
+	results := c.serialValues(soa)
+	results = append(results, c.timerValues()...)
+
+	listed := false
+
+	for _, rr := range l.lookup(l.origin, dns.TypeNS) {
+		if strings.EqualFold(rr.(*dns.NS).Ns, soa.Ns) {
+			listed = true
+		}
+	}
+
+	if listed {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : MNAME %s is listed as NS in the zone.", soa.Ns),
+			Status: true, Name: "MNAME",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: MNAME %s is not listed as NS in the zone.", soa.Ns),
+			Status: false, Name: "MNAME",
+		})
+	}
+
+	return results
+}
+
+// targets checks that the in-zone targets of NS or MX records are no CNAMEs, have
+// addresses and that those addresses are routable.
+func (l *lint) targets(kind string, targets []string) []ReportResult {
+	var (
+		results []ReportResult
+		ips     []string
+	)
+
+	for _, target := range targets {
+		if !l.inZone(target) {
+			continue
+		}
+
+		if len(l.lookup(target, dns.TypeCNAME)) > 0 {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Your %s (%s) is a CNAME.", kind, target),
+				Status: false, Name: "CNAME",
+			})
+		}
+
+		addrs := extractIP(append(l.lookup(target, dns.TypeA), l.lookup(target, dns.TypeAAAA)...))
+		if len(addrs) == 0 && len(l.lookup(target, dns.TypeCNAME)) == 0 {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Your %s (%s) has no A or AAAA records in the zone.", kind, target),
+				Status: false, Name: "Address",
+			})
+		}
+
+		for _, ip := range addrs {
+			if isRFC1918(ip) {
+				ips = append(ips, ip.String())
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : No CNAMEs found for your %s records", kind),
+			Status: true, Name: "CNAME",
+		})
+	}
+
+	if len(ips) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: Some of your %s records have non-routable (RFC1918) addresses: %v", kind, ips),
+			Status: false, Name: "RFC1918",
+		})
+	}
+
+	return results
+}
+
+func (l *lint) ns() []ReportResult {
+	var (
+		results []ReportResult
+		targets []string
+	)
+
+	rrset := l.lookup(l.origin, dns.TypeNS)
+	for _, rr := range rrset {
+		targets = append(targets, rr.(*dns.NS).Ns)
+	}
+
+	if len(rrset) > 1 {
+		results = append(results, ReportResult{
+			Result: "OK  : Multiple nameservers found",
+			Status: true, Name: "Multiple",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: Only %v nameserver found. Extra nameservers increases reliability", len(rrset)),
+			Status: false, Name: "Multiple",
+		})
+	}
+
+	return append(results, l.targets("nameserver", targets)...)
+}
+
+func (l *lint) mx() []ReportResult {
+	var targets []string
+
+	rrset := l.lookup(l.origin, dns.TypeMX)
+	if len(rrset) == 0 {
+		return []ReportResult{{
+			Result: "INFO: No MX records found in the zone.",
+			Status: true, Name: "MX",
+		}}
+	}
+
+	for _, rr := range rrset {
+		targets = append(targets, rr.(*dns.MX).Mx)
+	}
+
+	return l.targets("MX", targets)
+}
+
+// web checks for CNAMEs at the apex and CNAMEs that have other data at the same name.
+func (l *lint) web() []ReportResult {
+	var results []ReportResult
+
+	if len(l.lookup(l.origin, dns.TypeCNAME)) > 0 {
+		results = append(results, ReportResult{
+			Result: "FAIL: Found a CNAME for the root record. It conflicts with the SOA and NS records.",
+			Status: false, Name: "ApexCNAME",
+		})
+	}
+
+	for _, name := range l.authNames() {
+		if name == l.origin || len(l.lookup(name, dns.TypeCNAME)) == 0 {
+			continue
+		}
+
+		for _, t := range l.types[name] {
+			if t != dns.TypeCNAME && t != dns.TypeNSEC {
+				results = append(results, ReportResult{
+					Result: fmt.Sprintf("FAIL: %s has a CNAME and other data (%s).", name, dns.TypeToString[t]),
+					Status: false, Name: "CNAMEConflict",
+				})
+
+				break
+			}
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: "OK  : Didn't find a CNAME for the root record or CNAMEs with other data",
+			Status: true, Name: "ApexCNAME",
+		})
+	}
+
+	return results
+}
+
+func (l *lint) spam() []ReportResult {
+	c := &SpamCheck{DMARCDomain: l.origin, Spam: []SpamData{{
+		Name:  "zone file",
+		Dmarc: l.lookup("_dmarc."+l.origin, dns.TypeTXT),
+		Spf:   spfRecords(l.lookup(l.origin, dns.TypeTXT)),
+	}}}
+
+	c.BIMI = BIMIData{Domain: l.origin, TXT: l.lookup("default._bimi."+l.origin, dns.TypeTXT)}
+
+	c.MTASTS = MTASTSData{
+		STS:    l.lookup("_mta-sts."+l.origin, dns.TypeTXT),
+		TLSRPT: l.lookup("_smtp._tls."+l.origin, dns.TypeTXT),
+	}
+
+	return c.Values()
+}
+
+// ttl checks the TTLs of the apex rrsets in the zone file.
+func (l *lint) ttl() []ReportResult {
+	var rrsets []TTLData
+
+	for _, qtype := range ttlTypes {
+		data := TTLData{Name: "zone file", Owner: l.origin, Type: dns.TypeToString[qtype], RRset: l.lookup(l.origin, qtype)}
+
+		for _, sig := range l.sigs[rrsetKey{l.origin, qtype}] {
+			data.Sigs = append(data.Sigs, sig)
+		}
+
+		rrsets = append(rrsets, data)
+	}
+
+	results := consistencyValues(rrsets)
+	results = append(results, limitValues(rrsets)...)
+
+	return append(results, dnskeyValues(rrsets)...)
+}
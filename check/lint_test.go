@@ -0,0 +1,149 @@
+package check
+
+import (
+	"strings"
+	"testing"
+)
+
+const lintZone = `$ORIGIN example.com.
+$TTL 3600
+@	IN SOA ns1 hostmaster 2024061501 7200 900 1209600 3600
+@	IN NS ns1
+@	IN NS ns2.example.net.
+@	IN MX 10 mail
+@	IN TXT "v=spf1 mx -all"
+_dmarc	IN TXT "v=DMARC1; p=reject; rua=mailto:dmarc@example.com"
+ns1	IN A 192.0.2.1
+mail	IN A 192.0.2.25
+www	IN CNAME @
+`
+
+func TestParseZone(t *testing.T) {
+	rrs, err := ParseZone(strings.NewReader(lintZone), "example.com", "zone")
+	if err != nil || len(rrs) != 9 {
+		t.Errorf("ParseZone = %d records, %v", len(rrs), err)
+	}
+
+	if _, err := ParseZone(strings.NewReader("@ IN SOA ns1"), "example.com", "zone"); err == nil {
+		t.Errorf("ParseZone of a bad record didn't fail")
+	}
+}
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		name    string
+		replace []string // old, new pairs applied to lintZone
+		want    []string
+		notWant []string
+	}{
+		{
+			name: "valid",
+			want: []string{
+				"OK  : Serial 2024061501 uses the YYYYMMDDnn scheme.",
+				"OK  : RNAME hostmaster.example.com. is a valid mailbox hostmaster@example.com",
+				"OK  : SOA timers are within the recommended ranges",
+				"OK  : MNAME ns1.example.com. is listed as NS in the zone.",
+				"OK  : Multiple nameservers found",
+				"INFO: No DNSKEY found at example.com.",
+			},
+			notWant: []string{"FAIL", "WARN"},
+		},
+		{
+			name:    "bad SOA timers",
+			replace: []string{"7200 900 1209600 3600", "600 900 3600 60"},
+			want: []string{
+				"WARN: SOA refresh 600 is lower than 1200 on [zone file]",
+				"WARN: SOA retry 900 is not lower than refresh 600 on [zone file]",
+				"WARN: SOA minimum 60 is lower than 300 on [zone file]",
+			},
+		},
+		{
+			name:    "bad serial",
+			replace: []string{"2024061501", "2024133201"},
+			want:    []string{"OK  : Serial 2024133201 uses the incrementing scheme.", "WARN: Serial 2024133201 looks like YYYYMMDDnn"},
+		},
+		{
+			name:    "bad RNAME",
+			replace: []string{"hostmaster 2024", "hostmaster.com. 2024"},
+			want:    []string{"FAIL: RNAME hostmaster.com."},
+		},
+		{
+			name:    "single NS, MNAME not listed",
+			replace: []string{"@	IN NS ns1\n", ""},
+			want:    []string{"WARN: Only 1 nameserver found.", "WARN: MNAME ns1.example.com. is not listed as NS in the zone."},
+		},
+		{
+			name:    "no SOA",
+			replace: []string{"@	IN SOA ns1 hostmaster 2024061501 7200 900 1209600 3600\n", ""},
+			want:    []string{"FAIL: Expected 1 SOA record at example.com., found 0."},
+		},
+		{
+			name:    "NS without address",
+			replace: []string{"ns1	IN A 192.0.2.1\n", ""},
+			want:    []string{"FAIL: Your nameserver (ns1.example.com.) has no A or AAAA records in the zone."},
+		},
+		{
+			name:    "RFC1918 MX",
+			replace: []string{"192.0.2.25", "10.0.0.25"},
+			want:    []string{"FAIL: Some of your MX records have non-routable (RFC1918) addresses: [10.0.0.25]"},
+		},
+		{
+			name:    "CNAME with other data",
+			replace: []string{"www	IN CNAME @\n", "www	IN CNAME @\nwww	IN TXT \"x\"\n"},
+			want:    []string{"FAIL: www.example.com. has a CNAME and other data (TXT)."},
+		},
+		{
+			name:    "SPF syntax error",
+			replace: []string{"v=spf1 mx -all", "v=spf1 mx foo -all"},
+			want:    []string{"FAIL: SPF"},
+		},
+		{
+			name:    "SPF without all",
+			replace: []string{"v=spf1 mx -all", "v=spf1 mx"},
+			want:    []string{"WARN: SPF record has no all mechanism"},
+			notWant: []string{"FAIL: SPF"},
+		},
+	}
+
+	for _, tt := range tests {
+		zone := lintZone
+		for i := 0; i+1 < len(tt.replace); i += 2 {
+			zone = strings.Replace(zone, tt.replace[i], tt.replace[i+1], 1)
+		}
+
+		rrs, err := ParseZone(strings.NewReader(zone), "example.com", "zone")
+		if err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+
+		var results []string
+
+		for _, rep := range Lint("example.com.", rrs).Report {
+			for _, res := range rep.Result {
+				results = append(results, res.Result)
+			}
+		}
+
+		for _, want := range tt.want {
+			found := false
+
+			for _, res := range results {
+				if strings.HasPrefix(res, want) {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("%s: no result %q in %q", tt.name, want, results)
+			}
+		}
+
+		for _, notWant := range tt.notWant {
+			for _, res := range results {
+				if strings.HasPrefix(res, notWant) {
+					t.Errorf("%s: unexpected result %q", tt.name, res)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,396 @@
+package check
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	mtastsMaxPolicySize = 64 * 1024
+	mtastsMaxAge        = 31557600 // RFC 8461 3.2
+	mtastsMinAge        = 86400    // shorter max_age values make the policy useless against downgrades
+)
+
+var mtastsID = regexp.MustCompile(`^[a-zA-Z0-9]{1,32}$`)
+
+// MTASTSPolicy is a parsed MTA-STS policy (RFC 8461 3.2).
+type MTASTSPolicy struct {
+	Text    string
+	Version string
+	Mode    string
+	MaxAge  int
+	MX      []string
+}
+
+// MTASTSData has the MTA-STS and TLS-RPT records, the fetched policy and the MX
+// hosts it is checked against.
+type MTASTSData struct {
+	STS         []dns.RR
+	TLSRPT      []dns.RR
+	Policy      *MTASTSPolicy
+	PolicyURL   string
+	PolicyError string   `json:",omitempty"`
+	MX          []string // MX hosts as seen by MXCheck
+}
+
+// tagList parses a "k=v; k=v" record and returns the tags in order.
+func tagList(record string) [][2]string {
+	var tags [][2]string
+
+	for _, tag := range strings.Split(record, ";") {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			tags = append(tags, [2]string{strings.TrimSpace(kv[0]), ""})
+			continue
+		}
+
+		tags = append(tags, [2]string{strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])})
+	}
+
+	return tags
+}
+
+// recordsWithVersion returns the TXT records that start with v=version.
+func recordsWithVersion(txt []dns.RR, version string) []dns.RR {
+	records := []dns.RR{}
+
+	for _, rr := range txt {
+		tags := tagList(txtString(rr))
+		if len(tags) > 0 && tags[0][0] == "v" && tags[0][1] == version {
+			records = append(records, rr)
+		}
+	}
+
+	return records
+}
+
+// ParseMTASTSRecord parses a _mta-sts TXT record (RFC 8461 3.1) and returns the id.
+func ParseMTASTSRecord(record string) (string, error) {
+	tags := tagList(record)
+	if len(tags) == 0 || tags[0][0] != "v" || tags[0][1] != "STSv1" {
+		return "", fmt.Errorf("record does not start with v=STSv1")
+	}
+
+	id := ""
+
+	for _, tag := range tags[1:] {
+		if tag[0] == "id" {
+			if id != "" {
+				return "", fmt.Errorf("id appears more than once")
+			}
+
+			id = tag[1]
+		}
+	}
+
+	if !mtastsID.MatchString(id) {
+		return "", fmt.Errorf("invalid or missing id=%s, must be 1-32 alphanumeric characters", id)
+	}
+
+	return id, nil
+}
+
+// ParseTLSRPT parses a _smtp._tls TXT record (RFC 8460 3) and returns the rua URIs.
+func ParseTLSRPT(record string) ([]string, error) {
+	tags := tagList(record)
+	if len(tags) == 0 || tags[0][0] != "v" || tags[0][1] != "TLSRPTv1" {
+		return nil, fmt.Errorf("record does not start with v=TLSRPTv1")
+	}
+
+	var rua []string
+
+	for _, tag := range tags[1:] {
+		if tag[0] != "rua" {
+			continue
+		}
+
+		for _, uri := range strings.Split(tag[1], ",") {
+			uri = strings.TrimSpace(uri)
+
+			switch {
+			case strings.HasPrefix(uri, "mailto:") && strings.Contains(uri, "@"):
+			case strings.HasPrefix(uri, "https://") && len(uri) > len("https://"):
+			default:
+				return nil, fmt.Errorf("invalid rua URI %s, must be mailto: or https:", uri)
+			}
+
+			rua = append(rua, uri)
+		}
+	}
+
+	if len(rua) == 0 {
+		return nil, fmt.Errorf("no rua= tag found")
+	}
+
+	return rua, nil
+}
+
+// ParseMTASTSPolicy parses an MTA-STS policy file.
+func ParseMTASTSPolicy(text string) (*MTASTSPolicy, error) {
+	p := &MTASTSPolicy{Text: text, MaxAge: -1}
+
+	s := bufio.NewScanner(strings.NewReader(text))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "" {
+			continue
+		}
+
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return p, fmt.Errorf("invalid line %q", line)
+		}
+
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		switch key {
+		case "version":
+			p.Version = value
+		case "mode":
+			p.Mode = value
+		case "max_age":
+			age, err := strconv.Atoi(value)
+			if err != nil || age < 0 || age > mtastsMaxAge {
+				return p, fmt.Errorf("invalid max_age %s", value)
+			}
+
+			p.MaxAge = age
+		case "mx":
+			p.MX = append(p.MX, strings.ToLower(value))
+		}
+	}
+
+	switch {
+	case p.Version != "STSv1":
+		return p, fmt.Errorf("version must be STSv1")
+	case p.Mode != "enforce" && p.Mode != "testing" && p.Mode != "none":
+		return p, fmt.Errorf("invalid mode %s", p.Mode)
+	case p.MaxAge < 0:
+		return p, fmt.Errorf("no max_age found")
+	case len(p.MX) == 0 && p.Mode != "none":
+		return p, fmt.Errorf("no mx found")
+	}
+
+	return p, nil
+}
+
+// FetchMTASTSPolicy fetches the policy of domain with client. Redirects are not
+// followed (RFC 8461 3.3).
+func FetchMTASTSPolicy(client *http.Client, domain string) (string, *MTASTSPolicy, error) {
+	url := "https://mta-sts." + strings.TrimSuffix(domain, ".") + "/.well-known/mta-sts.txt"
+
+	if client == nil {
+		client = &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return url, nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return url, nil, fmt.Errorf("HTTP status %s", resp.Status)
+	}
+
+	if ct, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); ct != "text/plain" {
+		return url, nil, fmt.Errorf("content type %q, must be text/plain", resp.Header.Get("Content-Type"))
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, mtastsMaxPolicySize))
+	if err != nil {
+		return url, nil, err
+	}
+
+	p, err := ParseMTASTSPolicy(string(body))
+
+	return url, p, err
+}
+
+// mtastsMatch matches an MX host against a policy mx pattern. A wildcard only
+// matches the leftmost label.
+func mtastsMatch(pattern, mx string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	mx = strings.ToLower(strings.TrimSuffix(mx, "."))
+
+	if strings.HasPrefix(pattern, "*.") {
+		i := strings.IndexByte(mx, '.')
+		return i > 0 && mx[i+1:] == pattern[2:]
+	}
+
+	return pattern == mx
+}
+
+func (d *MTASTSData) Values() []ReportResult {
+	var results []ReportResult
+
+	sts := recordsWithVersion(d.STS, "STSv1")
+
+	switch {
+	case len(sts) == 0 && d.Policy == nil:
+		return append(results, ReportResult{
+			Result: "INFO: No MTA-STS record found.",
+			Status: true, Name: "MTASTS",
+		})
+	case len(sts) == 0:
+		results = append(results, ReportResult{
+			Result: "WARN: MTA-STS policy found but no _mta-sts record, senders won't use the policy.",
+			Status: false, Name: "MTASTS",
+		})
+	case len(sts) > 1:
+		results = append(results, ReportResult{
+			Result: "FAIL: Multiple MTA-STS records found, senders will ignore them (RFC8461 3.1).",
+			Status: false, Name: "MTASTSSyntax",
+		})
+	default:
+		if id, err := ParseMTASTSRecord(txtString(sts[0])); err != nil {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: MTA-STS syntax error: %s", err),
+				Status: false, Records: []string{sts[0].String()}, Name: "MTASTSSyntax",
+			})
+		} else {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("OK  : MTA-STS record found with id %s.", id),
+				Status: true, Records: []string{sts[0].String()}, Name: "MTASTS",
+			})
+		}
+	}
+
+	results = append(results, d.tlsrptValues()...)
+
+	if d.PolicyError != "" {
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: MTA-STS policy %s failed: %s", d.PolicyURL, d.PolicyError),
+			Status: false, Name: "MTASTSPolicy",
+		})
+	}
+
+	if d.Policy == nil {
+		return results
+	}
+
+	results = append(results, ReportResult{
+		Result: fmt.Sprintf("OK  : MTA-STS policy found, mode %s, max_age %d.", d.Policy.Mode, d.Policy.MaxAge),
+		Status: true, Records: strings.Split(strings.TrimSpace(strings.ReplaceAll(d.Policy.Text, "\r", "")), "\n"), Name: "MTASTSPolicy",
+	})
+
+	if d.Policy.Mode != "enforce" {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: MTA-STS policy mode is %s, senders don't refuse delivery without valid TLS.", d.Policy.Mode),
+			Status: false, Name: "MTASTSMode",
+		})
+	}
+
+	if d.Policy.MaxAge < mtastsMinAge && d.Policy.Mode != "none" {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: MTA-STS max_age %d is shorter than a day.", d.Policy.MaxAge),
+			Status: false, Name: "MTASTSMaxAge",
+		})
+	}
+
+	return append(results, d.mxValues()...)
+}
+
+func (d *MTASTSData) tlsrptValues() []ReportResult {
+	rpt := recordsWithVersion(d.TLSRPT, "TLSRPTv1")
+
+	switch {
+	case len(rpt) == 0:
+		return []ReportResult{{
+			Result: "WARN: No TLS-RPT record found, you won't receive reports about TLS delivery failures.",
+			Status: false, Name: "TLSRPT",
+		}}
+	case len(rpt) > 1:
+		return []ReportResult{{
+			Result: "FAIL: Multiple TLS-RPT records found (RFC8460 3).",
+			Status: false, Name: "TLSRPTSyntax",
+		}}
+	}
+
+	rua, err := ParseTLSRPT(txtString(rpt[0]))
+	if err != nil {
+		return []ReportResult{{
+			Result: fmt.Sprintf("FAIL: TLS-RPT syntax error: %s", err),
+			Status: false, Records: []string{rpt[0].String()}, Name: "TLSRPTSyntax",
+		}}
+	}
+
+	return []ReportResult{{
+		Result: fmt.Sprintf("OK  : TLS-RPT record found, reports are sent to %s.", strings.Join(rua, ", ")),
+		Status: true, Records: []string{rpt[0].String()}, Name: "TLSRPT",
+	}}
+}
+
+// mxValues checks that every MX host matches the policy and that every pattern
+// is still used.
+func (d *MTASTSData) mxValues() []ReportResult {
+	var (
+		results   []ReportResult
+		unmatched []string
+	)
+
+	used := make(map[string]bool)
+
+	for _, mx := range d.MX {
+		matched := false
+
+		for _, pattern := range d.Policy.MX {
+			if mtastsMatch(pattern, mx) {
+				matched, used[pattern] = true, true
+			}
+		}
+
+		if !matched {
+			unmatched = append(unmatched, mx)
+		}
+	}
+
+	switch {
+	case len(unmatched) > 0 && d.Policy.Mode == "enforce":
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: MX %v not matched by the MTA-STS policy, senders will not deliver to them.", unmatched),
+			Status: false, Name: "MTASTSMX",
+		})
+	case len(unmatched) > 0:
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: MX %v not matched by the MTA-STS policy.", unmatched),
+			Status: false, Name: "MTASTSMX",
+		})
+	case len(d.MX) > 0:
+		results = append(results, ReportResult{
+			Result: "OK  : All MX records match the MTA-STS policy.",
+			Status: true, Name: "MTASTSMX",
+		})
+	}
+
+	for _, pattern := range d.Policy.MX {
+		if !used[pattern] && len(d.MX) > 0 {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: MTA-STS policy mx %s matches none of your MX records.", pattern),
+				Status: false, Name: "MTASTSMX",
+			})
+		}
+	}
+
+	return results
+}
@@ -0,0 +1,135 @@
+package check
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMTASTSMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		mx      string
+		want    bool
+	}{
+		{"mail.example.com", "mail.example.com.", true},
+		{"MAIL.example.com.", "mail.EXAMPLE.com", true},
+		{"mail.example.com", "mx.example.com", false},
+		{"*.example.com", "mx1.example.com.", true},
+		{"*.example.com", "a.b.example.com", false},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", ".example.com", false},
+		{"*.mail.example.com", "mx.mail.example.com", true},
+	}
+
+	for _, tt := range tests {
+		if got := mtastsMatch(tt.pattern, tt.mx); got != tt.want {
+			t.Errorf("mtastsMatch(%q, %q) = %v, want %v", tt.pattern, tt.mx, got, tt.want)
+		}
+	}
+}
+
+func TestParseMTASTSPolicy(t *testing.T) {
+	tests := []struct {
+		text string
+		mx   int
+		err  string
+	}{
+		{text: "version: STSv1\r\nmode: enforce\r\nmx: mail.example.com\r\nmx: *.example.net\r\nmax_age: 604800\r\n", mx: 2},
+		{text: "version: STSv1\nmode: none\nmax_age: 86400\n"},
+		{text: "version: STSv2\nmode: enforce\nmx: mail.example.com\nmax_age: 86400\n", err: "version"},
+		{text: "version: STSv1\nmode: strict\nmx: mail.example.com\nmax_age: 86400\n", err: "invalid mode"},
+		{text: "version: STSv1\nmode: enforce\nmx: mail.example.com\n", err: "max_age"},
+		{text: "version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 99999999999\n", err: "invalid max_age"},
+		{text: "version: STSv1\nmode: testing\nmax_age: 86400\n", err: "no mx"},
+		{text: "version STSv1\n", err: "invalid line"},
+	}
+
+	for _, tt := range tests {
+		p, err := ParseMTASTSPolicy(tt.text)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseMTASTSPolicy(%q) error = %v, want %q", tt.text, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseMTASTSPolicy(%q) error = %s", tt.text, err)
+			continue
+		}
+
+		if len(p.MX) != tt.mx {
+			t.Errorf("ParseMTASTSPolicy(%q) mx = %v, want %d", tt.text, p.MX, tt.mx)
+		}
+	}
+}
+
+// policyClient returns a client that sends every request to srv.
+func policyClient(srv *httptest.Server) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, srv.Listener.Addr().String())
+			},
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true}, //nolint:gosec // test server
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func TestFetchMTASTSPolicy(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Host {
+		case "mta-sts.example.com":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			_, _ = w.Write([]byte("version: STSv1\nmode: enforce\nmx: mail.example.com\nmax_age: 604800\n"))
+		case "mta-sts.html.example":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html></html>"))
+		case "mta-sts.moved.example":
+			http.Redirect(w, r, "https://mta-sts.example.com/.well-known/mta-sts.txt", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		domain string
+		err    string
+	}{
+		{domain: "example.com."},
+		{domain: "html.example", err: "content type"},
+		{domain: "moved.example", err: "302"},
+		{domain: "missing.example", err: "404"},
+	}
+
+	for _, tt := range tests {
+		url, p, err := FetchMTASTSPolicy(policyClient(srv), tt.domain)
+
+		if want := "https://mta-sts." + strings.TrimSuffix(tt.domain, ".") + "/.well-known/mta-sts.txt"; url != want {
+			t.Errorf("FetchMTASTSPolicy(%s) url = %s, want %s", tt.domain, url, want)
+		}
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("FetchMTASTSPolicy(%s) error = %v, want %q", tt.domain, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil || p == nil || p.Mode != "enforce" {
+			t.Errorf("FetchMTASTSPolicy(%s) = %v, %v", tt.domain, p, err)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/42wim/dt/scan"
 	"github.com/42wim/dt/structs"
@@ -16,8 +17,25 @@ type MXCheck struct {
 	MX     []MXData
 	MXIP   map[string][]net.IP // cache mx ip records
 	MXIPRR map[string][]dns.RR // cache raw A/AAAA responses so we can extract CNAMEs if needed
+	Probe  bool                // connect to every MX ip on port 25
+	SPF    *SPFRecord          // SPF record, looked up for a null MX
+	SPFErr string              // error of the SPF lookup
+	Lame   map[string][]string // lame nameservers of the zones of out-of-zone MX targets
+	Probes []MXProbe
+	Dialer Dialer
 	Report
-	s *scan.Scan
+	s       *scan.Scan
+	scanned string // domain the MX records were scanned for
+}
+
+// MXProbe is the result of connecting to an MX ip on port 25.
+type MXProbe struct {
+	MX string
+	IP string
+	SMTPProbe
+	CertNameMatch bool
+	PTR           []string
+	BannerHost    string
 }
 
 type MXData struct {
@@ -29,14 +47,22 @@ type MXData struct {
 
 func NewMX(s *scan.Scan, ns []structs.NSData) *MXCheck {
 	c := &MXCheck{
-		s:  s,
-		NS: ns,
+		s:      s,
+		NS:     ns,
+		Dialer: defaultDialer(),
 	}
 
 	return c
 }
 
+// Scan gets the MX records and their addresses, only once per domain so the MX
+// scan can be shared with other checks.
 func (c *MXCheck) Scan(domain string) {
+	if c.scanned == domain {
+		return
+	}
+
+	c.scanned = domain
 	c.MXIP = make(map[string][]net.IP)
 	c.MXIPRR = make(map[string][]dns.RR)
 
@@ -57,18 +83,16 @@ func (c *MXCheck) Scan(domain string) {
 			for _, mxRR := range data.MX {
 				mx := mxRR.(*dns.MX).Mx
 
-				if _, ok := c.MXIP[mx]; !ok {
-					res, err := scan.Query(dns.Fqdn(mx), dns.TypeA, c.s.Resolver(), true)
-					if err != nil {
-						break
-					}
+				if _, ok := c.MXIP[mx]; ok || mx == "." {
+					continue
+				}
 
-					c.MXIP[mx] = append(c.MXIP[mx], extractIP(res.Msg.Answer)...)
-					c.MXIPRR[mx] = append(c.MXIPRR[mx], res.Msg.Answer...)
+				c.MXIP[mx] = []net.IP{}
 
-					res, err = scan.Query(dns.Fqdn(mx), dns.TypeAAAA, c.s.Resolver(), true)
+				for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+					res, err := scan.Query(dns.Fqdn(mx), qtype, c.s.Resolver(), true)
 					if err != nil {
-						break
+						continue
 					}
 
 					c.MXIP[mx] = append(c.MXIP[mx], extractIP(res.Msg.Answer)...)
@@ -81,6 +105,39 @@ func (c *MXCheck) Scan(domain string) {
 	}
 }
 
+// sharedMX returns mx scanned for domain, or a new MX scan if mx is nil.
+func sharedMX(s *scan.Scan, ns []structs.NSData, mx *MXCheck, domain string) *MXCheck {
+	if mx == nil {
+		mx = NewMX(s, ns)
+	}
+
+	mx.Scan(domain)
+
+	return mx
+}
+
+// rrset returns the MX records of the first nameserver that answered.
+func (c *MXCheck) rrset() []dns.RR {
+	for _, ns := range c.MX {
+		if ns.MX != nil {
+			return ns.MX
+		}
+	}
+
+	return nil
+}
+
+// isNullMX returns true if rrset has a null MX record "0 ." (RFC 7505).
+func isNullMX(rrset []dns.RR) bool {
+	for _, rr := range rrset {
+		if mx, ok := rr.(*dns.MX); ok && mx.Mx == "." {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *MXCheck) Identical() ReportResult {
 	m := make(map[string][]string)
 
@@ -175,58 +232,146 @@ func (c *MXCheck) CheckReverse() []ReportResult {
 	log.Debugf("MX: reverse")
 	defer log.Debugf("MX: reverse exit")
 
-	rep := []ReportResult{}
-	m := make(map[string]bool)
+	var (
+		names   []string
+		results []FCrDNS
+	)
 
-	if len(c.MXIP) > 0 {
-		for name, ips := range c.MXIP {
-			for _, ip := range ips {
-				rev, _ := dns.ReverseAddr(ip.String())
+	for name := range c.MXIP {
+		names = append(names, name)
+	}
 
-				res, _, err := scan.QueryRRset(rev, dns.TypePTR, c.s.Resolver(), true)
-				if err != nil {
-					break
-				}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, ip := range c.MXIP[name] {
+			results = append(results, fcrdns(c.s, name, ip))
+		}
+	}
+
+	return fcrdnsValues("MX", results)
+}
+
+// ScanProbe connects to every MX ip, checks STARTTLS and compares the certificate
+// with the MX name and the banner with the PTR record.
+func (c *MXCheck) ScanProbe() {
+	log.Debugf("MX: probe")
+	defer log.Debugf("MX: probe exit")
+
+	var names []string
+
+	for name := range c.MXIP {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, ip := range c.MXIP[name] {
+			p := MXProbe{MX: name, IP: ip.String(), SMTPProbe: probeSMTP(c.Dialer, ip, name)}
 
-				if len(res) > 0 {
-					m[name] = true
-				} else {
-					m[name] = false
+			if p.TLS && len(p.Certs) > 0 {
+				p.CertNameMatch = p.Certs[0].VerifyHostname(strings.TrimSuffix(name, ".")) == nil
+			}
+
+			if fields := strings.Fields(p.Banner); len(fields) > 0 {
+				p.BannerHost = strings.ToLower(strings.TrimSuffix(fields[0], "."))
+			}
+
+			rev, _ := dns.ReverseAddr(ip.String())
+
+			ptr, _, err := scan.QueryRRset(rev, dns.TypePTR, c.s.Resolver(), true)
+			if err == nil {
+				for _, rr := range ptr {
+					p.PTR = append(p.PTR, strings.ToLower(strings.TrimSuffix(rr.(*dns.PTR).Ptr, ".")))
 				}
 			}
+
+			c.Probes = append(c.Probes, p)
 		}
 	}
+}
+
+func (c *MXCheck) CheckProbe() []ReportResult {
+	rep := []ReportResult{}
 
-	for name, reverse := range m {
-		if !reverse {
+	for _, p := range c.Probes {
+		if p.ConnectError != "" {
 			rep = append(rep, ReportResult{
-				Result: fmt.Sprintf("WARN: Reverse PTR lookup for MX %s failed.", name),
-				Status: false, Name: "Reverse",
+				Result: fmt.Sprintf("ERR : MX %s (%s) is not reachable on port 25: %s", p.MX, p.IP, p.ConnectError),
+				Status: false, Name: "Probe",
 			})
+
+			continue
+		}
+
+		if p.Banner == "" {
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("FAIL: MX %s (%s) accepted the connection in %s but sent no SMTP greeting: %s", p.MX, p.IP, p.ConnectTime.Round(time.Millisecond), p.Error),
+				Status: false, Name: "Probe",
+			})
+
+			continue
 		}
-	}
 
-	if len(rep) == 0 {
 		rep = append(rep, ReportResult{
-			Result: "OK  : All MX records have reverse PTR records",
-			Status: true, Name: "Reverse",
+			Result: fmt.Sprintf("OK  : MX %s (%s) connected in %s", p.MX, p.IP, p.ConnectTime.Round(time.Millisecond)),
+			Status: true, Records: []string{p.Banner}, Name: "Probe",
 		})
+
+		switch {
+		case !p.StartTLS && p.Error != "":
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("FAIL: MX %s (%s) SMTP session failed: %s", p.MX, p.IP, p.Error),
+				Status: false, Name: "STARTTLS",
+			})
+		case !p.StartTLS:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: MX %s (%s) doesn't offer STARTTLS", p.MX, p.IP),
+				Status: false, Name: "STARTTLS",
+			})
+		case !p.TLS:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("FAIL: MX %s (%s) STARTTLS failed: %s", p.MX, p.IP, p.Error),
+				Status: false, Name: "STARTTLS",
+			})
+		case p.TLSVersion == "TLS 1.0" || p.TLSVersion == "TLS 1.1" || p.TLSVersion == "":
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: MX %s (%s) negotiated an outdated TLS version %s", p.MX, p.IP, p.TLSVersion),
+				Status: false, Name: "STARTTLS",
+			})
+		default:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("OK  : MX %s (%s) supports STARTTLS with %s", p.MX, p.IP, p.TLSVersion),
+				Status: true, Name: "STARTTLS",
+			})
+		}
+
+		if p.TLS && !p.CertNameMatch {
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: Certificate of MX %s (%s) doesn't match the MX name", p.MX, p.IP),
+				Status: false, Name: "CertName",
+			})
+		}
+
+		if !hasValue(p.PTR, p.BannerHost) {
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: Banner hostname %s of MX %s (%s) doesn't match the PTR %v", p.BannerHost, p.MX, p.IP, p.PTR),
+				Status: false, Name: "BannerPTR",
+			})
+		}
 	}
 
 	return rep
 }
 
 func (c *MXCheck) Values() []ReportResult {
-	var (
-		results []ReportResult
-		rrset   []dns.RR
-	)
+	var results []ReportResult
 
-	for _, ns := range c.MX {
-		if ns.MX != nil {
-			rrset = ns.MX
-			break
-		}
+	rrset := c.rrset()
+
+	if isNullMX(rrset) {
+		return c.nullMXValues(rrset)
 	}
 
 	if len(rrset) > 1 {
@@ -282,14 +427,219 @@ func (c *MXCheck) Values() []ReportResult {
 	return results
 }
 
+// nullMXValues checks a null MX (RFC 7505): it must be the only MX record and the
+// domain should not send mail either.
+func (c *MXCheck) nullMXValues(rrset []dns.RR) []ReportResult {
+	var results []ReportResult
+
+	records := []string{}
+	for _, rr := range rrset {
+		records = append(records, rr.String())
+	}
+
+	if len(rrset) > 1 || rrset[0].(*dns.MX).Preference != 0 {
+		return append(results, ReportResult{
+			Result: "FAIL: A null MX must be the only MX record and have preference 0 (RFC7505 3).",
+			Status: false, Records: records, Name: "NullMX",
+		})
+	}
+
+	results = append(results, ReportResult{
+		Result: "OK  : Null MX found, your domain doesn't accept mail (RFC7505)",
+		Status: true, Records: records, Name: "NullMX",
+	})
+
+	switch {
+	case c.SPFErr != "":
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: Domain with a null MX has no valid SPF record: %s. Publish \"v=spf1 -all\" (RFC7505 4.2).", c.SPFErr),
+			Status: false, Name: "NullMXSPF",
+		})
+	case c.SPF == nil:
+		// not scanned
+	case c.SPF.Redirect != "" || len(c.SPF.Terms) != 1 || c.SPF.Terms[0].Mechanism != "all" || c.SPF.Terms[0].Qualifier != '-':
+		results = append(results, ReportResult{
+			Result: "WARN: Domain with a null MX allows mail to be sent, its SPF record should be \"v=spf1 -all\" (RFC7505 4.2).",
+			Status: false, Records: []string{c.SPF.Text}, Name: "NullMXSPF",
+		})
+	default:
+		results = append(results, ReportResult{
+			Result: "OK  : Domain with a null MX doesn't send mail (v=spf1 -all)",
+			Status: true, Records: []string{c.SPF.Text}, Name: "NullMXSPF",
+		})
+	}
+
+	return results
+}
+
+// ScanTargets looks up the SPF record for a null MX and the lame nameservers of the
+// zones of MX targets outside domain.
+func (c *MXCheck) ScanTargets(domain string) {
+	log.Debugf("MX: targets")
+	defer log.Debugf("MX: targets exit")
+
+	c.Lame = make(map[string][]string)
+
+	if isNullMX(c.rrset()) {
+		rec, err := NewSPF(c.s).Record(domain)
+		if err != nil {
+			c.SPFErr = err.Error()
+		}
+
+		c.SPF = rec
+
+		return
+	}
+
+	seen := make(map[string]bool)
+
+	for _, rr := range c.rrset() {
+		mx := rr.(*dns.MX).Mx
+		if dns.IsSubDomain(dns.Fqdn(domain), mx) || net.ParseIP(mxLiteral(mx)) != nil {
+			continue
+		}
+
+		zone, err := zoneOf(c.s, mx)
+		if err != nil || seen[zone] {
+			continue
+		}
+
+		seen[zone] = true
+
+		lame, err := lameNS(c.s, zone)
+		if err != nil {
+			c.Lame[zone] = []string{err.Error()}
+			continue
+		}
+
+		if len(lame) > 0 {
+			c.Lame[zone] = lame
+		}
+	}
+}
+
+// mxLiteral strips the trailing dot and brackets of an MX target so it can be parsed
+// as an ip.
+func mxLiteral(mx string) string {
+	return strings.Trim(strings.TrimSuffix(mx, "."), "[]")
+}
+
+// CheckTargets checks the MX targets: ip literals, targets without addresses,
+// lame zones and targets of the same preference.
+func (c *MXCheck) CheckTargets() []ReportResult {
+	rep := []ReportResult{}
+
+	rrset := c.rrset()
+	if isNullMX(rrset) {
+		return rep
+	}
+
+	var targets []string
+
+	count := make(map[string]int)
+	prefs := make(map[uint16][]string)
+
+	for _, rr := range rrset {
+		mx := rr.(*dns.MX)
+		prefs[mx.Preference] = append(prefs[mx.Preference], mx.Mx)
+
+		if count[strings.ToLower(mx.Mx)]++; count[strings.ToLower(mx.Mx)] > 1 {
+			continue
+		}
+
+		targets = append(targets, strings.ToLower(mx.Mx))
+
+		switch {
+		case net.ParseIP(mxLiteral(mx.Mx)) != nil:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("FAIL: MX %s is an ip address, MX targets must be hostnames (RFC5321 5.1).", mx.Mx),
+				Status: false, Name: "Target",
+			})
+		case len(c.MXIP[mx.Mx]) == 0:
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("FAIL: MX %s has no A or AAAA records.", mx.Mx),
+				Status: false, Name: "Target",
+			})
+		}
+	}
+
+	for _, mx := range targets {
+		if count[mx] > 1 {
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("WARN: MX %s is listed %d times with different preferences.", mx, count[mx]),
+				Status: false, Name: "Target",
+			})
+		}
+	}
+
+	var levels []int
+	for pref := range prefs {
+		levels = append(levels, int(pref))
+	}
+
+	sort.Ints(levels)
+
+	for _, pref := range levels {
+		hosts := prefs[uint16(pref)]
+		if len(hosts) < 2 {
+			continue
+		}
+
+		var dead []string
+
+		for _, mx := range hosts {
+			if len(c.MXIP[mx]) == 0 {
+				dead = append(dead, mx)
+			}
+		}
+
+		if len(dead) > 0 {
+			rep = append(rep, ReportResult{
+				Result: fmt.Sprintf("FAIL: MX records with preference %d %v are load balanced, but %v can't receive mail.", pref, hosts, dead),
+				Status: false, Name: "Preference",
+			})
+		}
+	}
+
+	var zones []string
+	for zone := range c.Lame {
+		zones = append(zones, zone)
+	}
+
+	sort.Strings(zones)
+
+	for _, zone := range zones {
+		rep = append(rep, ReportResult{
+			Result: fmt.Sprintf("FAIL: Zone %s of your MX has lame nameservers.", zone),
+			Status: false, Records: c.Lame[zone], Name: "Lame",
+		})
+	}
+
+	if len(rep) == 0 {
+		rep = append(rep, ReportResult{
+			Result: "OK  : All your MX targets are hostnames with addresses.",
+			Status: true, Name: "Target",
+		})
+	}
+
+	return rep
+}
+
 func (c *MXCheck) CreateReport(domain string) Report {
 	c.Scan(domain)
 
 	c.Report.Type = "MX"
 	c.Report.Result = append(c.Report.Result, c.Identical())
+	c.ScanTargets(domain)
 	c.Report.Result = append(c.Report.Result, c.Values()...)
+	c.Report.Result = append(c.Report.Result, c.CheckTargets()...)
 	c.Report.Result = append(c.Report.Result, c.CheckCNAME()...)
 	c.Report.Result = append(c.Report.Result, c.CheckReverse()...)
 
+	if c.Probe {
+		c.ScanProbe()
+		c.Report.Result = append(c.Report.Result, c.CheckProbe()...)
+	}
+
 	return c.Report
 }
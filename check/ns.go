@@ -15,6 +15,8 @@ type NSCheck struct {
 	NS      []structs.NSData
 	NSCheck []NSCheckData
 	CacheIP map[string][]net.IP
+	// ParentScan has the delegations of the parent, nil asks the parent again
+	ParentScan *DelegationScan
 	Report
 	s *scan.Scan
 }
@@ -119,38 +121,29 @@ func (c *NSCheck) CheckParent(domain string) []ReportResult {
 
 	var rep []ReportResult
 
-	nsdata, err := c.s.FindNS(getParentDomain(domain))
+	delegations, err := sharedDelegations(c.s, c.ParentScan, domain)
 	if err != nil {
 		return []ReportResult{}
 	}
 
-	var rrset []dns.RR
-loop:
-	for _, ns := range nsdata {
-		for _, nsip := range ns.IP {
-			res, err := scan.Query(dns.Fqdn(domain), dns.TypeNS, nsip.String(), true)
-			if err != nil {
-				break
-			}
-			rrset = extractRR(res.Msg.Ns, dns.TypeNS)
-			if len(rrset) > 0 {
-				break loop
-			}
-		}
-	}
-
+	// the NS records of every parent nameserver, the differences between them are
+	// reported by the glue check
 	m := make(map[string]bool)
-	for _, rr := range rrset {
-		m[dns.Fqdn(rr.(*dns.NS).Ns)] = true
+
+	for _, d := range delegations {
+		for _, rr := range d.NS {
+			m[strings.ToLower(dns.Fqdn(rr.(*dns.NS).Ns))] = true
+		}
 	}
 
 	missing := []string{}
 
 	for _, ns := range c.NS {
-		if _, ok := m[ns.Name]; !ok {
+		name := strings.ToLower(dns.Fqdn(ns.Name))
+		if _, ok := m[name]; !ok {
 			missing = append(missing, ns.Name)
 		} else {
-			m[ns.Name] = false
+			m[name] = false
 		}
 	}
 
@@ -190,6 +183,21 @@ loop:
 	return rep
 }
 
+func (c *NSCheck) CheckReverse() []ReportResult {
+	log.Debugf("NS: CheckReverse")
+	defer log.Debugf("NS: CheckReverse exit")
+
+	var results []FCrDNS
+
+	for _, ns := range c.NS {
+		for _, ip := range ns.IP {
+			results = append(results, fcrdns(c.s, ns.Name, ip))
+		}
+	}
+
+	return fcrdnsValues("NS", results)
+}
+
 func (c *NSCheck) Identical() ReportResult {
 	m := make(map[string][]string)
 
@@ -423,6 +431,7 @@ func (c *NSCheck) CreateReport(domain string) Report {
 	c.Report.Result = append(c.Report.Result, c.Recursive()...)
 	c.Report.Result = append(c.Report.Result, c.CheckParent(domain)...)
 	c.Report.Result = append(c.Report.Result, c.CheckCNAME()...)
+	c.Report.Result = append(c.Report.Result, c.CheckReverse()...)
 
 	return c.Report
 }
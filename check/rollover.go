@@ -0,0 +1,293 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// RolloverCheck shows the state of every DNSKEY of a domain during a key rollover.
+type RolloverCheck struct {
+	NS         []structs.NSData
+	Keys       []KeyState
+	ChainValid bool
+	DNSKEYTTL  uint32
+	DSTTL      uint32
+	SigTTL     uint32 // highest original TTL of the RRSIGs seen
+	Report
+	s *scan.Scan
+}
+
+type KeyState struct {
+	KeyTag    uint16
+	Algorithm uint8
+	Flags     uint16
+	Role      string // KSK, ZSK or CSK
+	State     string // published, active, standby, retired or revoked
+	Published bool
+	DS        bool
+	SignsKeys bool
+	SignsZone bool
+}
+
+const (
+	KeyPublished = "published"
+	KeyActive    = "active"
+	KeyStandby   = "standby"
+	KeyRetired   = "retired"
+	KeyRevoked   = "revoked"
+)
+
+func NewRollover(s *scan.Scan, ns []structs.NSData) *RolloverCheck {
+	c := &RolloverCheck{
+		s:  s,
+		NS: ns,
+	}
+
+	return c
+}
+
+func (c *RolloverCheck) Scan(domain string) {
+	log.Debugf("Rollover: scan")
+	defer log.Debugf("Rollover: scan exit")
+
+	keyMap := make(map[uint16]*dns.DNSKEY)
+	signsKeys := make(map[uint16]bool)
+	signsZone := make(map[uint16]bool)
+
+	for _, ns := range c.NS {
+		for _, nsip := range ns.IP {
+			res, err := c.s.LookupDNSKEY(domain, nsip.String(), keyMap)
+			if err != nil {
+				c.Report.Result = append(c.Report.Result, ReportResult{
+					Result: fmt.Sprintf("ERR : DNSKEY lookup failed on %s (%s): %s", ns.Name, nsip.String(), err),
+					Status: false, Name: "DNSKEY",
+				})
+
+				continue
+			}
+
+			keys := extractRRMsg(res.Msg, dns.TypeDNSKEY)
+			for _, rr := range keys {
+				c.DNSKEYTTL = rr.Header().Ttl
+			}
+
+			c.markSigners(keyMap, keys, extractRRMsg(res.Msg, dns.TypeRRSIG), signsKeys)
+
+			res, err = scan.Query(domain, dns.TypeSOA, nsip.String(), true)
+			if err == nil {
+				c.markSigners(keyMap, extractRRMsg(res.Msg, dns.TypeSOA), extractRRMsg(res.Msg, dns.TypeRRSIG), signsZone)
+			}
+		}
+	}
+
+	var keys []dns.RR
+
+	for _, key := range keyMap {
+		keys = append(keys, key)
+	}
+
+	parentDS := getParentDS(c.s, domain)
+	dsTags := make(map[uint16]bool)
+
+	for _, rr := range parentDS {
+		ds := rr.(*dns.DS)
+		c.DSTTL = ds.Hdr.Ttl
+		dsTags[ds.KeyTag] = true
+	}
+
+	inDS := make(map[uint16]bool)
+	for _, key := range keysInDS(keys, parentDS) {
+		inDS[key.KeyTag()] = true
+	}
+
+	// roles that have a key signing with it
+	active := make(map[string]bool)
+
+	for tag, key := range keyMap {
+		ks := KeyState{
+			KeyTag: tag, Algorithm: key.Algorithm, Flags: key.Flags,
+			Published: true, DS: inDS[tag], SignsKeys: signsKeys[tag], SignsZone: signsZone[tag],
+		}
+
+		ks.Role = keyRole(ks)
+		c.Keys = append(c.Keys, ks)
+
+		if ks.SignsKeys {
+			active["KSK"] = true
+		}
+
+		if ks.SignsZone {
+			active["ZSK"] = true
+		}
+	}
+
+	for i := range c.Keys {
+		c.Keys[i].State = keyStateOf(c.Keys[i], active)
+	}
+
+	// DS records at the parent without a published DNSKEY
+	for tag := range dsTags {
+		if _, ok := keyMap[tag]; !ok {
+			c.Keys = append(c.Keys, KeyState{KeyTag: tag, Role: "KSK", State: KeyRetired, DS: true})
+		}
+	}
+
+	sort.Slice(c.Keys, func(i, j int) bool { return c.Keys[i].KeyTag < c.Keys[j].KeyTag })
+
+	c.ChainValid, _ = c.s.ValidateParentDS(domain, keyMap)
+}
+
+// markSigners sets signers[keytag] for every key that has a valid RRSIG over rrset.
+func (c *RolloverCheck) markSigners(keyMap map[uint16]*dns.DNSKEY, rrset, sigs []dns.RR, signers map[uint16]bool) {
+	if len(rrset) == 0 {
+		return
+	}
+
+	for _, rr := range sigs {
+		sig := rr.(*dns.RRSIG)
+		if sig.TypeCovered != rrset[0].Header().Rrtype {
+			continue
+		}
+
+		if sig.OrigTtl > c.SigTTL {
+			c.SigTTL = sig.OrigTtl
+		}
+
+		key, ok := keyMap[sig.KeyTag]
+		if !ok {
+			continue
+		}
+
+		if sig.Verify(key, rrset) == nil && sig.ValidityPeriod(time.Now()) {
+			signers[sig.KeyTag] = true
+		}
+	}
+}
+
+func keyRole(ks KeyState) string {
+	switch {
+	case ks.SignsKeys && ks.SignsZone:
+		return "CSK"
+	case ks.Flags&dns.SEP != 0:
+		return "KSK"
+	default:
+		return "ZSK"
+	}
+}
+
+// keyStateOf returns the rollover state of a key. A published key without DS that
+// doesn't sign while another key of its role does is retired, or pre-published for
+// the next rollover: the DNS can't tell them apart.
+func keyStateOf(ks KeyState, active map[string]bool) string {
+	switch {
+	case ks.Flags&dns.REVOKE != 0:
+		return KeyRevoked
+	case !ks.Published:
+		return KeyRetired
+	case ks.SignsKeys || ks.SignsZone:
+		return KeyActive
+	case ks.DS:
+		return KeyStandby
+	case active[ks.Role]:
+		return KeyRetired
+	default:
+		return KeyPublished
+	}
+}
+
+func ttlString(ttl uint32) string {
+	return (time.Duration(ttl) * time.Second).String()
+}
+
+func (c *RolloverCheck) Values() []ReportResult {
+	var results []ReportResult
+
+	if len(c.Keys) == 0 {
+		return []ReportResult{{
+			Result: "INFO: No DNSKEY records found.",
+			Status: true, Name: "Rollover",
+		}}
+	}
+
+	if c.ChainValid {
+		results = append(results, ReportResult{
+			Result: "OK  : A published DNSKEY matches the DS records at the parent.",
+			Status: true, Name: "Chain",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: "FAIL: No published DNSKEY matches the DS records at the parent.",
+			Status: false, Name: "Chain",
+		})
+	}
+
+	var keySigners, zoneSigners, dsKeys int
+
+	for _, ks := range c.Keys {
+		if ks.SignsKeys {
+			keySigners++
+		}
+
+		if ks.SignsZone {
+			zoneSigners++
+		}
+
+		if ks.DS && ks.Published {
+			dsKeys++
+		}
+	}
+
+	for _, ks := range c.Keys {
+		results = append(results, c.removal(ks, keySigners, zoneSigners, dsKeys))
+	}
+
+	return results
+}
+
+// removal returns whether removing the key now would break the chain.
+func (c *RolloverCheck) removal(ks KeyState, keySigners, zoneSigners, dsKeys int) ReportResult {
+	res := ReportResult{Name: "Removal"}
+
+	switch {
+	case ks.State == KeyRevoked:
+		res.Result = fmt.Sprintf("INFO: key %d (%s) is revoked. Keep it published for the RFC 5011 hold-down time (30 days) before removing it.", ks.KeyTag, ks.Role)
+		res.Status = true
+	case ks.State == KeyRetired && !ks.Published:
+		res.Result = fmt.Sprintf("WARN: DS for key %d is still at the parent but the key is not published. Remove the DS from the parent.", ks.KeyTag)
+	case ks.State == KeyRetired && ks.Role == "KSK":
+		res.Result = fmt.Sprintf("OK  : key %d (%s) is retired: it has no DS and doesn't sign. It can be removed once %s (DS TTL) has passed since its DS was removed. If it is pre-published instead, wait %s (DNSKEY TTL) before adding its DS.", ks.KeyTag, ks.Role, ttlString(c.DSTTL), ttlString(c.DNSKEYTTL))
+		res.Status = true
+	case ks.State == KeyRetired:
+		res.Result = fmt.Sprintf("OK  : key %d (%s) is retired: it doesn't sign. It can be removed once %s (RRSIG TTL) has passed since it last signed. If it is pre-published instead, wait %s (DNSKEY TTL) before signing with it.", ks.KeyTag, ks.Role, ttlString(c.SigTTL), ttlString(c.DNSKEYTTL))
+		res.Status = true
+	case ks.DS && dsKeys == 1:
+		res.Result = fmt.Sprintf("WARN: removing key %d (%s) now would break the chain: it is the only key matching a DS at the parent. Add the DS of a new key and wait %s (DS TTL) and %s (DNSKEY TTL) after publishing the new key before removing it.", ks.KeyTag, ks.Role, ttlString(c.DSTTL), ttlString(c.DNSKEYTTL))
+	case ks.SignsKeys && keySigners == 1:
+		res.Result = fmt.Sprintf("WARN: removing key %d (%s) now would break the chain: it is the only key signing the DNSKEY rrset. Publish a new key, wait %s (DNSKEY TTL), sign with it and wait %s (RRSIG TTL) before removing it.", ks.KeyTag, ks.Role, ttlString(c.DNSKEYTTL), ttlString(c.SigTTL))
+	case ks.SignsZone && zoneSigners == 1:
+		res.Result = fmt.Sprintf("WARN: removing key %d (%s) now would break the chain: it is the only key signing the zone data. Publish a new key, wait %s (DNSKEY TTL), sign with it and wait %s (RRSIG TTL) before removing it.", ks.KeyTag, ks.Role, ttlString(c.DNSKEYTTL), ttlString(c.SigTTL))
+	case ks.SignsKeys || ks.SignsZone:
+		res.Result = fmt.Sprintf("WARN: key %d (%s) still has signatures. Stop signing with it and wait %s (RRSIG TTL) before removing it.", ks.KeyTag, ks.Role, ttlString(c.SigTTL))
+	case ks.DS:
+		res.Result = fmt.Sprintf("WARN: key %d (%s) has a DS at the parent. Remove the DS and wait %s (DS TTL) before removing it.", ks.KeyTag, ks.Role, ttlString(c.DSTTL))
+	default:
+		res.Result = fmt.Sprintf("OK  : key %d (%s) is not used in the chain and can be removed once %s (RRSIG TTL) has passed since it last signed.", ks.KeyTag, ks.Role, ttlString(c.SigTTL))
+		res.Status = true
+	}
+
+	return res
+}
+
+func (c *RolloverCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "Rollover"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
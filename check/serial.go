@@ -0,0 +1,207 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+// serialLess returns true if serial a is older than b using serial number
+// arithmetic (RFC 1982 3.2). Serials that are exactly 2^31 apart are undefined and
+// neither is less than the other.
+func serialLess(a, b uint32) bool {
+	return a != b && b-a < 1<<31
+}
+
+// newestSerial returns the newest serial of serials.
+func newestSerial(serials []uint32) uint32 {
+	newest := serials[0]
+
+	for _, serial := range serials[1:] {
+		if serialLess(newest, serial) {
+			newest = serial
+		}
+	}
+
+	return newest
+}
+
+// SerialWatch is the result of watching the serials of all servers until they
+// converge on the same serial.
+type SerialWatch struct {
+	Start     time.Time                // time the new serial was first seen
+	Serial    uint32                   // serial the servers converge on
+	Converged map[string]time.Duration // time it took every server to reach Serial
+	Rollback  []string                 // servers that went back to an older serial
+	Done      bool                     // all servers converged before the timeout
+}
+
+// serialDivergence compares the serials of all servers and reports the servers that
+// are behind the newest serial. The primary (MNAME) being behind means the serial
+// was rolled back.
+func (c *SOACheck) serialDivergence() []ReportResult {
+	var (
+		results []ReportResult
+		serials []uint32
+		mname   string
+	)
+
+	for _, ns := range c.SOA {
+		if ns.SOA != nil {
+			serials = append(serials, ns.SOA.Serial)
+			mname = ns.SOA.Ns
+		}
+	}
+
+	if len(serials) == 0 {
+		return results
+	}
+
+	newest := newestSerial(serials)
+
+	for _, ns := range c.SOA {
+		if ns.SOA == nil || ns.SOA.Serial == newest {
+			continue
+		}
+
+		server := fmt.Sprintf("%s(%s)", ns.Name, ns.IP)
+
+		switch {
+		case !serialLess(ns.SOA.Serial, newest):
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Serial %d on %s and %d can't be compared (RFC1982 3.2), secondaries won't transfer the zone.", ns.SOA.Serial, server, newest),
+				Status: false, Name: "SerialDivergence",
+			})
+		case strings.EqualFold(dns.Fqdn(ns.Name), dns.Fqdn(mname)):
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Serial %d on primary %s is %d behind %d, the serial was rolled back. Secondaries won't transfer the zone until it's higher.", ns.SOA.Serial, server, newest-ns.SOA.Serial, newest),
+				Status: false, Name: "SerialRollback",
+			})
+		default:
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("WARN: Serial %d on %s is %d behind %d.", ns.SOA.Serial, server, newest-ns.SOA.Serial, newest),
+				Status: false, Name: "SerialDivergence",
+			})
+		}
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : All nameservers have serial %d.", newest),
+			Status: true, Name: "SerialDivergence",
+		})
+	}
+
+	return results
+}
+
+// pollSerials asks every nameserver for the serial of domain.
+func (c *SOACheck) pollSerials(domain string) map[string]uint32 {
+	serials := make(map[string]uint32)
+
+	for _, ns := range c.NS {
+		for _, nsip := range ns.IP {
+			soa, _, err := scan.QueryRRset(domain, dns.TypeSOA, nsip.String(), false)
+			if err != nil || len(soa) == 0 {
+				log.Debugf("SOA: watch %s(%s): %v", ns.Name, nsip, err)
+				continue
+			}
+
+			serials[fmt.Sprintf("%s(%s)", ns.Name, nsip)] = soa[0].(*dns.SOA).Serial
+		}
+	}
+
+	return serials
+}
+
+// Watch polls the serial of every nameserver every interval until they all have
+// the same serial, or until timeout. If the serials are identical when it starts it
+// waits for a new serial first. progress is called for every server that reaches
+// the new serial.
+func (c *SOACheck) Watch(domain string, interval, timeout time.Duration, progress func(server string, serial uint32, lag time.Duration)) *SerialWatch {
+	log.Debugf("SOA: watch")
+	defer log.Debugf("SOA: watch exit")
+
+	return watchSerials(func() map[string]uint32 { return c.pollSerials(domain) }, interval, timeout, progress)
+}
+
+func watchSerials(poll func() map[string]uint32, interval, timeout time.Duration, progress func(string, uint32, time.Duration)) *SerialWatch {
+	w := &SerialWatch{Converged: make(map[string]time.Duration)}
+	deadline := time.Now().Add(timeout)
+
+	prev := poll()
+	if len(prev) == 0 {
+		return w
+	}
+
+	var serials []uint32
+	for _, serial := range prev {
+		serials = append(serials, serial)
+	}
+
+	w.Serial = newestSerial(serials)
+	waiting := true
+
+	for _, serial := range prev {
+		if serial != w.Serial {
+			// already diverged, the lag is measured from now
+			waiting = false
+			w.Start = time.Now()
+		}
+	}
+
+	for ; time.Now().Before(deadline); time.Sleep(interval) {
+		cur := poll()
+		now := time.Now()
+
+		var servers []string
+		for server := range cur {
+			servers = append(servers, server)
+		}
+
+		sort.Strings(servers)
+
+		for _, server := range servers {
+			serial := cur[server]
+
+			if old, ok := prev[server]; ok && serialLess(serial, old) {
+				w.Rollback = append(w.Rollback, server)
+			}
+
+			prev[server] = serial
+
+			if serialLess(w.Serial, serial) {
+				// a newer serial, start over
+				w.Serial, w.Start, waiting = serial, now, false
+				w.Converged = make(map[string]time.Duration)
+			}
+		}
+
+		if waiting {
+			continue
+		}
+
+		for _, server := range servers {
+			if _, ok := w.Converged[server]; ok || cur[server] != w.Serial {
+				continue
+			}
+
+			w.Converged[server] = now.Sub(w.Start)
+
+			if progress != nil {
+				progress(server, w.Serial, w.Converged[server])
+			}
+		}
+
+		if len(w.Converged) == len(prev) {
+			w.Done = true
+			return w
+		}
+	}
+
+	return w
+}
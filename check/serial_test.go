@@ -0,0 +1,159 @@
+package check
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestSerialLess(t *testing.T) {
+	tests := []struct {
+		a, b uint32
+		want bool
+	}{
+		{1, 2, true},
+		{2, 1, false},
+		{1, 1, false},
+		{2024010100, 2024010101, true},
+		{4294967295, 0, true},
+		{4294967295, 10, true},
+		{10, 4294967295, false},
+		{0, 1<<31 - 1, true},
+		{0, 1 << 31, false},
+		{1 << 31, 0, false},
+	}
+
+	for _, tt := range tests {
+		if got := serialLess(tt.a, tt.b); got != tt.want {
+			t.Errorf("serialLess(%d, %d) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestNewestSerial(t *testing.T) {
+	tests := []struct {
+		serials []uint32
+		want    uint32
+	}{
+		{[]uint32{1}, 1},
+		{[]uint32{1, 3, 2}, 3},
+		{[]uint32{4294967290, 5, 4294967295}, 5},
+	}
+
+	for _, tt := range tests {
+		if got := newestSerial(tt.serials); got != tt.want {
+			t.Errorf("newestSerial(%v) = %d, want %d", tt.serials, got, tt.want)
+		}
+	}
+}
+
+func TestSerialDivergence(t *testing.T) {
+	tests := []struct {
+		name string
+		ns   string
+		want string
+	}{
+		{"secondary behind", "ns2.example.com.", "WARN: Serial 1 on ns2.example.com.(192.0.2.2) is 1 behind 2."},
+		{"primary behind", "ns1.example.com.", "FAIL: Serial 1 on primary ns1.example.com.(192.0.2.2)"},
+		{"primary in other case", "NS1.Example.com", "FAIL: Serial 1 on primary NS1.Example.com(192.0.2.2)"},
+	}
+
+	for _, tt := range tests {
+		c := &SOACheck{SOA: []SOAData{
+			{Name: "ns3.example.com.", IP: "192.0.2.3", SOA: &dns.SOA{Ns: "ns1.example.com.", Serial: 2}},
+			{Name: tt.ns, IP: "192.0.2.2", SOA: &dns.SOA{Ns: "ns1.example.com.", Serial: 1}},
+		}}
+
+		results := c.serialDivergence()
+		if len(results) != 1 || !strings.HasPrefix(results[0].Result, tt.want) {
+			t.Errorf("%s: %v, want %q", tt.name, results, tt.want)
+		}
+	}
+}
+
+// scriptedPoll returns a poll function that returns the polls in order and then
+// keeps returning the last one.
+func scriptedPoll(polls ...map[string]uint32) func() map[string]uint32 {
+	i := 0
+
+	return func() map[string]uint32 {
+		p := make(map[string]uint32)
+		for k, v := range polls[i] {
+			p[k] = v
+		}
+
+		if i < len(polls)-1 {
+			i++
+		}
+
+		return p
+	}
+}
+
+func TestWatchSerials(t *testing.T) {
+	tests := []struct {
+		name     string
+		polls    []map[string]uint32
+		serial   uint32
+		done     bool
+		progress int
+		rollback []string
+	}{
+		{
+			name:     "waits for a new serial",
+			polls:    []map[string]uint32{{"a": 1, "b": 1}, {"a": 1, "b": 1}, {"a": 2, "b": 1}, {"a": 2, "b": 2}},
+			serial:   2,
+			done:     true,
+			progress: 2,
+		},
+		{
+			name:     "already diverged",
+			polls:    []map[string]uint32{{"a": 2, "b": 1}, {"a": 2, "b": 2}},
+			serial:   2,
+			done:     true,
+			progress: 2,
+		},
+		{
+			name:     "newer serial during the watch",
+			polls:    []map[string]uint32{{"a": 2, "b": 1}, {"a": 3, "b": 2}, {"a": 3, "b": 3}},
+			serial:   3,
+			done:     true,
+			progress: 2,
+		},
+		{
+			name:     "serial wraps around",
+			polls:    []map[string]uint32{{"a": 4294967295, "b": 4294967295}, {"a": 1, "b": 4294967295}, {"a": 1, "b": 1}},
+			serial:   1,
+			done:     true,
+			progress: 2,
+		},
+		{
+			name:     "rollback never converges",
+			polls:    []map[string]uint32{{"a": 2, "b": 2}, {"a": 1, "b": 2}},
+			serial:   2,
+			rollback: []string{"a"},
+		},
+		{
+			name:  "no servers",
+			polls: []map[string]uint32{{}},
+		},
+	}
+
+	for _, tt := range tests {
+		progress := 0
+
+		w := watchSerials(scriptedPoll(tt.polls...), time.Millisecond, 50*time.Millisecond, func(string, uint32, time.Duration) {
+			progress++
+		})
+
+		if w.Serial != tt.serial || w.Done != tt.done || progress != tt.progress {
+			t.Errorf("%s: serial %d done %v progress %d, want %d %v %d", tt.name, w.Serial, w.Done, progress, tt.serial, tt.done, tt.progress)
+		}
+
+		if len(w.Rollback) != len(tt.rollback) || len(tt.rollback) > 0 && w.Rollback[0] != tt.rollback[0] {
+			t.Errorf("%s: rollback %v, want %v", tt.name, w.Rollback, tt.rollback)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package check
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	smtpTimeout = 10 * time.Second
+	smtpHelo    = "localhost"
+)
+
+// Dialer connects to a mail server. *net.Dialer satisfies it, tests can use a
+// dialer that connects to a local server instead.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// SMTPProbe is the result of connecting to a mail server and negotiating STARTTLS.
+type SMTPProbe struct {
+	Addr         string
+	ConnectTime  time.Duration
+	Banner       string
+	StartTLS     bool
+	TLS          bool
+	TLSVersion   string
+	Certs        []*x509.Certificate `json:"-"`
+	ConnectError string              `json:",omitempty"` // the connection to port 25 failed
+	Error        string              `json:",omitempty"` // the SMTP session or STARTTLS failed
+}
+
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+func defaultDialer() Dialer {
+	return &net.Dialer{Timeout: smtpTimeout}
+}
+
+// probeSMTP connects to ip on port 25, reads the banner, sends EHLO and does STARTTLS
+// if it's advertised. The certificate is not verified, the caller does that.
+func probeSMTP(d Dialer, ip net.IP, serverName string) SMTPProbe {
+	p := SMTPProbe{Addr: net.JoinHostPort(ip.String(), "25")}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*smtpTimeout)
+	defer cancel()
+
+	start := time.Now()
+
+	conn, err := d.DialContext(ctx, "tcp", p.Addr)
+	if err != nil {
+		p.ConnectError = err.Error()
+		return p
+	}
+
+	defer conn.Close()
+
+	p.ConnectTime = time.Since(start)
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tp := textproto.NewConn(conn)
+
+	_, p.Banner, err = tp.ReadResponse(220)
+	if err != nil {
+		p.Error = fmt.Sprintf("banner: %s", err)
+		return p
+	}
+
+	if err = tp.PrintfLine("EHLO %s", smtpHelo); err != nil {
+		p.Error = fmt.Sprintf("EHLO: %s", err)
+		return p
+	}
+
+	_, ext, err := tp.ReadResponse(250)
+	if err != nil {
+		p.Error = fmt.Sprintf("EHLO: %s", err)
+		return p
+	}
+
+	for _, line := range strings.Split(ext, "\n") {
+		if strings.EqualFold(strings.TrimSpace(line), "STARTTLS") {
+			p.StartTLS = true
+		}
+	}
+
+	if !p.StartTLS {
+		_ = tp.PrintfLine("QUIT")
+		return p
+	}
+
+	if err = tp.PrintfLine("STARTTLS"); err != nil {
+		p.Error = fmt.Sprintf("STARTTLS: %s", err)
+		return p
+	}
+
+	if _, _, err = tp.ReadResponse(220); err != nil {
+		p.Error = fmt.Sprintf("STARTTLS: %s", err)
+		return p
+	}
+
+	tc := tls.Client(conn, &tls.Config{
+		ServerName:         strings.TrimSuffix(serverName, "."),
+		InsecureSkipVerify: true, //nolint:gosec // the certificate is verified against PKIX or DANE by the caller
+	})
+
+	if err = tc.Handshake(); err != nil {
+		p.Error = fmt.Sprintf("TLS handshake: %s", err)
+		return p
+	}
+
+	state := tc.ConnectionState()
+	p.TLS = true
+	p.TLSVersion = tlsVersions[state.Version]
+	p.Certs = state.PeerCertificates
+
+	_ = textproto.NewConn(tc).PrintfLine("QUIT")
+
+	return p
+}
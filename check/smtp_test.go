@@ -0,0 +1,82 @@
+package check
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestProbeSMTP(t *testing.T) {
+	_, cert := testChain(t, "mx.example.com")
+
+	tests := []struct {
+		name     string
+		dialer   *fakeSMTP
+		startTLS bool
+		tls      bool
+		connect  string
+		err      string
+	}{
+		{name: "STARTTLS", dialer: &fakeSMTP{banner: "mx.example.com ESMTP", cert: &cert}, startTLS: true, tls: true},
+		{name: "no STARTTLS", dialer: &fakeSMTP{banner: "mx.example.com ESMTP"}},
+		{name: "no greeting", dialer: &fakeSMTP{}, err: "banner"},
+		{name: "refused", dialer: &fakeSMTP{err: errors.New("connection refused")}, connect: "connection refused"},
+	}
+
+	for _, tt := range tests {
+		p := probeSMTP(tt.dialer, net.ParseIP("192.0.2.25"), "mx.example.com.")
+
+		if p.Addr != "192.0.2.25:25" {
+			t.Errorf("%s: addr %s", tt.name, p.Addr)
+		}
+
+		if p.StartTLS != tt.startTLS || p.TLS != tt.tls {
+			t.Errorf("%s: STARTTLS %v TLS %v, want %v %v (%s)", tt.name, p.StartTLS, p.TLS, tt.startTLS, tt.tls, p.Error)
+		}
+
+		if p.ConnectError != tt.connect {
+			t.Errorf("%s: connect error %q, want %q", tt.name, p.ConnectError, tt.connect)
+		}
+
+		if (tt.err == "") != (p.Error == "") || !strings.Contains(p.Error, tt.err) {
+			t.Errorf("%s: error %q, want %q", tt.name, p.Error, tt.err)
+		}
+
+		if tt.tls && (len(p.Certs) == 0 || p.Certs[0].VerifyHostname("mx.example.com") != nil) {
+			t.Errorf("%s: certificate not kept", tt.name)
+		}
+	}
+}
+
+func TestCheckProbe(t *testing.T) {
+	_, cert := testChain(t, "mx.example.com")
+
+	tests := []struct {
+		name   string
+		dialer *fakeSMTP
+		want   string
+	}{
+		{"STARTTLS", &fakeSMTP{banner: "mx.example.com ESMTP", cert: &cert}, "OK  : MX mx.example.com. (192.0.2.25) supports STARTTLS"},
+		{"no STARTTLS", &fakeSMTP{banner: "mx.example.com ESMTP"}, "WARN: MX mx.example.com. (192.0.2.25) doesn't offer STARTTLS"},
+		{"no greeting", &fakeSMTP{}, "FAIL: MX mx.example.com. (192.0.2.25) accepted the connection"},
+		{"refused", &fakeSMTP{err: errors.New("connection refused")}, "ERR : MX mx.example.com. (192.0.2.25) is not reachable on port 25"},
+	}
+
+	for _, tt := range tests {
+		p := MXProbe{MX: "mx.example.com.", IP: "192.0.2.25", SMTPProbe: probeSMTP(tt.dialer, net.ParseIP("192.0.2.25"), "mx.example.com.")}
+		c := &MXCheck{Probes: []MXProbe{p}}
+
+		found := false
+
+		for _, res := range c.CheckProbe() {
+			if strings.HasPrefix(res.Result, tt.want) {
+				found = true
+			}
+		}
+
+		if !found {
+			t.Errorf("%s: no result %q in %v", tt.name, tt.want, c.CheckProbe())
+		}
+	}
+}
@@ -2,6 +2,10 @@ package check
 
 import (
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/42wim/dt/scan"
@@ -13,8 +17,36 @@ type SOACheck struct {
 	NS     []structs.NSData
 	SOA    []SOAData
 	Domain string
+	Timers SOATimers
 	Report
-	s *scan.Scan
+	s      *scan.Scan
+	lookup func(name string, qtype uint16) ([]dns.RR, error)
+}
+
+// SOARange is the allowed range of a SOA timer in seconds.
+type SOARange struct {
+	Min uint32
+	Max uint32
+}
+
+// SOATimers are the limits the SOA refresh, retry, expire and minimum fields are
+// checked against.
+type SOATimers struct {
+	Refresh     SOARange
+	Retry       SOARange
+	Expire      SOARange
+	Minimum     SOARange // negative caching TTL (RFC 2308)
+	ExpireRatio uint32   // expire must be at least this many times refresh
+}
+
+// SOATimerLimits are the limits used by NewSOA and lint, based on RFC 1912 2.2 and
+// RIPE-203. Change them (see ParseSOATimers) to check against a local policy.
+var SOATimerLimits = SOATimers{
+	Refresh:     SOARange{1200, 86400},
+	Retry:       SOARange{120, 7200},
+	Expire:      SOARange{604800, 3600000},
+	Minimum:     SOARange{300, 86400},
+	ExpireRatio: 7,
 }
 
 type SOAData struct {
@@ -26,8 +58,13 @@ type SOAData struct {
 
 func NewSOA(s *scan.Scan, ns []structs.NSData) *SOACheck {
 	c := &SOACheck{
-		s:  s,
-		NS: ns,
+		s:      s,
+		NS:     ns,
+		Timers: SOATimerLimits,
+	}
+
+	if s != nil {
+		c.lookup = resolverLookup(s)
 	}
 
 	return c
@@ -55,6 +92,7 @@ func (c *SOACheck) Scan(domain string) {
 	}
 }
 
+// checkMname returns true if mname is one of the NS records at the parent.
 func (c *SOACheck) checkMname(mname string) bool {
 	log.Debugf("SOA: mname")
 	defer log.Debugf("SOA: mname exit")
@@ -70,7 +108,7 @@ loop:
 		for _, nsip := range ns.IP {
 			res, err := scan.Query(dns.Fqdn(c.Domain), dns.TypeNS, nsip.String(), true)
 			if err != nil {
-				break
+				continue
 			}
 
 			rrset = extractRR(res.Msg.Ns, dns.TypeNS)
@@ -81,7 +119,7 @@ loop:
 	}
 
 	for _, pns := range rrset {
-		if pns.(*dns.NS).Ns == mname {
+		if strings.EqualFold(pns.(*dns.NS).Ns, mname) {
 			return true
 		}
 	}
@@ -89,6 +127,70 @@ loop:
 	return false
 }
 
+// mnameAuthoritative resolves mname and asks its ips for the SOA of the domain. It
+// returns the ips and the ips that answer authoritatively.
+func (c *SOACheck) mnameAuthoritative(mname string) ([]net.IP, []net.IP) {
+	var ips, auth []net.IP
+
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		rrs, err := c.lookup(mname, qtype)
+		if err == nil {
+			ips = append(ips, extractIP(rrs)...)
+		}
+	}
+
+	for _, ip := range ips {
+		res, err := scan.Query(dns.Fqdn(c.Domain), dns.TypeSOA, ip.String(), false)
+		if err == nil && res.Msg.Authoritative && len(extractRR(res.Msg.Answer, dns.TypeSOA)) > 0 {
+			auth = append(auth, ip)
+		}
+	}
+
+	return ips, auth
+}
+
+// rnameMailbox converts the RNAME of a SOA record to a mailbox: the first
+// unescaped dot is the @ (RFC 1035 8).
+func rnameMailbox(rname string) (string, string, error) {
+	labels := dns.SplitDomainName(rname)
+	if len(labels) < 3 {
+		return "", "", fmt.Errorf("RNAME %s must be a local part followed by a domain of at least 2 labels", rname)
+	}
+
+	local := strings.ReplaceAll(labels[0], "\\.", ".")
+	domain := dns.Fqdn(strings.Join(labels[1:], "."))
+
+	switch {
+	case strings.ContainsAny(local, "@ ") || strings.Contains(local, "\\"):
+		return "", "", fmt.Errorf("RNAME %s has an invalid local part %s", rname, local)
+	case strings.HasPrefix(local, ".") || strings.HasSuffix(local, ".") || strings.Contains(local, ".."):
+		return "", "", fmt.Errorf("RNAME %s has an invalid local part %s, dots must be escaped", rname, local)
+	}
+
+	if _, ok := dns.IsDomainName(domain); !ok || strings.Contains(domain, "\\") {
+		return "", "", fmt.Errorf("RNAME %s has an invalid domain %s", rname, domain)
+	}
+
+	return local + "@" + strings.TrimSuffix(domain, "."), domain, nil
+}
+
+// serialScheme returns the scheme of serial: YYYYMMDDnn with a date that is not
+// in the future, unixtime of the last 20 years or incrementing.
+func serialScheme(serial uint32, now time.Time) string {
+	str := strconv.FormatUint(uint64(serial), 10)
+
+	if len(str) == 10 {
+		if t, err := time.Parse("20060102", str[:8]); err == nil && t.Year() >= 1990 && !t.After(now) {
+			return "YYYYMMDDnn"
+		}
+	}
+
+	if t := time.Unix(int64(serial), 0); t.After(now.AddDate(-20, 0, 0)) && !t.After(now.Add(24*time.Hour)) {
+		return "unixtime"
+	}
+
+	return "incrementing"
+}
 func (c *SOACheck) Identical() ReportResult {
 	m := make(map[string][]string)
 
@@ -116,15 +218,140 @@ func (c *SOACheck) Identical() ReportResult {
 	return res
 }
 
-func checkSerial(serial uint32) bool {
-	serialstr := fmt.Sprintf("%v", serial)
-	if len(serialstr) != 10 {
-		return false
+// ParseSOATimers parses a comma separated list of field=min-max limits and
+// ratio=n, for example "refresh=3600-86400,ratio=10". Fields that are not given keep
+// their value in limits.
+func ParseSOATimers(limits SOATimers, spec string) (SOATimers, error) {
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			return limits, fmt.Errorf("invalid SOA timer %s, expected field=min-max", field)
+		}
+
+		name := strings.ToLower(kv[0])
+		if name == "ratio" {
+			n, err := strconv.ParseUint(kv[1], 10, 32)
+			if err != nil {
+				return limits, fmt.Errorf("invalid SOA timer ratio %s", kv[1])
+			}
+
+			limits.ExpireRatio = uint32(n)
+
+			continue
+		}
+
+		var r SOARange
+		if _, err := fmt.Sscanf(kv[1], "%d-%d", &r.Min, &r.Max); err != nil || r.Min > r.Max {
+			return limits, fmt.Errorf("invalid SOA timer range %s, expected min-max", kv[1])
+		}
+
+		switch name {
+		case "refresh":
+			limits.Refresh = r
+		case "retry":
+			limits.Retry = r
+		case "expire":
+			limits.Expire = r
+		case "minimum":
+			limits.Minimum = r
+		default:
+			return limits, fmt.Errorf("unknown SOA timer %s", name)
+		}
+	}
+
+	return limits, nil
+}
+
+// timerProblems returns the SOA fields of soa that are out of range or inconsistent.
+func (t SOATimers) timerProblems(soa *dns.SOA) []string {
+	var problems []string
+
+	for _, f := range []struct {
+		name  string
+		value uint32
+		r     SOARange
+	}{
+		{"refresh", soa.Refresh, t.Refresh},
+		{"retry", soa.Retry, t.Retry},
+		{"expire", soa.Expire, t.Expire},
+		{"minimum", soa.Minttl, t.Minimum},
+	} {
+		switch {
+		case f.value < f.r.Min:
+			problems = append(problems, fmt.Sprintf("%s %d is lower than %d", f.name, f.value, f.r.Min))
+		case f.value > f.r.Max:
+			problems = append(problems, fmt.Sprintf("%s %d is higher than %d", f.name, f.value, f.r.Max))
+		}
+	}
+
+	if soa.Retry >= soa.Refresh {
+		problems = append(problems, fmt.Sprintf("retry %d is not lower than refresh %d", soa.Retry, soa.Refresh))
+	}
+
+	if uint64(soa.Expire) < uint64(t.ExpireRatio)*uint64(soa.Refresh) {
+		problems = append(problems, fmt.Sprintf("expire %d is less than %d times refresh %d", soa.Expire, t.ExpireRatio, soa.Refresh))
 	}
 
-	_, err := time.Parse("20060102", serialstr[:len(serialstr)-2])
+	if uint64(soa.Expire) <= uint64(soa.Refresh)+uint64(soa.Retry) {
+		problems = append(problems, fmt.Sprintf("expire %d is not higher than refresh + retry %d", soa.Expire, uint64(soa.Refresh)+uint64(soa.Retry)))
+	}
 
-	return err != nil
+	return problems
+}
+
+// timerValues checks the SOA timers of every server. Servers with the same problem
+// are reported together.
+func (c *SOACheck) timerValues() []ReportResult {
+	var (
+		results  []ReportResult
+		problems []string
+	)
+
+	servers := make(map[string][]string)
+
+	for _, ns := range c.SOA {
+		if ns.SOA == nil {
+			continue
+		}
+
+		server := ns.Name
+		if ns.IP != "" {
+			server += "(" + ns.IP + ")"
+		}
+
+		for _, p := range c.Timers.timerProblems(ns.SOA) {
+			if servers[p] == nil {
+				problems = append(problems, p)
+			}
+
+			servers[p] = append(servers[p], server)
+		}
+	}
+
+	for _, p := range problems {
+		sort.Strings(servers[p])
+
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: SOA %s on %v", p, servers[p]),
+			Status: false, Name: "Timers",
+		})
+	}
+
+	if len(results) == 0 {
+		t := c.Timers
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : SOA timers are within the recommended ranges (refresh %d-%d, retry %d-%d, expire %d-%d, minimum %d-%d).",
+				t.Refresh.Min, t.Refresh.Max, t.Retry.Min, t.Retry.Max, t.Expire.Min, t.Expire.Max, t.Minimum.Min, t.Minimum.Max),
+			Status: true, Name: "Timers",
+		})
+	}
+
+	return results
 }
 
 func (c *SOACheck) checkRFC1918() bool {
@@ -139,6 +366,117 @@ func (c *SOACheck) checkRFC1918() bool {
 	return false
 }
 
+// serialValues returns the checks that only need the SOA record itself.
+func (c *SOACheck) serialValues(soa *dns.SOA) []ReportResult {
+	var results []ReportResult
+
+	scheme := serialScheme(soa.Serial, time.Now())
+
+	results = append(results, ReportResult{
+		Result: fmt.Sprintf("OK  : Serial %d uses the %s scheme.", soa.Serial, scheme),
+		Status: true, Records: []string{soa.String()}, Name: "Serial",
+	})
+
+	if scheme == "incrementing" && len(strconv.FormatUint(uint64(soa.Serial), 10)) == 10 && soa.Serial/100 > 19900000 && soa.Serial/100 < 21000000 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: Serial %d looks like YYYYMMDDnn but the date is invalid or in the future.", soa.Serial),
+			Status: false, Name: "Serial",
+		})
+	}
+
+	mailbox, _, err := rnameMailbox(soa.Mbox)
+	if err != nil {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: %s", err),
+			Status: false, Name: "RNAME",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : RNAME %s is a valid mailbox %s", soa.Mbox, mailbox),
+			Status: true, Name: "RNAME",
+		})
+	}
+
+	return results
+}
+
+// rnameValues checks that mail can be delivered to the RNAME domain.
+func (c *SOACheck) rnameValues(soa *dns.SOA) []ReportResult {
+	mailbox, domain, err := rnameMailbox(soa.Mbox)
+	if err != nil {
+		return nil
+	}
+
+	for _, qtype := range []uint16{dns.TypeMX, dns.TypeA, dns.TypeAAAA} {
+		rrs, err := c.lookup(domain, qtype)
+		if err != nil {
+			return []ReportResult{{
+				Result: fmt.Sprintf("ERR : Lookup of %s %s for RNAME failed: %s", domain, dns.TypeToString[qtype], err),
+				Status: false, Name: "RNAME",
+			}}
+		}
+
+		if len(rrs) > 0 {
+			return []ReportResult{{
+				Result: fmt.Sprintf("OK  : RNAME domain %s has %s records, %s can receive mail.", domain, dns.TypeToString[qtype], mailbox),
+				Status: true, Name: "RNAME",
+			}}
+		}
+	}
+
+	return []ReportResult{{
+		Result: fmt.Sprintf("FAIL: RNAME domain %s has no MX, A or AAAA records, %s can't receive mail.", domain, mailbox),
+		Status: false, Name: "RNAME",
+	}}
+}
+
+// mnameValues checks that MNAME resolves and if it is listed at the parent and
+// answers authoritatively. A primary that is not listed is a hidden primary.
+func (c *SOACheck) mnameValues(soa *dns.SOA) []ReportResult {
+	var results []ReportResult
+
+	ips, auth := c.mnameAuthoritative(soa.Ns)
+	listed := c.checkMname(soa.Ns)
+
+	switch {
+	case len(ips) == 0 && listed:
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: MNAME %s is listed at the parent servers but doesn't resolve.", soa.Ns),
+			Status: false, Name: "MNAME",
+		})
+	case len(ips) == 0:
+		return append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: MNAME %s doesn't resolve, NOTIFY and dynamic updates (RFC2136) can't find the primary.", soa.Ns),
+			Status: false, Name: "MNAME",
+		})
+	}
+
+	switch {
+	case listed && len(auth) > 0:
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : MNAME %s is listed at the parent servers and answers authoritatively.", soa.Ns),
+			Status: true, Name: "MNAME",
+		})
+	case listed:
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: MNAME %s is listed at the parent servers but doesn't answer authoritatively %v.", soa.Ns, ips),
+			Status: false, Name: "MNAME",
+		})
+	case len(auth) > 0:
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("INFO: MNAME %s is not listed at the parent servers, it is a hidden primary that answers authoritatively.", soa.Ns),
+			Status: true, Name: "MNAME",
+		})
+	default:
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("INFO: MNAME %s is not listed at the parent servers and doesn't answer authoritatively for you, it is a hidden primary.", soa.Ns),
+			Status: true, Name: "MNAME",
+		})
+	}
+
+	return results
+}
+
 func (c *SOACheck) Values() []ReportResult {
 	var (
 		soa     *dns.SOA
@@ -151,24 +489,17 @@ func (c *SOACheck) Values() []ReportResult {
 		}
 	}
 
-	if checkSerial(soa.Serial) {
-		results = append(results, ReportResult{
-			Result: "OK  : Serial format appears to be in the recommended format of YYYYMMDDnn.",
-			Status: true, Records: []string{soa.String()}, Name: "Serial",
+	if soa == nil {
+		return append(results, ReportResult{
+			Result: "FAIL: No SOA record found.",
+			Status: false, Name: "SOA",
 		})
 	}
 
-	if c.checkMname(soa.Ns) {
-		results = append(results, ReportResult{
-			Result: fmt.Sprintf("OK  : MNAME %s is listed at the parent servers.", soa.Ns),
-			Status: true, Name: "MNAME",
-		})
-	} else {
-		results = append(results, ReportResult{
-			Result: fmt.Sprintf("FAIL: MNAME %s is not listed at the parent servers.", soa.Ns),
-			Status: false, Name: "MNAME",
-		})
-	}
+	results = append(results, c.serialValues(soa)...)
+	results = append(results, c.timerValues()...)
+	results = append(results, c.rnameValues(soa)...)
+	results = append(results, c.mnameValues(soa)...)
 
 	if !c.checkRFC1918() {
 		results = append(results, ReportResult{
@@ -190,6 +521,7 @@ func (c *SOACheck) CreateReport(domain string) Report {
 
 	c.Report.Type = "SOA"
 	c.Report.Result = append(c.Report.Result, c.Identical())
+	c.Report.Result = append(c.Report.Result, c.serialDivergence()...)
 	c.Report.Result = append(c.Report.Result, c.Values()...)
 
 	return c.Report
@@ -0,0 +1,174 @@
+package check
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestRnameMailbox(t *testing.T) {
+	tests := []struct {
+		rname   string
+		mailbox string
+		domain  string
+		err     string
+	}{
+		{rname: "hostmaster.example.com.", mailbox: "hostmaster@example.com", domain: "example.com."},
+		{rname: "dns\\.admin.example.com.", mailbox: "dns.admin@example.com", domain: "example.com."},
+		{rname: "hostmaster.mail.example.com", mailbox: "hostmaster@mail.example.com", domain: "mail.example.com."},
+		{rname: "hostmaster.com.", err: "at least 2 labels"},
+		{rname: "example.com.", err: "at least 2 labels"},
+		{rname: "dns\\.\\.admin.example.com.", err: "dots must be escaped"},
+		{rname: "\\.admin.example.com.", err: "dots must be escaped"},
+		{rname: "dns@admin.example.com.", err: "invalid local part"},
+	}
+
+	for _, tt := range tests {
+		mailbox, domain, err := rnameMailbox(tt.rname)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("rnameMailbox(%q) error = %v, want %q", tt.rname, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil || mailbox != tt.mailbox || domain != tt.domain {
+			t.Errorf("rnameMailbox(%q) = %q, %q, %v, want %q, %q", tt.rname, mailbox, domain, err, tt.mailbox, tt.domain)
+		}
+	}
+}
+
+func TestSerialScheme(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		serial uint32
+		want   string
+	}{
+		{2024061501, "YYYYMMDDnn"},
+		{2024061599, "YYYYMMDDnn"},
+		{1999123100, "YYYYMMDDnn"},
+		{2024061601, "incrementing"}, // a date in the future
+		{2024131501, "incrementing"},
+		{uint32(now.Unix()), "unixtime"},
+		{uint32(now.Add(-time.Hour).Unix()), "unixtime"},
+		{uint32(now.AddDate(-21, 0, 0).Unix()), "incrementing"},
+		{1, "incrementing"},
+		{42, "incrementing"},
+	}
+
+	for _, tt := range tests {
+		if got := serialScheme(tt.serial, now); got != tt.want {
+			t.Errorf("serialScheme(%d) = %s, want %s", tt.serial, got, tt.want)
+		}
+	}
+}
+
+func TestRnameValues(t *testing.T) {
+	c := &SOACheck{lookup: fakeLookup(t,
+		`example.com. 300 IN MX 10 mail.example.com.`,
+		`example.net. 300 IN A 192.0.2.1`,
+	)}
+
+	tests := []struct {
+		rname string
+		want  string
+	}{
+		{"hostmaster.example.com.", "OK  : RNAME domain example.com. has MX records"},
+		{"hostmaster.example.net.", "OK  : RNAME domain example.net. has A records"},
+		{"hostmaster.example.org.", "FAIL: RNAME domain example.org. has no MX, A or AAAA records"},
+	}
+
+	for _, tt := range tests {
+		results := c.rnameValues(&dns.SOA{Mbox: tt.rname})
+		if len(results) != 1 || !strings.HasPrefix(results[0].Result, tt.want) {
+			t.Errorf("rnameValues(%s) = %v, want %q", tt.rname, results, tt.want)
+		}
+	}
+}
+
+func TestParseSOATimers(t *testing.T) {
+	tests := []struct {
+		spec string
+		want SOATimers
+		err  string
+	}{
+		{spec: "", want: SOATimerLimits},
+		{spec: "refresh=3600-43200", want: func() SOATimers {
+			l := SOATimerLimits
+			l.Refresh = SOARange{3600, 43200}
+
+			return l
+		}()},
+		{spec: " Retry=60-600 , ratio=10,", want: func() SOATimers {
+			l := SOATimerLimits
+			l.Retry = SOARange{60, 600}
+			l.ExpireRatio = 10
+
+			return l
+		}()},
+		{spec: "expire=1-2,minimum=3-4", want: func() SOATimers {
+			l := SOATimerLimits
+			l.Expire = SOARange{1, 2}
+			l.Minimum = SOARange{3, 4}
+
+			return l
+		}()},
+		{spec: "refresh", err: "expected field=min-max"},
+		{spec: "refresh=3600", err: "invalid SOA timer range"},
+		{spec: "refresh=7200-3600", err: "invalid SOA timer range"},
+		{spec: "ratio=x", err: "invalid SOA timer ratio"},
+		{spec: "serial=1-2", err: "unknown SOA timer serial"},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSOATimers(SOATimerLimits, tt.spec)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseSOATimers(%q) error = %v, want %q", tt.spec, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil || got != tt.want {
+			t.Errorf("ParseSOATimers(%q) = %+v, %v, want %+v", tt.spec, got, err, tt.want)
+		}
+	}
+}
+
+func TestTimerProblems(t *testing.T) {
+	tests := []struct {
+		soa  string
+		want []string
+	}{
+		{"7200 900 1209600 3600", nil},
+		{"600 900 3600 60", []string{
+			"refresh 600 is lower than 1200",
+			"expire 3600 is lower than 604800",
+			"minimum 60 is lower than 300",
+			"retry 900 is not lower than refresh 600",
+			"expire 3600 is less than 7 times refresh 600",
+		}},
+		{"86400 3600 3600000 172800", []string{"minimum 172800 is higher than 86400"}},
+		{"7200 7200 1209600 3600", []string{"retry 7200 is not lower than refresh 7200"}},
+		{"86400 3600 604800 3600", nil}, // expire is exactly 7 times refresh
+	}
+
+	for _, tt := range tests {
+		rr, err := dns.NewRR("example.com. IN SOA ns1.example.com. hostmaster.example.com. 1 " + tt.soa)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := SOATimerLimits.timerProblems(rr.(*dns.SOA))
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("timerProblems(%s) = %q, want %q", tt.soa, got, tt.want)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package check
 
 import (
+	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/42wim/dt/scan"
@@ -9,8 +11,18 @@ import (
 )
 
 type SpamCheck struct {
-	NS   []structs.NSData
-	Spam []SpamData
+	NS             []structs.NSData
+	Spam           []SpamData
+	DMARCDomain    string   // domain of the DMARC record, the organizational domain if domain has none
+	DMARCOrg       []dns.RR // DMARC record of the organizational domain
+	DMARCAuth      []DMARCAuth
+	MTASTS         MTASTSData
+	BIMI           BIMIData
+	HTTPClient     *http.Client // used to fetch the MTA-STS policy and the BIMI assets, nil uses a default client
+	SPFTree        *SPFNode
+	SPFLookups     int
+	SPFVoidLookups int
+	MXScan         *MXCheck // shared MX scan, nil scans the MX records again
 	Report
 	s *scan.Scan
 }
@@ -37,6 +49,7 @@ func (c *SpamCheck) Scan(domain string) {
 	c.ScanDmarc(domain)
 	c.ScanSpf(domain)
 	c.ScanBIMI(domain)
+	c.ScanMTASTS(domain)
 }
 
 func (c *SpamCheck) ScanDmarc(domain string) {
@@ -54,6 +67,46 @@ func (c *SpamCheck) ScanDmarc(domain string) {
 			}
 		}
 	}
+
+	d := NewDMARC(c.s)
+	c.DMARCDomain = dns.Fqdn(domain)
+
+	rrset := dmarcRecords(c.dmarcRRset())
+	if len(rrset) == 0 {
+		c.DMARCDomain, c.DMARCOrg = d.OrgDomain(domain)
+		rrset = c.DMARCOrg
+	}
+
+	if len(rrset) != 1 {
+		return
+	}
+
+	rec, err := ParseDMARC(txtString(rrset[0]))
+	if err != nil {
+		return
+	}
+
+	org := c.DMARCDomain
+	if len(c.DMARCOrg) == 0 {
+		org, _ = d.OrgDomain(c.DMARCDomain)
+	}
+
+	for _, u := range append(rec.RUA, rec.RUF...) {
+		if u.Host != "." && !dns.IsSubDomain(org, u.Host) {
+			c.DMARCAuth = append(c.DMARCAuth, d.Authorized(c.DMARCDomain, u))
+		}
+	}
+}
+
+// dmarcRRset returns the TXT records at _dmarc of the first nameserver that has them.
+func (c *SpamCheck) dmarcRRset() []dns.RR {
+	for _, ns := range c.Spam {
+		if ns.Dmarc != nil {
+			return ns.Dmarc
+		}
+	}
+
+	return nil
 }
 
 func (c *SpamCheck) ScanBIMI(domain string) {
@@ -68,6 +121,62 @@ func (c *SpamCheck) ScanBIMI(domain string) {
 			if !c.Report.scanError("BIMI scan", ns.Name, nsip.String(), domain, bimi, err) {
 				data.BIMI = bimi
 				c.Spam = append(c.Spam, data)
+
+				if c.BIMI.TXT == nil {
+					c.BIMI.TXT = bimi
+				}
+			}
+		}
+	}
+
+	c.BIMI.Domain = domain
+	c.BIMI.Scan(c.HTTPClient)
+}
+
+// ScanMTASTS gets the MTA-STS and TLS-RPT records, fetches the MTA-STS policy and
+// gets the MX records to check the policy against.
+func (c *SpamCheck) ScanMTASTS(domain string) {
+	log.Debugf("Spam: scanmtasts")
+	defer log.Debugf("Spam: scanmtasts exit")
+
+	for _, ns := range c.NS {
+		for _, nsip := range ns.IP {
+			sts, _, err := scan.QueryRRset("_mta-sts."+domain, dns.TypeTXT, nsip.String(), true)
+			if !c.Report.scanError("MTA-STS scan", ns.Name, nsip.String(), domain, sts, err) && c.MTASTS.STS == nil {
+				c.MTASTS.STS = sts
+			}
+
+			rpt, _, err := scan.QueryRRset("_smtp._tls."+domain, dns.TypeTXT, nsip.String(), true)
+			if !c.Report.scanError("TLS-RPT scan", ns.Name, nsip.String(), domain, rpt, err) && c.MTASTS.TLSRPT == nil {
+				c.MTASTS.TLSRPT = rpt
+			}
+		}
+	}
+
+	if len(recordsWithVersion(c.MTASTS.STS, "STSv1")) == 0 {
+		return
+	}
+
+	url, policy, err := FetchMTASTSPolicy(c.HTTPClient, domain)
+
+	c.MTASTS.PolicyURL = url
+	c.MTASTS.Policy = policy
+
+	if err != nil {
+		c.MTASTS.PolicyError = err.Error()
+		return
+	}
+
+	mx := sharedMX(c.s, c.NS, c.MXScan, domain)
+
+	seen := make(map[string]bool)
+
+	for _, data := range mx.MX {
+		for _, rr := range data.MX {
+			name := rr.(*dns.MX).Mx
+			if !seen[name] {
+				seen[name] = true
+				c.MTASTS.MX = append(c.MTASTS.MX, name)
 			}
 		}
 	}
@@ -86,18 +195,36 @@ func (c *SpamCheck) ScanSpf(domain string) {
 				continue
 			}
 
-			spf := []dns.RR{}
+			data.Spf = spfRecords(txt)
+			c.Spam = append(c.Spam, data)
+		}
+	}
 
-			for _, rr := range txt {
-				if strings.Contains(rr.String(), "v=spf") {
-					spf = append(spf, rr)
-				}
-			}
+	e := NewSPF(c.s)
+	c.SPFTree = e.Expand(domain)
+	c.SPFLookups = e.Lookups
+	c.SPFVoidLookups = e.VoidLookups
+}
 
-			data.Spf = spf
-			c.Spam = append(c.Spam, data)
+func txtString(rr dns.RR) string {
+	if txt, ok := rr.(*dns.TXT); ok {
+		return strings.Join(txt.Txt, "")
+	}
+
+	return ""
+}
+
+// spfRecords returns the TXT records that are SPF records.
+func spfRecords(txt []dns.RR) []dns.RR {
+	spf := []dns.RR{}
+
+	for _, rr := range txt {
+		if isSPF(txtString(rr)) {
+			spf = append(spf, rr)
 		}
 	}
+
+	return spf
 }
 
 func (c *SpamCheck) Values() []ReportResult {
@@ -106,11 +233,15 @@ func (c *SpamCheck) Values() []ReportResult {
 		rrset   []dns.RR
 	)
 
-	for _, ns := range c.Spam {
-		if ns.Dmarc != nil {
-			rrset = ns.Dmarc
-			break
-		}
+	rrset = dmarcRecords(c.dmarcRRset())
+
+	if len(rrset) == 0 && len(dmarcRecords(c.DMARCOrg)) > 0 {
+		rrset = dmarcRecords(c.DMARCOrg)
+
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("INFO: No DMARC records found, using the record of the organizational domain %s.", c.DMARCDomain),
+			Status: true, Name: "DMARC",
+		})
 	}
 
 	if len(rrset) > 0 {
@@ -119,34 +250,7 @@ func (c *SpamCheck) Values() []ReportResult {
 			Status: true, Name: "DMARC",
 		})
 
-		records := []string{}
-
-		for _, rr := range rrset {
-			records = append(records, rr.String())
-
-			if strings.Contains(rr.String(), "p=none") {
-				results = append(results, ReportResult{
-					Result: "WARN: DMARC with monitoring policy found.",
-					Status: false, Name: "DMARCPolicy",
-				})
-			}
-
-			if strings.Contains(rr.String(), "p=quarantine") {
-				results = append(results, ReportResult{
-					Result: "WARN: DMARC with quarantine policy found.",
-					Status: false, Name: "DMARCPolicy",
-				})
-			}
-
-			if strings.Contains(rr.String(), "p=reject") {
-				results = append(results, ReportResult{
-					Result: "OK  : DMARC with reject policy.",
-					Status: true, Name: "DMARCPolicy",
-				})
-			}
-		}
-
-		results = append(results, ReportResult{Status: true, Records: records})
+		results = append(results, dmarcValues(c.DMARCDomain, rrset, c.DMARCAuth)...)
 	} else {
 		results = append(results, ReportResult{
 			Result: "WARN: No DMARC records found. Along with DKIM and SPF, DMARC helps prevent spam from your domain.",
@@ -154,6 +258,7 @@ func (c *SpamCheck) Values() []ReportResult {
 		})
 	}
 
+	rrset = nil
 	for _, ns := range c.Spam {
 		if ns.Spf != nil {
 			rrset = ns.Spf
@@ -178,49 +283,42 @@ func (c *SpamCheck) Values() []ReportResult {
 		})
 	}
 
+	if len(rrset) > 1 {
+		results = append(results, ReportResult{
+			Result: "FAIL: Multiple SPF records found (RFC7208 4.5).",
+			Status: false, Name: "SPFSyntax",
+		})
+	}
+
 	for _, rr := range rrset {
-		if strings.Contains(rr.String(), "-all") {
+		rec, err := ParseSPF(txtString(rr))
+		if err != nil {
 			results = append(results, ReportResult{
-				Result: "OK  : SPF records set up restrictively.",
-				Status: true, Name: "SPF",
+				Result: fmt.Sprintf("FAIL: SPF syntax error: %s", err),
+				Status: false, Name: "SPFSyntax",
 			})
-		}
 
-		if strings.Contains(rr.String(), "~all") {
-			results = append(results, ReportResult{
-				Result: "WARN: SPF record set to softfail.",
-				Status: true, Name: "SPF",
-			})
+			continue
 		}
 
-		if strings.Contains(rr.String(), " ptr ") || strings.Contains(rr.String(), " ptr:") {
-			results = append(results, ReportResult{
-				Result: "WARN: SPF record uses ptr mechanism (see RFC7208 5.5).",
-				Status: true, Name: "SPF",
-			})
-		}
+		results = append(results, spfPolicyValues(rec)...)
 	}
 
-	rrset = nil
-	for _, ns := range c.Spam {
-		if ns.BIMI != nil {
-			rrset = ns.BIMI
-			break
-		}
+	if len(rrset) > 0 && c.SPFTree != nil {
+		results = append(results, spfValues(c.SPFTree, c.SPFLookups, c.SPFVoidLookups)...)
 	}
 
-	if len(rrset) > 0 {
-		records := []string{}
-		for _, rr := range rrset {
-			records = append(records, rr.String())
-		}
+	var dmarc *DMARCRecord
 
-		results = append(results, ReportResult{
-			Result: "INFO: BIMI records found.",
-			Status: true, Records: records, Name: "BIMI",
-		})
+	if rrset := dmarcRecords(c.dmarcRRset()); len(rrset) == 1 {
+		dmarc, _ = ParseDMARC(txtString(rrset[0]))
+	} else if rrset := dmarcRecords(c.DMARCOrg); len(rrset) == 1 {
+		dmarc, _ = ParseDMARC(txtString(rrset[0]))
 	}
 
+	results = append(results, c.BIMI.Values(dmarc)...)
+	results = append(results, c.MTASTS.Values()...)
+
 	// TODO
 	// dmarc: p=none recommendation?
 	// spf: further recommendations ?
@@ -0,0 +1,1097 @@
+package check
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+const (
+	spfMaxLookups     = 10 // RFC 7208 4.6.4
+	spfMaxVoidLookups = 2  // RFC 7208 4.6.4
+)
+
+// SPFRecord is a parsed SPF record (RFC 7208).
+type SPFRecord struct {
+	Text     string
+	Terms    []SPFTerm
+	Redirect string
+	Exp      string
+}
+
+type SPFTerm struct {
+	Qualifier byte   // '+', '-', '~' or '?'
+	Mechanism string // all, include, a, mx, ptr, ip4, ip6 or exists
+	Value     string // domain-spec or network, empty if not given
+	CIDR4     int
+	CIDR6     int
+	Macro     bool
+}
+
+func (t SPFTerm) String() string {
+	s := t.Mechanism
+
+	if t.Qualifier != '+' {
+		s = string(t.Qualifier) + s
+	}
+
+	switch t.Mechanism {
+	case "ip4", "ip6":
+		return s + ":" + t.network()
+	case "a", "mx":
+		if t.Value != "" {
+			s += ":" + t.Value
+		}
+
+		if t.CIDR4 != 32 {
+			s += "/" + strconv.Itoa(t.CIDR4)
+		}
+
+		if t.CIDR6 != 128 {
+			s += "//" + strconv.Itoa(t.CIDR6)
+		}
+	default:
+		if t.Value != "" {
+			s += ":" + t.Value
+		}
+	}
+
+	return s
+}
+
+// network returns the network of an ip4 or ip6 mechanism.
+func (t SPFTerm) network() string {
+	switch {
+	case t.Mechanism == "ip4" && t.CIDR4 != 32:
+		return t.Value + "/" + strconv.Itoa(t.CIDR4)
+	case t.Mechanism == "ip6" && t.CIDR6 != 128:
+		return t.Value + "/" + strconv.Itoa(t.CIDR6)
+	default:
+		return t.Value
+	}
+}
+
+// ParseSPF parses an SPF record. It returns an error for every syntax error that
+// results in a permerror.
+func ParseSPF(record string) (*SPFRecord, error) {
+	rec := &SPFRecord{Text: record}
+
+	terms := strings.Fields(record)
+	if len(terms) == 0 || !strings.EqualFold(terms[0], "v=spf1") {
+		return rec, fmt.Errorf("record does not start with v=spf1")
+	}
+
+	for _, term := range terms[1:] {
+		if i := strings.IndexByte(term, '='); i > 0 && !strings.ContainsAny(term[:i], ":/") {
+			if err := rec.parseModifier(strings.ToLower(term[:i]), term[i+1:]); err != nil {
+				return rec, err
+			}
+
+			continue
+		}
+
+		t, err := parseSPFTerm(term)
+		if err != nil {
+			return rec, err
+		}
+
+		rec.Terms = append(rec.Terms, t)
+	}
+
+	return rec, nil
+}
+
+func (rec *SPFRecord) parseModifier(name, value string) error {
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || strings.ContainsRune("-_.", c)) {
+			return fmt.Errorf("invalid modifier name %s", name)
+		}
+	}
+
+	if err := checkMacro(value, name == "exp"); err != nil {
+		return fmt.Errorf("%s=%s: %s", name, value, err)
+	}
+
+	switch name {
+	case "redirect":
+		if rec.Redirect != "" {
+			return fmt.Errorf("redirect modifier appears more than once")
+		}
+
+		if value == "" {
+			return fmt.Errorf("redirect modifier without domain")
+		}
+
+		rec.Redirect = value
+	case "exp":
+		if rec.Exp != "" {
+			return fmt.Errorf("exp modifier appears more than once")
+		}
+
+		rec.Exp = value
+	}
+
+	return nil
+}
+
+func parseSPFTerm(term string) (SPFTerm, error) {
+	t := SPFTerm{Qualifier: '+', CIDR4: 32, CIDR6: 128}
+
+	if strings.ContainsRune("+-~?", rune(term[0])) {
+		t.Qualifier = term[0]
+		term = term[1:]
+	}
+
+	name := term
+	arg := ""
+
+	if i := strings.IndexAny(term, ":/"); i >= 0 {
+		name = term[:i]
+		arg = term[i:]
+	}
+
+	t.Mechanism = strings.ToLower(name)
+
+	var err error
+
+	switch t.Mechanism {
+	case "all":
+		if arg != "" {
+			return t, fmt.Errorf("all does not take arguments: %s", term)
+		}
+	case "include", "exists":
+		if !strings.HasPrefix(arg, ":") || len(arg) == 1 {
+			return t, fmt.Errorf("%s needs a domain: %s", t.Mechanism, term)
+		}
+
+		t.Value = arg[1:]
+	case "ptr":
+		if strings.HasPrefix(arg, ":") {
+			t.Value = arg[1:]
+		} else if arg != "" {
+			return t, fmt.Errorf("ptr does not take a cidr: %s", term)
+		}
+	case "a", "mx":
+		t.Value, t.CIDR4, t.CIDR6, err = parseDualCIDR(strings.TrimPrefix(arg, ":"))
+		if err != nil {
+			return t, fmt.Errorf("%s: %s", term, err)
+		}
+	case "ip4", "ip6":
+		if !strings.HasPrefix(arg, ":") {
+			return t, fmt.Errorf("%s needs a network: %s", t.Mechanism, term)
+		}
+
+		if err := t.parseNetwork(arg[1:]); err != nil {
+			return t, fmt.Errorf("%s: %s", term, err)
+		}
+	default:
+		return t, fmt.Errorf("unknown mechanism %s", term)
+	}
+
+	if t.Value != "" && t.Mechanism != "ip4" && t.Mechanism != "ip6" {
+		if err := checkMacro(t.Value, false); err != nil {
+			return t, fmt.Errorf("%s: %s", term, err)
+		}
+
+		t.Macro = strings.Contains(t.Value, "%")
+	}
+
+	return t, nil
+}
+
+func (t *SPFTerm) parseNetwork(network string) error {
+	ip := network
+	cidr := ""
+
+	if i := strings.IndexByte(network, '/'); i >= 0 {
+		ip, cidr = network[:i], network[i+1:]
+	}
+
+	parsed := net.ParseIP(ip)
+	if parsed == nil || (t.Mechanism == "ip4") != (parsed.To4() != nil && !strings.Contains(ip, ":")) {
+		return fmt.Errorf("invalid %s address %s", t.Mechanism, ip)
+	}
+
+	t.Value = ip
+
+	if cidr == "" {
+		return nil
+	}
+
+	n, err := strconv.Atoi(cidr)
+	if err != nil || n < 0 || (t.Mechanism == "ip4" && n > 32) || n > 128 || strings.HasPrefix(cidr, "0") && n != 0 {
+		return fmt.Errorf("invalid cidr length /%s", cidr)
+	}
+
+	if t.Mechanism == "ip4" {
+		t.CIDR4 = n
+	} else {
+		t.CIDR6 = n
+	}
+
+	return nil
+}
+
+// parseDualCIDR parses domain/ip4-cidr//ip6-cidr as used by a and mx.
+func parseDualCIDR(s string) (string, int, int, error) {
+	c4, c6 := 32, 128
+
+	i := strings.IndexByte(s, '/')
+	if i < 0 {
+		return s, c4, c6, nil
+	}
+
+	domain, rest := s[:i], s[i:]
+
+	if j := strings.Index(rest, "//"); j >= 0 {
+		n, err := strconv.Atoi(rest[j+2:])
+		if err != nil || n < 0 || n > 128 {
+			return domain, c4, c6, fmt.Errorf("invalid ip6 cidr length %s", rest[j:])
+		}
+
+		c6 = n
+		rest = rest[:j]
+	}
+
+	if rest != "" {
+		n, err := strconv.Atoi(rest[1:])
+		if err != nil || n < 0 || n > 32 {
+			return domain, c4, c6, fmt.Errorf("invalid ip4 cidr length %s", rest)
+		}
+
+		c4 = n
+	}
+
+	return domain, c4, c6, nil
+}
+
+// checkMacro checks the macro syntax of a macro-string (RFC 7208 7.1).
+func checkMacro(s string, exp bool) error {
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			continue
+		}
+
+		if i+1 >= len(s) {
+			return fmt.Errorf("incomplete macro")
+		}
+
+		i++
+
+		switch s[i] {
+		case '%', '_', '-':
+			continue
+		case '{':
+		default:
+			return fmt.Errorf("invalid macro %%%c", s[i])
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if end < 2 {
+			return fmt.Errorf("invalid macro %s", s[i-1:])
+		}
+
+		letter := strings.ToLower(s[i+1 : i+2])
+		if !strings.Contains("slodiphv", letter) && !(exp && strings.Contains("crt", letter)) {
+			return fmt.Errorf("invalid macro letter %s", letter)
+		}
+
+		transformers := s[i+2 : i+end]
+		transformers = strings.TrimLeft(transformers, "0123456789")
+		transformers = strings.TrimPrefix(strings.TrimPrefix(transformers, "r"), "R")
+
+		if strings.Trim(transformers, ".-+,/_=") != "" {
+			return fmt.Errorf("invalid macro %s", s[i-1:i+end+1])
+		}
+
+		i += end
+	}
+
+	return nil
+}
+
+// SPF expands and evaluates SPF records against a resolver.
+type SPF struct {
+	Lookup      func(name string, qtype uint16) ([]dns.RR, error)
+	Lookups     int      // DNS querying mechanisms and modifiers (RFC 7208 4.6.4)
+	VoidLookups int      // DNS queries that returned no records
+	Queries     []string // every DNS query that was done
+}
+
+// SPFNode is an SPF record with its included and redirected records.
+type SPFNode struct {
+	Domain   string
+	Via      string // the term that led to this record
+	Record   string
+	IPs      []string
+	Children []*SPFNode
+	Errors   []string
+	Notes    []string
+}
+
+func NewSPF(s *scan.Scan) *SPF {
+	return &SPF{Lookup: resolverLookup(s)}
+}
+
+// query asks the resolver and counts void lookups if void is set.
+func (e *SPF) query(name string, qtype uint16, void bool) ([]dns.RR, error) {
+	e.Queries = append(e.Queries, fmt.Sprintf("%s %s", dns.Fqdn(name), dns.TypeToString[qtype]))
+
+	rrs, err := e.Lookup(dns.Fqdn(name), qtype)
+	if err == nil && len(rrs) == 0 && void {
+		e.VoidLookups++
+	}
+
+	return rrs, err
+}
+
+// Record fetches and parses the SPF record of domain.
+func (e *SPF) Record(domain string) (*SPFRecord, error) {
+	return e.record(domain, false)
+}
+
+func (e *SPF) record(domain string, void bool) (*SPFRecord, error) {
+	txt, err := e.query(domain, dns.TypeTXT, void)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []string
+
+	for _, rr := range txt {
+		if s := txtString(rr); isSPF(s) {
+			records = append(records, s)
+		}
+	}
+
+	switch len(records) {
+	case 0:
+		return nil, fmt.Errorf("no SPF record found for %s", domain)
+	case 1:
+		return ParseSPF(records[0])
+	default:
+		return nil, fmt.Errorf("multiple SPF records found for %s (RFC7208 4.5)", domain)
+	}
+}
+
+// addresses returns the networks of the a or mx mechanism t for domain.
+func (e *SPF) addresses(t SPFTerm, domain string) ([]*net.IPNet, error) {
+	target := domain
+	if t.Value != "" {
+		target = t.Value
+	}
+
+	hosts := []string{target}
+
+	if t.Mechanism == "mx" {
+		mx, err := e.query(target, dns.TypeMX, true)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(mx) > spfMaxLookups {
+			return nil, fmt.Errorf("mx:%s has more than %d MX records (RFC7208 4.6.4)", target, spfMaxLookups)
+		}
+
+		hosts = []string{}
+		for _, rr := range mx {
+			hosts = append(hosts, rr.(*dns.MX).Mx)
+		}
+	}
+
+	var nets []*net.IPNet
+
+	for _, host := range hosts {
+		for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+			rrs, err := e.query(host, qtype, false)
+			if err != nil {
+				return nets, err
+			}
+
+			for _, ip := range extractIP(rrs) {
+				if ip.To4() != nil {
+					nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(t.CIDR4, 32)})
+				} else {
+					nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(t.CIDR6, 128)})
+				}
+			}
+		}
+	}
+
+	if len(nets) == 0 && t.Mechanism == "a" {
+		e.VoidLookups++
+	}
+
+	return nets, nil
+}
+
+// Expand fetches the SPF record of domain and recursively expands every mechanism.
+func (e *SPF) Expand(domain string) *SPFNode {
+	return e.expand(dns.Fqdn(domain), "", make(map[string]bool))
+}
+
+func (e *SPF) expand(domain, via string, path map[string]bool) *SPFNode {
+	n := &SPFNode{Domain: domain, Via: via}
+
+	key := strings.ToLower(dns.Fqdn(domain))
+	if path[key] {
+		n.Errors = append(n.Errors, fmt.Sprintf("loop detected: %s includes itself", domain))
+		return n
+	}
+
+	path[key] = true
+	defer delete(path, key)
+
+	rec, err := e.record(domain, via != "")
+	if rec != nil {
+		n.Record = rec.Text
+	}
+
+	if err != nil {
+		n.Errors = append(n.Errors, err.Error())
+		return n
+	}
+
+	hasAll := false
+
+	for _, t := range rec.Terms {
+		switch t.Mechanism {
+		case "include", "a", "mx", "ptr", "exists":
+			e.Lookups++
+		}
+
+		if t.Macro {
+			n.Notes = append(n.Notes, fmt.Sprintf("%s uses macros and is not expanded", t))
+			continue
+		}
+
+		switch t.Mechanism {
+		case "all":
+			hasAll = true
+		case "include":
+			n.Children = append(n.Children, e.expand(t.Value, t.String(), path))
+		case "a", "mx":
+			nets, err := e.addresses(t, domain)
+			if err != nil {
+				n.Errors = append(n.Errors, fmt.Sprintf("%s: %s", t, err))
+			}
+
+			for _, ipnet := range nets {
+				if ones, bits := ipnet.Mask.Size(); ones == bits && t.Qualifier == '+' {
+					n.IPs = append(n.IPs, ipnet.IP.String())
+				} else if t.Qualifier == '+' {
+					n.IPs = append(n.IPs, ipnet.String())
+				}
+			}
+		case "ptr":
+			n.Notes = append(n.Notes, "ptr mechanism should not be used (RFC7208 5.5)")
+		case "exists":
+			if _, err := e.query(t.Value, dns.TypeA, true); err != nil {
+				n.Errors = append(n.Errors, fmt.Sprintf("%s: %s", t, err))
+			}
+		case "ip4", "ip6":
+			if t.Qualifier == '+' {
+				n.IPs = append(n.IPs, t.network())
+			}
+		}
+	}
+
+	if rec.Redirect != "" {
+		if hasAll {
+			n.Notes = append(n.Notes, "redirect is ignored because the record has an all mechanism")
+		} else {
+			e.Lookups++
+
+			if strings.Contains(rec.Redirect, "%") {
+				n.Notes = append(n.Notes, fmt.Sprintf("redirect=%s uses macros and is not expanded", rec.Redirect))
+			} else {
+				n.Children = append(n.Children, e.expand(rec.Redirect, "redirect="+rec.Redirect, path))
+			}
+		}
+	}
+
+	return n
+}
+
+// Lines returns the expanded tree as indented lines.
+func (n *SPFNode) Lines() []string {
+	return n.lines("")
+}
+
+func (n *SPFNode) lines(indent string) []string {
+	name := n.Domain
+	if n.Via != "" {
+		name = n.Via
+	}
+
+	lines := []string{fmt.Sprintf("%s%s %q", indent, name, n.Record)}
+
+	for _, note := range n.Notes {
+		lines = append(lines, fmt.Sprintf("%s  note: %s", indent, note))
+	}
+
+	for _, err := range n.Errors {
+		lines = append(lines, fmt.Sprintf("%s  error: %s", indent, err))
+	}
+
+	for _, child := range n.Children {
+		lines = append(lines, child.lines(indent+"  ")...)
+	}
+
+	return lines
+}
+
+// Flatten returns the sorted list of networks that are authorized by the tree.
+func (n *SPFNode) Flatten() []string {
+	m := make(map[string]bool)
+
+	var walk func(*SPFNode)
+
+	walk = func(n *SPFNode) {
+		for _, ip := range n.IPs {
+			m[ip] = true
+		}
+
+		for _, child := range n.Children {
+			walk(child)
+		}
+	}
+
+	walk(n)
+
+	ips := []string{}
+	for ip := range m {
+		ips = append(ips, ip)
+	}
+
+	sort.Strings(ips)
+
+	return ips
+}
+
+// AllErrors returns the errors of the node and its children.
+func (n *SPFNode) AllErrors() []string {
+	errs := append([]string{}, n.Errors...)
+
+	for _, child := range n.Children {
+		errs = append(errs, child.AllErrors()...)
+	}
+
+	return errs
+}
+
+// isSPF returns true if txt is an SPF record: "v=spf1" followed by a space or
+// nothing (RFC7208 4.5).
+func isSPF(txt string) bool {
+	if len(txt) < 6 || !strings.EqualFold(txt[:6], "v=spf1") {
+		return false
+	}
+
+	return len(txt) == 6 || txt[6] == ' '
+}
+
+// spfPolicyValues reports the qualifier of the all mechanism and the use of ptr.
+func spfPolicyValues(rec *SPFRecord) []ReportResult {
+	var (
+		results []ReportResult
+		all     *SPFTerm
+		ptr     bool
+	)
+
+	for i, t := range rec.Terms {
+		switch t.Mechanism {
+		case "all":
+			if all == nil {
+				all = &rec.Terms[i]
+			}
+		case "ptr":
+			ptr = true
+		}
+	}
+
+	switch {
+	case all == nil && rec.Redirect != "":
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("INFO: SPF record redirects to %s for its policy.", rec.Redirect),
+			Status: true, Name: "SPF",
+		})
+	case all == nil:
+		results = append(results, ReportResult{
+			Result: "WARN: SPF record has no all mechanism, other hosts get a neutral result.",
+			Status: false, Name: "SPF",
+		})
+	case all.Qualifier == '-':
+		results = append(results, ReportResult{
+			Result: "OK  : SPF records set up restrictively.",
+			Status: true, Name: "SPF",
+		})
+	case all.Qualifier == '~':
+		results = append(results, ReportResult{
+			Result: "WARN: SPF record set to softfail.",
+			Status: true, Name: "SPF",
+		})
+	case all.Qualifier == '?':
+		results = append(results, ReportResult{
+			Result: "WARN: SPF record set to neutral (?all), it doesn't protect your domain.",
+			Status: false, Name: "SPF",
+		})
+	default:
+		results = append(results, ReportResult{
+			Result: "FAIL: SPF record allows every host to send mail (+all).",
+			Status: false, Name: "SPF",
+		})
+	}
+
+	if ptr {
+		results = append(results, ReportResult{
+			Result: "WARN: SPF record uses ptr mechanism (see RFC7208 5.5).",
+			Status: true, Name: "SPF",
+		})
+	}
+
+	return results
+}
+
+// spfValues reports the result of an expanded SPF record.
+func spfValues(tree *SPFNode, lookups, voids int) []ReportResult {
+	var results []ReportResult
+
+	records := append(tree.Lines(), "flattened: "+strings.Join(tree.Flatten(), " "))
+
+	results = append(results, ReportResult{
+		Result: fmt.Sprintf("INFO: SPF record expands to %d networks.", len(tree.Flatten())),
+		Status: true, Records: records, Name: "SPFExpand",
+	})
+
+	for _, err := range tree.AllErrors() {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: SPF error: %s", err),
+			Status: false, Name: "SPFExpand",
+		})
+	}
+
+	if lookups > spfMaxLookups {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: SPF record needs %d DNS lookups, the limit is %d (RFC7208 4.6.4).", lookups, spfMaxLookups),
+			Status: false, Name: "SPFLookups",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : SPF record needs %d of %d DNS lookups.", lookups, spfMaxLookups),
+			Status: true, Name: "SPFLookups",
+		})
+	}
+
+	if voids > spfMaxVoidLookups {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: SPF record has %d void lookups, the limit is %d (RFC7208 4.6.4).", voids, spfMaxVoidLookups),
+			Status: false, Name: "SPFVoidLookups",
+		})
+	}
+
+	return results
+}
+
+// SPF results (RFC 7208 2.6).
+const (
+	SPFNone      = "none"
+	SPFNeutral   = "neutral"
+	SPFPass      = "pass"
+	SPFFail      = "fail"
+	SPFSoftfail  = "softfail"
+	SPFTemperror = "temperror"
+	SPFPermerror = "permerror"
+)
+
+// SPFResult is the result of check_host().
+type SPFResult struct {
+	Result      string
+	Domain      string // domain of the record that decided the result
+	Mechanism   string // term that matched, empty if no term matched
+	Explanation string `json:",omitempty"`
+	Error       string `json:",omitempty"`
+	Queries     []string
+}
+
+// spfHost holds the arguments of check_host() that are used in macros.
+type spfHost struct {
+	ip     net.IP
+	sender string
+	helo   string
+}
+
+// CheckHost evaluates the SPF record of domain for a mail from sender (MAIL FROM)
+// sent by ip that said helo, like check_host() in RFC 7208 4.
+func (e *SPF) CheckHost(ip net.IP, domain, sender, helo string) SPFResult {
+	if sender == "" {
+		sender = "postmaster@" + helo
+	}
+
+	if !strings.Contains(sender, "@") {
+		sender = "postmaster@" + sender
+	}
+
+	h := &spfHost{ip: ip, sender: sender, helo: helo}
+
+	res := e.checkHost(h, dns.Fqdn(domain), 0)
+	if res.Result != SPFTemperror && res.Result != SPFPermerror && e.VoidLookups > spfMaxVoidLookups {
+		res = SPFResult{Result: SPFPermerror, Domain: res.Domain, Error: fmt.Sprintf("more than %d void lookups (RFC7208 4.6.4)", spfMaxVoidLookups)}
+	}
+
+	res.Queries = e.Queries
+
+	return res
+}
+
+func (e *SPF) checkHost(h *spfHost, domain string, depth int) SPFResult {
+	res := SPFResult{Domain: domain}
+
+	rec, err := e.record(domain, depth > 0)
+	if err != nil {
+		switch {
+		case rec != nil, strings.Contains(err.Error(), "multiple SPF"):
+			res.Result = SPFPermerror
+		case strings.Contains(err.Error(), "no SPF record"):
+			res.Result = SPFNone
+		default:
+			res.Result = SPFTemperror
+		}
+
+		res.Error = err.Error()
+
+		return res
+	}
+
+	for _, t := range rec.Terms {
+		switch t.Mechanism {
+		case "include", "a", "mx", "ptr", "exists":
+			e.Lookups++
+			if e.Lookups > spfMaxLookups {
+				return SPFResult{Result: SPFPermerror, Domain: domain, Error: fmt.Sprintf("more than %d DNS lookups (RFC7208 4.6.4)", spfMaxLookups)}
+			}
+		}
+
+		if t.Value != "" && t.Mechanism != "ip4" && t.Mechanism != "ip6" {
+			t.Value = h.expand(t.Value, domain)
+		}
+
+		match, err := e.match(h, t, domain, depth)
+		if err != nil {
+			return SPFResult{Result: SPFTemperror, Domain: domain, Mechanism: t.String(), Error: err.Error()}
+		}
+
+		if match.Result == SPFPermerror || match.Result == SPFTemperror {
+			return match
+		}
+
+		if match.Result != SPFPass {
+			continue
+		}
+
+		res.Mechanism = t.String()
+		if match.Mechanism != "" {
+			res.Mechanism += " -> " + match.Mechanism + " (" + match.Domain + ")"
+		}
+
+		switch t.Qualifier {
+		case '-':
+			res.Result = SPFFail
+			res.Explanation = e.explanation(h, rec, domain)
+		case '~':
+			res.Result = SPFSoftfail
+		case '?':
+			res.Result = SPFNeutral
+		default:
+			res.Result = SPFPass
+		}
+
+		return res
+	}
+
+	if rec.Redirect != "" {
+		e.Lookups++
+		if e.Lookups > spfMaxLookups {
+			return SPFResult{Result: SPFPermerror, Domain: domain, Error: fmt.Sprintf("more than %d DNS lookups (RFC7208 4.6.4)", spfMaxLookups)}
+		}
+
+		target := dns.Fqdn(h.expand(rec.Redirect, domain))
+
+		r := e.checkHost(h, target, depth+1)
+		if r.Result == SPFNone {
+			r.Result = SPFPermerror
+			r.Error = fmt.Sprintf("redirect=%s has no SPF record", target)
+		}
+
+		return r
+	}
+
+	res.Result = SPFNeutral
+
+	return res
+}
+
+// match evaluates a single mechanism. A matching mechanism returns pass.
+func (e *SPF) match(h *spfHost, t SPFTerm, domain string, depth int) (SPFResult, error) {
+	yes := SPFResult{Result: SPFPass}
+	no := SPFResult{Result: SPFNeutral}
+
+	switch t.Mechanism {
+	case "all":
+		return yes, nil
+	case "include":
+		if depth > spfMaxLookups {
+			return SPFResult{Result: SPFPermerror, Domain: domain, Error: "include loop"}, nil
+		}
+
+		r := e.checkHost(h, dns.Fqdn(t.Value), depth+1)
+
+		switch r.Result {
+		case SPFPass:
+			return r, nil
+		case SPFTemperror:
+			return r, nil
+		case SPFNone, SPFPermerror:
+			r.Result = SPFPermerror
+			if r.Error == "" {
+				r.Error = fmt.Sprintf("include:%s has no SPF record", t.Value)
+			}
+
+			return r, nil
+		default:
+			return no, nil
+		}
+	case "a", "mx":
+		nets, err := e.addresses(t, domain)
+		if err != nil {
+			if strings.Contains(err.Error(), "more than") {
+				return SPFResult{Result: SPFPermerror, Domain: domain, Error: err.Error()}, nil
+			}
+
+			return no, err
+		}
+
+		for _, ipnet := range nets {
+			if ipnet.Contains(h.ip) {
+				return yes, nil
+			}
+		}
+	case "ip4", "ip6":
+		if (t.Mechanism == "ip4") != (h.ip.To4() != nil) {
+			return no, nil
+		}
+
+		ipnet := &net.IPNet{IP: net.ParseIP(t.Value), Mask: net.CIDRMask(t.CIDR6, 128)}
+		if t.Mechanism == "ip4" {
+			ipnet = &net.IPNet{IP: net.ParseIP(t.Value).To4(), Mask: net.CIDRMask(t.CIDR4, 32)}
+		}
+
+		if ipnet.Contains(h.ip) {
+			return yes, nil
+		}
+	case "exists":
+		rrs, err := e.query(t.Value, dns.TypeA, true)
+		if err != nil {
+			return no, err
+		}
+
+		if len(rrs) > 0 {
+			return yes, nil
+		}
+	case "ptr":
+		target := domain
+		if t.Value != "" {
+			target = dns.Fqdn(t.Value)
+		}
+
+		for _, name := range e.validatedNames(h.ip) {
+			if dns.IsSubDomain(strings.ToLower(target), strings.ToLower(name)) {
+				return yes, nil
+			}
+		}
+	}
+
+	return no, nil
+}
+
+// validatedNames returns the PTR names of ip that resolve back to ip (RFC 7208 5.5).
+func (e *SPF) validatedNames(ip net.IP) []string {
+	var names []string
+
+	rev, err := dns.ReverseAddr(ip.String())
+	if err != nil {
+		return names
+	}
+
+	ptrs, err := e.query(rev, dns.TypePTR, true)
+	if err != nil {
+		return names
+	}
+
+	qtype := dns.TypeA
+	if ip.To4() == nil {
+		qtype = dns.TypeAAAA
+	}
+
+	for i, rr := range ptrs {
+		if i >= spfMaxLookups {
+			break
+		}
+
+		name := rr.(*dns.PTR).Ptr
+
+		rrs, err := e.query(name, qtype, false)
+		if err != nil {
+			continue
+		}
+
+		for _, addr := range extractIP(rrs) {
+			if addr.Equal(ip) {
+				names = append(names, name)
+				break
+			}
+		}
+	}
+
+	return names
+}
+
+// explanation returns the expanded exp= text of a record, if any.
+func (e *SPF) explanation(h *spfHost, rec *SPFRecord, domain string) string {
+	if rec.Exp == "" {
+		return ""
+	}
+
+	txt, err := e.query(h.expand(rec.Exp, domain), dns.TypeTXT, false)
+	if err != nil || len(txt) != 1 {
+		return ""
+	}
+
+	return h.expand(txtString(txt[0]), domain)
+}
+
+// expand expands the macros in a macro-string (RFC 7208 7).
+func (h *spfHost) expand(s, domain string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' || i+1 >= len(s) {
+			sb.WriteByte(s[i])
+			continue
+		}
+
+		i++
+
+		switch s[i] {
+		case '%':
+			sb.WriteByte('%')
+			continue
+		case '_':
+			sb.WriteByte(' ')
+			continue
+		case '-':
+			sb.WriteString("%20")
+			continue
+		}
+
+		end := strings.IndexByte(s[i:], '}')
+		if s[i] != '{' || end < 2 {
+			sb.WriteByte('%')
+			sb.WriteByte(s[i])
+
+			continue
+		}
+
+		sb.WriteString(h.macro(s[i+1:i+end], domain))
+		i += end
+	}
+
+	out := sb.String()
+
+	// a domain-spec can't be longer than 253 characters, remove labels on the left
+	for len(out) > 253 && strings.Contains(out, ".") {
+		out = out[strings.IndexByte(out, '.')+1:]
+	}
+
+	return out
+}
+
+// macro expands a single macro without the braces, for example "ir" or "d2".
+func (h *spfHost) macro(m, domain string) string {
+	letter := m[0]
+	value := ""
+
+	local, senderDomain := h.sender, h.sender
+	if i := strings.LastIndexByte(h.sender, '@'); i >= 0 {
+		local, senderDomain = h.sender[:i], h.sender[i+1:]
+	}
+
+	switch letter | 0x20 {
+	case 's':
+		value = h.sender
+	case 'l':
+		value = local
+	case 'o':
+		value = senderDomain
+	case 'd':
+		value = strings.TrimSuffix(domain, ".")
+	case 'i':
+		if h.ip.To4() != nil {
+			value = h.ip.To4().String()
+		} else {
+			rev, _ := dns.ReverseAddr(h.ip.String())
+			value = strings.TrimSuffix(rev, ".ip6.arpa.")
+			parts := strings.Split(value, ".")
+
+			for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+				parts[i], parts[j] = parts[j], parts[i]
+			}
+
+			value = strings.Join(parts, ".")
+		}
+	case 'p':
+		value = "unknown"
+	case 'v':
+		value = "in-addr"
+		if h.ip.To4() == nil {
+			value = "ip6"
+		}
+	case 'h':
+		value = h.helo
+	}
+
+	rest := m[1:]
+	digits := strings.TrimLeft(rest, "0123456789")
+	keep, _ := strconv.Atoi(rest[:len(rest)-len(digits)])
+	reverse := strings.HasPrefix(strings.ToLower(digits), "r")
+
+	delims := "."
+	if d := strings.TrimLeft(digits, "rR"); d != "" {
+		delims = d
+	}
+
+	parts := strings.FieldsFunc(value, func(r rune) bool { return strings.ContainsRune(delims, r) })
+
+	if reverse {
+		for i, j := 0, len(parts)-1; i < j; i, j = i+1, j-1 {
+			parts[i], parts[j] = parts[j], parts[i]
+		}
+	}
+
+	if keep > 0 && keep < len(parts) {
+		parts = parts[len(parts)-keep:]
+	}
+
+	value = strings.Join(parts, ".")
+
+	if letter >= 'A' && letter <= 'Z' {
+		value = url.QueryEscape(value)
+	}
+
+	return value
+}
@@ -0,0 +1,217 @@
+package check
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// fakeLookup returns a lookup function that answers from records in zone file
+// format instead of asking a resolver.
+func fakeLookup(t *testing.T, records ...string) func(name string, qtype uint16) ([]dns.RR, error) {
+	t.Helper()
+
+	zone := make(map[string][]dns.RR)
+
+	for _, s := range records {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatalf("bad record %q: %s", s, err)
+		}
+
+		key := strings.ToLower(rr.Header().Name) + " " + dns.TypeToString[rr.Header().Rrtype]
+		zone[key] = append(zone[key], rr)
+	}
+
+	return func(name string, qtype uint16) ([]dns.RR, error) {
+		return zone[strings.ToLower(dns.Fqdn(name))+" "+dns.TypeToString[qtype]], nil
+	}
+}
+
+func TestParseSPF(t *testing.T) {
+	tests := []struct {
+		record   string
+		terms    []string
+		redirect string
+		err      string
+	}{
+		{record: "v=spf1 -all", terms: []string{"-all"}},
+		{record: "V=SPF1 ip4:192.0.2.0/24 ~all", terms: []string{"ip4:192.0.2.0/24", "~all"}},
+		{record: "v=spf1 a mx/24 ip6:2001:db8::/32 include:example.org ?all", terms: []string{"a", "mx/24", "ip6:2001:db8::/32", "include:example.org", "?all"}},
+		{record: "v=spf1 a:mail.example.com//64 -all", terms: []string{"a:mail.example.com//64", "-all"}},
+		{record: "v=spf1 redirect=_spf.example.com", redirect: "_spf.example.com"},
+		{record: "v=spf1 exists:%{ir}.%{l1r+-}._spf.%{d} -all", terms: []string{"exists:%{ir}.%{l1r+-}._spf.%{d}", "-all"}},
+		{record: "spf1 -all", err: "does not start with v=spf1"},
+		{record: "", err: "does not start with v=spf1"},
+		{record: "v=spf1 redirect=a.example redirect=b.example", err: "more than once"},
+		{record: "v=spf1 ip4:192.0.2.0/33 -all", err: "cidr"},
+		{record: "v=spf1 foo -all", err: "foo"},
+		{record: "v=spf1 exists:%{z}.example.com", err: "invalid macro letter"},
+	}
+
+	for _, tt := range tests {
+		rec, err := ParseSPF(tt.record)
+
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseSPF(%q) error = %v, want %q", tt.record, err, tt.err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("ParseSPF(%q) error = %s", tt.record, err)
+			continue
+		}
+
+		var terms []string
+		for _, term := range rec.Terms {
+			terms = append(terms, term.String())
+		}
+
+		if strings.Join(terms, " ") != strings.Join(tt.terms, " ") {
+			t.Errorf("ParseSPF(%q) terms = %v, want %v", tt.record, terms, tt.terms)
+		}
+
+		if rec.Redirect != tt.redirect {
+			t.Errorf("ParseSPF(%q) redirect = %q, want %q", tt.record, rec.Redirect, tt.redirect)
+		}
+	}
+}
+
+func TestIsSPF(t *testing.T) {
+	tests := []struct {
+		txt  string
+		want bool
+	}{
+		{"v=spf1", true},
+		{"v=spf1 -all", true},
+		{"V=SPF1 -all", true},
+		{"v=spf10 -all", false},
+		{"v=spf1-all", false},
+		{"v=spf2.0/pra -all", false},
+		{"google-site-verification=v=spf1", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := isSPF(tt.txt); got != tt.want {
+			t.Errorf("isSPF(%q) = %v, want %v", tt.txt, got, tt.want)
+		}
+	}
+}
+
+func TestCheckMacro(t *testing.T) {
+	tests := []struct {
+		s   string
+		exp bool
+		err bool
+	}{
+		{s: "example.com"},
+		{s: "%{i}._spf.%{d}"},
+		{s: "%{ir}.%{v}._spf.%{d2}"},
+		{s: "%{l1r+-}.%{o}"},
+		{s: "%%-%_-%-"},
+		{s: "%{c} %{r} %{t}", exp: true},
+		{s: "%{c}", err: true},
+		{s: "%{x}", err: true},
+		{s: "%{i", err: true},
+		{s: "%", err: true},
+		{s: "%a", err: true},
+		{s: "%{d2x}", err: true},
+	}
+
+	for _, tt := range tests {
+		err := checkMacro(tt.s, tt.exp)
+		if (err != nil) != tt.err {
+			t.Errorf("checkMacro(%q, %v) error = %v, want error %v", tt.s, tt.exp, err, tt.err)
+		}
+	}
+}
+
+func TestCheckHost(t *testing.T) {
+	lookup := fakeLookup(t,
+		`example.com. 300 IN TXT "v=spf1 ip4:192.0.2.0/24 include:_spf.example.net mx -all"`,
+		`example.com. 300 IN TXT "not an spf record"`,
+		`example.com. 300 IN MX 10 mail.example.com.`,
+		`mail.example.com. 300 IN A 198.51.100.25`,
+		`mail.example.com. 300 IN AAAA 2001:db8::25`,
+		`_spf.example.net. 300 IN TXT "v=spf1 ip4:203.0.113.0/24 ?all"`,
+		`soft.example. 300 IN TXT "v=spf1 ~all"`,
+		`redirect.example. 300 IN TXT "v=spf1 redirect=example.com"`,
+		`dangling.example. 300 IN TXT "v=spf1 redirect=none.example"`,
+		`double.example. 300 IN TXT "v=spf1 -all"`,
+		`double.example. 300 IN TXT "v=spf1 +all"`,
+		`broken.example. 300 IN TXT "v=spf1 ip4:300.0.0.1 -all"`,
+		`macro.example. 300 IN TXT "v=spf1 exists:%{i}.allow.macro.example -all"`,
+		`192.0.2.9.allow.macro.example. 300 IN A 127.0.0.2`,
+	)
+
+	tests := []struct {
+		ip     string
+		domain string
+		want   string
+	}{
+		{"192.0.2.10", "example.com", SPFPass},
+		{"198.51.100.25", "example.com", SPFPass},
+		{"2001:db8::25", "example.com", SPFPass},
+		{"203.0.113.5", "example.com", SPFPass},
+		{"198.51.100.26", "example.com", SPFFail},
+		{"192.0.2.10", "soft.example", SPFSoftfail},
+		{"192.0.2.10", "redirect.example", SPFPass},
+		{"198.51.100.26", "redirect.example", SPFFail},
+		{"192.0.2.10", "dangling.example", SPFPermerror},
+		{"192.0.2.10", "double.example", SPFPermerror},
+		{"192.0.2.10", "broken.example", SPFPermerror},
+		{"192.0.2.10", "none.example", SPFNone},
+		{"192.0.2.9", "macro.example", SPFPass},
+		{"192.0.2.8", "macro.example", SPFFail},
+	}
+
+	for _, tt := range tests {
+		e := &SPF{Lookup: lookup}
+
+		res := e.CheckHost(net.ParseIP(tt.ip), tt.domain, "user@"+tt.domain, "mail."+tt.domain)
+		if res.Result != tt.want {
+			t.Errorf("CheckHost(%s, %s) = %s (%s), want %s", tt.ip, tt.domain, res.Result, res.Error, tt.want)
+		}
+	}
+}
+
+func TestSPFPolicyValues(t *testing.T) {
+	tests := []struct {
+		record string
+		want   []string
+	}{
+		{"v=spf1 mx -all", []string{"OK  : SPF records set up restrictively."}},
+		{"v=spf1 mx ~all", []string{"WARN: SPF record set to softfail."}},
+		{"v=spf1 ?all", []string{"WARN: SPF record set to neutral"}},
+		{"v=spf1 +all", []string{"FAIL: SPF record allows every host"}},
+		{"v=spf1 all", []string{"FAIL: SPF record allows every host"}},
+		{"v=spf1 mx", []string{"WARN: SPF record has no all mechanism"}},
+		{"v=spf1 redirect=_spf.example.com", []string{"INFO: SPF record redirects to _spf.example.com"}},
+		{"v=spf1 ptr -all", []string{"OK  : SPF records set up restrictively.", "WARN: SPF record uses ptr mechanism"}},
+	}
+
+	for _, tt := range tests {
+		rec, err := ParseSPF(tt.record)
+		if err != nil {
+			t.Fatalf("ParseSPF(%q): %s", tt.record, err)
+		}
+
+		results := spfPolicyValues(rec)
+		if len(results) != len(tt.want) {
+			t.Errorf("spfPolicyValues(%q) = %v, want %v", tt.record, results, tt.want)
+			continue
+		}
+
+		for i, want := range tt.want {
+			if !strings.HasPrefix(results[i].Result, want) {
+				t.Errorf("spfPolicyValues(%q)[%d] = %q, want %q", tt.record, i, results[i].Result, want)
+			}
+		}
+	}
+}
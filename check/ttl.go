@@ -0,0 +1,418 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// TTLRange is the allowed range of a TTL in seconds.
+type TTLRange struct {
+	Min uint32
+	Max uint32
+}
+
+// TTLLimits are the TTL ranges outside of which the NS, SOA, MX and DNSKEY rrsets
+// are flagged as extremely low or high.
+var TTLLimits = map[uint16]TTLRange{
+	dns.TypeNS:     {Min: 3600, Max: 604800},
+	dns.TypeSOA:    {Min: 300, Max: 604800},
+	dns.TypeMX:     {Min: 300, Max: 604800},
+	dns.TypeDNSKEY: {Min: 300, Max: 172800},
+}
+
+// ttlTypes are the rrsets of the zone apex every nameserver is asked for.
+var ttlTypes = []uint16{dns.TypeNS, dns.TypeSOA, dns.TypeMX, dns.TypeDNSKEY}
+
+type TTLCheck struct {
+	NS     []structs.NSData
+	Parent []Delegation
+	RRsets []TTLData
+	// ParentScan has the delegations of the parent, nil asks the parent again
+	ParentScan *DelegationScan
+	Report
+	s *scan.Scan
+}
+
+// TTLData is an rrset as one nameserver ip sends it.
+type TTLData struct {
+	Name  string // nameserver
+	IP    string
+	Owner string
+	Type  string
+	RRset []dns.RR
+	Sigs  []dns.RR // RRSIGs over the rrset
+	Error string   `json:",omitempty"`
+}
+
+func NewTTL(s *scan.Scan, ns []structs.NSData) *TTLCheck {
+	c := &TTLCheck{
+		s:  s,
+		NS: ns,
+	}
+
+	return c
+}
+
+// Scan asks the parent nameservers for the delegation and every nameserver ip for
+// the apex rrsets and the addresses of the nameservers that have glue.
+func (c *TTLCheck) Scan(domain string) {
+	log.Debugf("TTL: scan")
+	defer log.Debugf("TTL: scan exit")
+
+	domain = dns.Fqdn(domain)
+
+	var err error
+
+	c.Parent, err = sharedDelegations(c.s, c.ParentScan, domain)
+	if err != nil {
+		log.Debugf("TTL: parent delegations of %s: %s", domain, err)
+	}
+
+	var glue []string
+
+	// the nameservers of the zone are only authoritative for the in-zone glue
+	for _, d := range c.Parent {
+		for _, rr := range d.Glue {
+			if name := strings.ToLower(rr.Header().Name); dns.IsSubDomain(domain, name) && !hasValue(glue, name) {
+				glue = append(glue, name)
+			}
+		}
+	}
+
+	sort.Strings(glue)
+
+	for _, ns := range c.NS {
+		for _, nsip := range c.s.UsableIPs(ns.IP) {
+			for _, qtype := range ttlTypes {
+				c.RRsets = append(c.RRsets, ttlQuery(ns.Name, nsip.String(), domain, qtype))
+			}
+
+			for _, name := range glue {
+				for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+					c.RRsets = append(c.RRsets, ttlQuery(ns.Name, nsip.String(), name, qtype))
+				}
+			}
+		}
+	}
+}
+
+// ttlQuery asks nameserver ns on ip for the rrset of owner and qtype with its
+// signatures.
+func ttlQuery(ns, ip, owner string, qtype uint16) TTLData {
+	data := TTLData{Name: ns, IP: ip, Owner: strings.ToLower(owner), Type: dns.TypeToString[qtype]}
+
+	res, err := scan.Query(owner, qtype, ip, true)
+	if err != nil {
+		if !strings.Contains(err.Error(), "NXDOMAIN") {
+			data.Error = err.Error()
+		}
+
+		return data
+	}
+
+	data.RRset = extractRR(res.Msg.Answer, qtype)
+
+	for _, rr := range extractRR(res.Msg.Answer, dns.TypeRRSIG) {
+		if rr.(*dns.RRSIG).TypeCovered == qtype {
+			data.Sigs = append(data.Sigs, rr)
+		}
+	}
+
+	return data
+}
+
+// ttls returns the distinct TTLs of rrset, sorted.
+func ttls(rrset []dns.RR) []uint32 {
+	var out []uint32
+
+	seen := make(map[uint32]bool)
+
+	for _, rr := range rrset {
+		if ttl := rr.Header().Ttl; !seen[ttl] {
+			seen[ttl] = true
+			out = append(out, ttl)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+
+	return out
+}
+
+// ttlsString returns ttls as durations for messages.
+func ttlsString(ttls []uint32) string {
+	var s []string
+
+	for _, ttl := range ttls {
+		s = append(s, ttlString(ttl))
+	}
+
+	return strings.Join(s, ", ")
+}
+
+// server is the name and ip of the nameserver for messages.
+func (d TTLData) server() string {
+	return fmt.Sprintf("%s(%s)", d.Name, d.IP)
+}
+
+// rrsetID is the owner and type of the rrset for messages.
+func (d TTLData) rrsetID() string {
+	return d.Owner + " " + d.Type
+}
+
+// parentValues compares the TTLs of the NS and glue records at the parent with the
+// TTLs of the same records at the nameservers of the zone.
+func (c *TTLCheck) parentValues(domain string) []ReportResult {
+	var results []ReportResult
+
+	parent := make(map[string][]dns.RR)
+
+	var ids []string
+
+	for _, d := range c.Parent {
+		if d.Error != "" {
+			continue
+		}
+
+		for _, rr := range append(append([]dns.RR{}, d.NS...), d.Glue...) {
+			id := strings.ToLower(rr.Header().Name) + " " + dns.TypeToString[rr.Header().Rrtype]
+			if parent[id] == nil {
+				ids = append(ids, id)
+			}
+
+			parent[id] = append(parent[id], rr)
+		}
+	}
+
+	child := make(map[string][]dns.RR)
+
+	for _, data := range c.RRsets {
+		child[data.rrsetID()] = append(child[data.rrsetID()], data.RRset...)
+	}
+
+	for _, id := range ids {
+		if len(child[id]) == 0 {
+			continue
+		}
+
+		p, ch := ttls(parent[id]), ttls(child[id])
+		if ttlsString(p) == ttlsString(ch) {
+			continue
+		}
+
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("INFO: TTL of %s is %s at the parent and %s at the nameservers, resolvers can cache either. Wait for the longest after changing it.", id, ttlsString(p), ttlsString(ch)),
+			Status: true, Name: "ParentChildTTL",
+		})
+	}
+
+	if len(results) == 0 && len(ids) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : TTLs of the NS and glue records at parent %s match the nameservers.", dns.Fqdn(getParentDomain(domain))),
+			Status: true, Name: "ParentChildTTL",
+		})
+	}
+
+	return results
+}
+
+// consistencyValues reports rrsets with different TTLs on one server (RFC 2181
+// section 5.2) or between the servers.
+func consistencyValues(rrsets []TTLData) []ReportResult {
+	var (
+		results []ReportResult
+		ids     []string
+	)
+
+	servers := make(map[string]map[string][]string)
+
+	for _, data := range rrsets {
+		if data.Error != "" || len(data.RRset) == 0 {
+			continue
+		}
+
+		t := ttls(data.RRset)
+
+		if len(t) > 1 {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("FAIL: Records of %s on %s have different TTLs (%s), an rrset must have one TTL (RFC 2181).", data.rrsetID(), data.server(), ttlsString(t)),
+				Status: false, Name: "TTLConsistent",
+			})
+		}
+
+		id := data.rrsetID()
+		if servers[id] == nil {
+			ids = append(ids, id)
+			servers[id] = make(map[string][]string)
+		}
+
+		servers[id][ttlsString(t)] = append(servers[id][ttlsString(t)], data.server())
+	}
+
+	for _, id := range ids {
+		if len(servers[id]) < 2 {
+			continue
+		}
+
+		res := ReportResult{
+			Result: fmt.Sprintf("WARN: Nameservers send %s with different TTLs.", id),
+			Status: false, Name: "TTLConsistent",
+		}
+
+		var keys []string
+		for k := range servers[id] {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			res.Records = append(res.Records, fmt.Sprintf("%v: %s", servers[id][k], k))
+		}
+
+		results = append(results, res)
+	}
+
+	if len(results) == 0 && len(ids) > 0 {
+		results = append(results, ReportResult{
+			Result: "OK  : TTLs of every rrset are consistent.",
+			Status: true, Name: "TTLConsistent",
+		})
+	}
+
+	return results
+}
+
+// limitValues reports NS, SOA, MX and DNSKEY rrsets with a TTL outside TTLLimits.
+func limitValues(rrsets []TTLData) []ReportResult {
+	var results []ReportResult
+
+	seen := make(map[string]bool)
+
+	for _, data := range rrsets {
+		qtype := dns.StringToType[data.Type]
+
+		limit, ok := TTLLimits[qtype]
+		if !ok || len(data.RRset) == 0 {
+			continue
+		}
+
+		for _, ttl := range ttls(data.RRset) {
+			key := fmt.Sprintf("%s %d", data.rrsetID(), ttl)
+			if seen[key] {
+				continue
+			}
+
+			seen[key] = true
+
+			switch {
+			case ttl < limit.Min:
+				results = append(results, ReportResult{
+					Result: fmt.Sprintf("WARN: TTL of %s is very low (%s, minimum %s), this increases the load on the nameservers and the risk of outages.", data.rrsetID(), ttlString(ttl), ttlString(limit.Min)),
+					Status: false, Name: "TTLRange",
+				})
+			case ttl > limit.Max:
+				results = append(results, ReportResult{
+					Result: fmt.Sprintf("WARN: TTL of %s is very high (%s, maximum %s), changes take that long to reach all resolvers.", data.rrsetID(), ttlString(ttl), ttlString(limit.Max)),
+					Status: false, Name: "TTLRange",
+				})
+			}
+		}
+	}
+
+	if len(results) == 0 && len(seen) > 0 {
+		results = append(results, ReportResult{
+			Result: "OK  : TTLs of the NS, SOA, MX and DNSKEY records are in range.",
+			Status: true, Name: "TTLRange",
+		})
+	}
+
+	return results
+}
+
+// dnskeyValues reports DNSKEY TTLs longer than the validity window of the RRSIGs over
+// the DNSKEY rrset: a resolver can keep the keys cached longer than the signature
+// that validates them.
+func dnskeyValues(rrsets []TTLData) []ReportResult {
+	var (
+		results []ReportResult
+		keyTTL  uint32
+		window  uint32
+		found   bool
+	)
+
+	for _, data := range rrsets {
+		if data.Type != "DNSKEY" {
+			continue
+		}
+
+		for _, ttl := range ttls(data.RRset) {
+			if ttl > keyTTL {
+				keyTTL = ttl
+			}
+		}
+
+		for _, rr := range data.Sigs {
+			s := rr.(*dns.RRSIG)
+			if s.TypeCovered != dns.TypeDNSKEY || s.Expiration <= s.Inception {
+				continue
+			}
+
+			if w := s.Expiration - s.Inception; !found || w < window {
+				found, window = true, w
+			}
+		}
+	}
+
+	if keyTTL == 0 || !found {
+		return results
+	}
+
+	if keyTTL > window {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: DNSKEY TTL (%s) is longer than the validity window of the RRSIG over the DNSKEY rrset (%s).", ttlString(keyTTL), ttlString(window)),
+			Status: false, Name: "DNSKEYTTL",
+		})
+	} else {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : DNSKEY TTL (%s) is shorter than the validity window of the RRSIGs over the DNSKEY rrset (%s).", ttlString(keyTTL), ttlString(window)),
+			Status: true, Name: "DNSKEYTTL",
+		})
+	}
+
+	return results
+}
+
+func (c *TTLCheck) Values(domain string) []ReportResult {
+	var results []ReportResult
+
+	for _, data := range c.RRsets {
+		if data.Error != "" {
+			results = append(results, ReportResult{
+				Result: fmt.Sprintf("ERR : %s query failed on %s: %s", data.rrsetID(), data.server(), data.Error),
+				Status: false, Name: "TTL",
+			})
+		}
+	}
+
+	results = append(results, c.parentValues(domain)...)
+	results = append(results, consistencyValues(c.RRsets)...)
+	results = append(results, limitValues(c.RRsets)...)
+	results = append(results, dnskeyValues(c.RRsets)...)
+
+	return results
+}
+
+func (c *TTLCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "TTL"
+	c.Report.Result = append(c.Report.Result, c.Values(domain)...)
+
+	return c.Report
+}
@@ -1,8 +1,12 @@
 package check
 
 import (
+	"fmt"
 	"net"
+	"strings"
+	"time"
 
+	"github.com/42wim/dt/scan"
 	"github.com/42wim/dt/structs"
 	"github.com/ammario/ipisp"
 	"github.com/miekg/dns"
@@ -17,6 +21,23 @@ func getParentDomain(domain string) string {
 	return "."
 }
 
+// resolverLookup returns a lookup function that asks the resolver of s. NXDOMAIN
+// is not an error but returns no records.
+func resolverLookup(s *scan.Scan) func(name string, qtype uint16) ([]dns.RR, error) {
+	return func(name string, qtype uint16) ([]dns.RR, error) {
+		res, err := scan.Query(name, qtype, s.Resolver(), false)
+		if err != nil {
+			if strings.Contains(err.Error(), "NXDOMAIN") {
+				return []dns.RR{}, nil
+			}
+
+			return []dns.RR{}, err
+		}
+
+		return extractRR(res.Msg.Answer, qtype), nil
+	}
+}
+
 func extractRR(rrset []dns.RR, qtypes ...uint16) []dns.RR {
 	var out []dns.RR
 
@@ -58,6 +79,17 @@ func extractIP(rrset []dns.RR) []net.IP {
 	return ips
 }
 
+// hasValue returns true if values contains value, ignoring case.
+func hasValue(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func isRFC1918(ip net.IP) bool {
 	ten := net.IPNet{IP: net.ParseIP("10.0.0.0"), Mask: net.CIDRMask(8, 32)}
 	oneNineTwo := net.IPNet{IP: net.ParseIP("192.168.0.0"), Mask: net.CIDRMask(16, 32)}
@@ -108,3 +140,152 @@ func isSameSubnet(ips ...net.IP) bool {
 
 	return count == ipv4*len(ipnets)
 }
+
+// dsMatchesKey returns true if the DS record is a digest of key.
+func dsMatchesKey(ds *dns.DS, key *dns.DNSKEY) bool {
+	if ds.KeyTag != key.KeyTag() || ds.Algorithm != key.Algorithm {
+		return false
+	}
+
+	childDS := key.ToDS(ds.DigestType)
+	if childDS == nil {
+		return false
+	}
+
+	return strings.EqualFold(childDS.Digest, ds.Digest)
+}
+
+// keysInDS returns the DNSKEYs from keys that have a matching DS record in dsset.
+func keysInDS(keys []dns.RR, dsset []dns.RR) []*dns.DNSKEY {
+	var out []*dns.DNSKEY
+
+	for _, k := range keys {
+		key, ok := k.(*dns.DNSKEY)
+		if !ok {
+			continue
+		}
+
+		for _, rr := range dsset {
+			if ds, ok := rr.(*dns.DS); ok && dsMatchesKey(ds, key) {
+				out = append(out, key)
+				break
+			}
+		}
+	}
+
+	return out
+}
+
+// dnskeys returns the DNSKEY records in rrs.
+func dnskeys(rrs []dns.RR) []*dns.DNSKEY {
+	var out []*dns.DNSKEY
+
+	for _, rr := range rrs {
+		if key, ok := rr.(*dns.DNSKEY); ok {
+			out = append(out, key)
+		}
+	}
+
+	return out
+}
+
+// verifySigs tries every RRSIG in sigs with every key and returns the keytag
+// of the first key that has a valid signature over rrset.
+func verifySigs(keys []*dns.DNSKEY, rrset []dns.RR, sigs []dns.RR) (uint16, bool) {
+	if len(rrset) == 0 {
+		return 0, false
+	}
+
+	for _, rr := range sigs {
+		sig, ok := rr.(*dns.RRSIG)
+		if !ok || sig.TypeCovered != rrset[0].Header().Rrtype {
+			continue
+		}
+
+		for _, key := range keys {
+			if sig.KeyTag != key.KeyTag() {
+				continue
+			}
+
+			if sig.Verify(key, rrset) == nil && sig.ValidityPeriod(time.Now()) {
+				return key.KeyTag(), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+// getParentDS returns the DS rrset of domain from the first parent nameserver that answers.
+func getParentDS(s *scan.Scan, domain string) []dns.RR {
+	log.Debugf("Finding NS of parent: %s", dns.Fqdn(getParentDomain(domain)))
+
+	nsdata, err := s.FindNS(getParentDomain(domain))
+	if err != nil {
+		return []dns.RR{}
+	}
+
+	for _, ns := range nsdata {
+		for _, nsip := range ns.IP {
+			log.Debugf("Asking parent %s (%s) DS of %s", ns.Name, nsip.String(), domain)
+
+			rrset, _, err := scan.QueryRRset(domain, dns.TypeDS, nsip.String(), true)
+			if err == nil {
+				return rrset
+			}
+		}
+	}
+
+	return []dns.RR{}
+}
+
+// zoneOf returns the zone that name is in, from the SOA record in the answer or
+// authority section of a SOA query. Without a SOA it walks up to the parent name.
+func zoneOf(s *scan.Scan, name string) (string, error) {
+	for n := dns.Fqdn(name); ; n = dns.Fqdn(getParentDomain(n)) {
+		res, err := scan.Query(n, dns.TypeSOA, s.Resolver(), false)
+		if err != nil && !strings.Contains(err.Error(), "NXDOMAIN") {
+			return "", err
+		}
+
+		if res.Msg != nil {
+			for _, rr := range append(res.Msg.Answer, res.Msg.Ns...) {
+				if soa, ok := rr.(*dns.SOA); ok {
+					return soa.Hdr.Name, nil
+				}
+			}
+		}
+
+		if n == "." {
+			return "", fmt.Errorf("no SOA found for %s", name)
+		}
+	}
+}
+
+// lameNS returns the nameservers of zone that don't answer authoritatively for it.
+func lameNS(s *scan.Scan, zone string) ([]string, error) {
+	var lame []string
+
+	nsdata, err := s.FindNS(zone)
+	if err != nil {
+		return lame, err
+	}
+
+	for _, ns := range nsdata {
+		for _, nsip := range s.UsableIPs(ns.IP) {
+			// timeouts and other failures of the server aren't lame delegations
+			state, _, err := lameState(zone, nsip.String())
+			if state != LameUpward && state != LameRefused && state != LameNoAuth {
+				continue
+			}
+
+			if err != nil {
+				state += ": " + err.Error()
+			}
+
+			lame = append(lame, fmt.Sprintf("%s (%s): %s", ns.Name, nsip, state))
+		}
+	}
+
+	return lame, nil
+}
@@ -0,0 +1,533 @@
+package check
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/42wim/dt/scan"
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// maxListed is the maximum number of names listed in a single report line.
+const maxListed = 10
+
+// ZoneCheck verifies the DNSSEC signatures and the NSEC/NSEC3 chain of a complete zone.
+// The zone is fetched with AXFR unless RRs is already set.
+type ZoneCheck struct {
+	NS     []structs.NSData
+	Origin string
+	RRs    []dns.RR
+	Report
+	s *scan.Scan
+}
+
+type rrsetKey struct {
+	name  string
+	qtype uint16
+}
+
+// zoneData is a zone split up in rrsets.
+type zoneData struct {
+	origin string
+	rrsets map[rrsetKey][]dns.RR
+	sigs   map[rrsetKey][]*dns.RRSIG
+	types  map[string][]uint16 // types per owner name, without NSEC3 owners
+	cuts   map[string]bool     // delegation points
+	keys   map[uint16]*dns.DNSKEY
+}
+
+func NewZone(s *scan.Scan, ns []structs.NSData) *ZoneCheck {
+	c := &ZoneCheck{
+		s:  s,
+		NS: ns,
+	}
+
+	return c
+}
+
+func (c *ZoneCheck) Scan(domain string) {
+	log.Debugf("Zone: scan")
+	defer log.Debugf("Zone: scan exit")
+
+	c.Origin = dns.Fqdn(domain)
+
+	if c.RRs != nil || c.s == nil {
+		return
+	}
+
+	rrs, err := c.s.ZoneTransfer(domain)
+	if err != nil {
+		log.Debugf("Zone: %s", err)
+		return
+	}
+
+	c.RRs = rrs
+}
+
+func newZoneData(origin string, rrs []dns.RR) *zoneData {
+	z := &zoneData{
+		origin: strings.ToLower(dns.Fqdn(origin)),
+		rrsets: make(map[rrsetKey][]dns.RR),
+		sigs:   make(map[rrsetKey][]*dns.RRSIG),
+		types:  make(map[string][]uint16),
+		cuts:   make(map[string]bool),
+		keys:   make(map[uint16]*dns.DNSKEY),
+	}
+
+	for _, rr := range rrs {
+		name := strings.ToLower(rr.Header().Name)
+
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			z.sigs[rrsetKey{name, sig.TypeCovered}] = append(z.sigs[rrsetKey{name, sig.TypeCovered}], sig)
+			continue
+		}
+
+		k := rrsetKey{name, rr.Header().Rrtype}
+		if _, ok := z.rrsets[k]; !ok && k.qtype != dns.TypeNSEC3 {
+			z.types[name] = append(z.types[name], k.qtype)
+		}
+
+		z.rrsets[k] = append(z.rrsets[k], rr)
+
+		switch rr := rr.(type) {
+		case *dns.NS:
+			if name != z.origin {
+				z.cuts[name] = true
+			}
+		case *dns.DNSKEY:
+			if name == z.origin {
+				z.keys[rr.KeyTag()] = rr
+			}
+		}
+	}
+
+	return z
+}
+
+// occluded returns true if name is below a delegation point (glue or occluded data).
+func (z *zoneData) occluded(name string) bool {
+	for name != z.origin && name != "." {
+		name = getParentDomain(name)
+
+		if z.cuts[name] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authoritative returns true if the rrset is authoritative data that must be signed.
+func (z *zoneData) authoritative(k rrsetKey) bool {
+	if !dns.IsSubDomain(z.origin, k.name) || z.occluded(k.name) {
+		return false
+	}
+
+	if z.cuts[k.name] {
+		return k.qtype == dns.TypeDS || k.qtype == dns.TypeNSEC
+	}
+
+	return true
+}
+
+// authNames returns the owner names of authoritative data in canonical order.
+func (z *zoneData) authNames() []string {
+	var names []string
+
+	for name := range z.types {
+		if dns.IsSubDomain(z.origin, name) && !z.occluded(name) {
+			names = append(names, name)
+		}
+	}
+
+	sort.Slice(names, func(i, j int) bool { return canonicalLess(names[i], names[j]) })
+
+	return names
+}
+
+// canonicalLess compares two domain names in canonical DNS order (RFC 4034 6.1).
+func canonicalLess(a, b string) bool {
+	la := dns.SplitDomainName(strings.ToLower(a))
+	lb := dns.SplitDomainName(strings.ToLower(b))
+
+	for i := 1; i <= len(la) && i <= len(lb); i++ {
+		x, y := la[len(la)-i], lb[len(lb)-i]
+		if x != y {
+			return x < y
+		}
+	}
+
+	return len(la) < len(lb)
+}
+
+func listNames(names []string) string {
+	if len(names) > maxListed {
+		return fmt.Sprintf("%s and %d more", names[:maxListed], len(names)-maxListed)
+	}
+
+	return fmt.Sprintf("%s", names)
+}
+
+// verifySigs verifies every RRSIG in the zone and reports unsigned rrsets and
+// rrsets that are only signed by retired keys.
+func (z *zoneData) verifySigs() []ReportResult {
+	var (
+		results                  []ReportResult
+		unsigned, bogus, retired []string
+		valid                    int
+	)
+
+	if len(z.keys) == 0 {
+		return []ReportResult{{
+			Result: fmt.Sprintf("INFO: No DNSKEY found at %s. Zone is not signed.", z.origin),
+			Status: true, Name: "ZoneSigned",
+		}}
+	}
+
+	for k, rrset := range z.rrsets {
+		if !z.authoritative(k) {
+			continue
+		}
+
+		id := k.name + "/" + dns.TypeToString[k.qtype]
+
+		sigs := z.sigs[k]
+		if len(sigs) == 0 {
+			unsigned = append(unsigned, id)
+			continue
+		}
+
+		var ok, okRetired bool
+
+		for _, sig := range sigs {
+			// signed by a key that was removed from the DNSKEY set, it can't be
+			// verified anymore
+			key, found := z.keys[sig.KeyTag]
+			if !found {
+				okRetired = true
+				continue
+			}
+
+			if sig.Verify(key, rrset) != nil || !sig.ValidityPeriod(time.Now()) {
+				continue
+			}
+
+			if key.Flags&dns.REVOKE != 0 {
+				okRetired = true
+			} else {
+				ok = true
+			}
+		}
+
+		switch {
+		case ok:
+			valid++
+		case okRetired:
+			retired = append(retired, id)
+		default:
+			bogus = append(bogus, id)
+		}
+	}
+
+	sort.Strings(unsigned)
+	sort.Strings(bogus)
+	sort.Strings(retired)
+
+	if len(bogus) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: %d rrsets have no valid signature: %s", len(bogus), listNames(bogus)),
+			Status: false, Name: "ZoneBogus",
+		})
+	}
+
+	if len(unsigned) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: %d rrsets are not signed: %s", len(unsigned), listNames(unsigned)),
+			Status: false, Name: "ZoneUnsigned",
+		})
+	}
+
+	if len(retired) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("WARN: %d rrsets are only signed by retired or revoked keys: %s", len(retired), listNames(retired)),
+			Status: false, Name: "ZoneRetired",
+		})
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : All %d rrsets have a valid signature.", valid),
+			Status: true, Name: "ZoneSigned",
+		})
+	}
+
+	return results
+}
+
+// verifyNSEC verifies the NSEC or NSEC3 chain of the zone.
+func (z *zoneData) verifyNSEC() []ReportResult {
+	if len(z.keys) == 0 {
+		return []ReportResult{}
+	}
+
+	if _, ok := z.rrsets[rrsetKey{z.origin, dns.TypeNSEC3PARAM}]; ok {
+		return z.verifyNSEC3()
+	}
+
+	var (
+		results           []ReportResult
+		missing, bitmap   []string
+		broken            []string
+		owners            []string
+		next              = make(map[string]string)
+		nsecTypes         = make(map[string][]uint16)
+		authNames         = z.authNames()
+		nsecOwners, names = make(map[string]bool), make(map[string]bool)
+	)
+
+	for k, rrset := range z.rrsets {
+		if k.qtype != dns.TypeNSEC {
+			continue
+		}
+
+		nsec := rrset[0].(*dns.NSEC)
+		owners = append(owners, k.name)
+		nsecOwners[k.name] = true
+		next[k.name] = strings.ToLower(nsec.NextDomain)
+		nsecTypes[k.name] = nsec.TypeBitMap
+	}
+
+	if len(owners) == 0 {
+		return []ReportResult{{
+			Result: "FAIL: Zone is signed but has no NSEC or NSEC3 records.",
+			Status: false, Name: "NSEC",
+		}}
+	}
+
+	sort.Slice(owners, func(i, j int) bool { return canonicalLess(owners[i], owners[j]) })
+
+	for _, name := range authNames {
+		names[name] = true
+
+		if !nsecOwners[name] {
+			missing = append(missing, name)
+			continue
+		}
+
+		if !sameTypes(nsecTypes[name], z.types[name], len(z.sigs[rrsetKey{name, dns.TypeNSEC}]) > 0) {
+			bitmap = append(bitmap, name)
+		}
+	}
+
+	for i, owner := range owners {
+		want := owners[(i+1)%len(owners)]
+		if next[owner] != want {
+			broken = append(broken, fmt.Sprintf("%s -> %s (expected %s)", owner, next[owner], want))
+		}
+
+		if !names[owner] {
+			broken = append(broken, fmt.Sprintf("%s has no data", owner))
+		}
+	}
+
+	if len(missing) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: %d names have no NSEC record: %s", len(missing), listNames(missing)),
+			Status: false, Name: "NSEC",
+		})
+	}
+
+	if len(bitmap) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: NSEC type bitmap does not match the rrsets for: %s", listNames(bitmap)),
+			Status: false, Name: "NSECBitmap",
+		})
+	}
+
+	if len(broken) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: NSEC chain is broken or not in canonical order: %s", listNames(broken)),
+			Status: false, Name: "NSECChain",
+		})
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : NSEC chain is complete and ordered (%d records).", len(owners)),
+			Status: true, Name: "NSEC",
+		})
+	}
+
+	return results
+}
+
+// sameTypes compares an NSEC type bitmap with the types found in the zone.
+func sameTypes(bitmap, types []uint16, signed bool) bool {
+	m := make(map[uint16]bool)
+
+	for _, t := range types {
+		m[t] = true
+	}
+
+	if signed {
+		m[dns.TypeRRSIG] = true
+	}
+
+	if len(bitmap) != len(m) {
+		return false
+	}
+
+	for _, t := range bitmap {
+		if !m[t] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// signed returns true if name has an RRSIG over any of its rrsets.
+func (z *zoneData) signed(name string) bool {
+	for _, t := range z.types[name] {
+		if len(z.sigs[rrsetKey{name, t}]) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (z *zoneData) verifyNSEC3() []ReportResult {
+	var (
+		results                 []ReportResult
+		missing, broken, bitmap []string
+		nsec3                   []*dns.NSEC3
+		optOut                  bool
+	)
+
+	// NSEC3 records by the hash in their owner name
+	hashes := make(map[string]*dns.NSEC3)
+
+	for k, rrset := range z.rrsets {
+		if k.qtype == dns.TypeNSEC3 {
+			n := rrset[0].(*dns.NSEC3)
+			nsec3 = append(nsec3, n)
+			hashes[strings.ToUpper(dns.SplitDomainName(n.Hdr.Name)[0])] = n
+			optOut = optOut || n.Flags&1 == 1
+		}
+	}
+
+	if len(nsec3) == 0 {
+		return []ReportResult{{
+			Result: "FAIL: Zone has NSEC3PARAM but no NSEC3 records.",
+			Status: false, Name: "NSEC3",
+		}}
+	}
+
+	param := z.rrsets[rrsetKey{z.origin, dns.TypeNSEC3PARAM}][0].(*dns.NSEC3PARAM)
+
+	// names that need an NSEC3 record: authoritative names and empty non-terminals
+	need := make(map[string]bool)
+
+	for _, name := range z.authNames() {
+		need[name] = true
+
+		for parent := getParentDomain(name); parent != z.origin && dns.IsSubDomain(z.origin, parent); parent = getParentDomain(parent) {
+			need[parent] = true
+		}
+	}
+
+	for name := range need {
+		// insecure delegations can be skipped with opt-out
+		if optOut && z.cuts[name] && len(z.rrsets[rrsetKey{name, dns.TypeDS}]) == 0 {
+			continue
+		}
+
+		n, ok := hashes[dns.HashName(name, param.Hash, param.Iterations, param.Salt)]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+
+		if !sameTypes(n.TypeBitMap, z.types[name], z.signed(name)) {
+			bitmap = append(bitmap, name)
+		}
+	}
+
+	sort.Slice(nsec3, func(i, j int) bool {
+		return strings.ToLower(nsec3[i].Hdr.Name) < strings.ToLower(nsec3[j].Hdr.Name)
+	})
+
+	for i, n := range nsec3 {
+		want := strings.ToUpper(dns.SplitDomainName(nsec3[(i+1)%len(nsec3)].Hdr.Name)[0])
+		if strings.ToUpper(n.NextDomain) != want {
+			broken = append(broken, fmt.Sprintf("%s -> %s (expected %s)", n.Hdr.Name, n.NextDomain, want))
+		}
+	}
+
+	sort.Strings(missing)
+	sort.Strings(bitmap)
+
+	if len(missing) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: %d names have no NSEC3 record: %s", len(missing), listNames(missing)),
+			Status: false, Name: "NSEC3",
+		})
+	}
+
+	if len(bitmap) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: NSEC3 type bitmap does not match the rrsets for: %s", listNames(bitmap)),
+			Status: false, Name: "NSEC3Bitmap",
+		})
+	}
+
+	if len(broken) > 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("FAIL: NSEC3 chain is broken or not in hash order: %s", listNames(broken)),
+			Status: false, Name: "NSEC3Chain",
+		})
+	}
+
+	if len(results) == 0 {
+		results = append(results, ReportResult{
+			Result: fmt.Sprintf("OK  : NSEC3 chain is complete and ordered (%d records).", len(nsec3)),
+			Status: true, Name: "NSEC3",
+		})
+	}
+
+	return results
+}
+
+func (c *ZoneCheck) Values() []ReportResult {
+	if len(c.RRs) == 0 {
+		return []ReportResult{{
+			Result: "INFO: AXFR denied on all nameservers. Zone signatures not verified.",
+			Status: true, Name: "Zone",
+		}}
+	}
+
+	z := newZoneData(c.Origin, c.RRs)
+
+	results := []ReportResult{{
+		Result: fmt.Sprintf("INFO: Verifying %d records of %s", len(c.RRs), c.Origin),
+		Status: true, Name: "Zone",
+	}}
+
+	results = append(results, z.verifySigs()...)
+	results = append(results, z.verifyNSEC()...)
+
+	return results
+}
+
+func (c *ZoneCheck) CreateReport(domain string) Report {
+	c.Scan(domain)
+
+	c.Report.Type = "Zone"
+	c.Report.Result = append(c.Report.Result, c.Values()...)
+
+	return c.Report
+}
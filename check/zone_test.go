@@ -0,0 +1,347 @@
+package check
+
+import (
+	"crypto"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testKey is a zone signing key with its private key.
+type testKey struct {
+	*dns.DNSKEY
+	priv crypto.Signer
+}
+
+func newTestKey(t *testing.T, flags uint16) testKey {
+	t.Helper()
+
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 3600},
+		Flags:     flags,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+
+	priv, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testKey{key, priv.(crypto.Signer)}
+}
+
+func (k testKey) sign(t *testing.T, rrset []dns.RR) *dns.RRSIG {
+	t.Helper()
+
+	sig := &dns.RRSIG{
+		Hdr:        dns.RR_Header{Ttl: rrset[0].Header().Ttl},
+		Algorithm:  k.Algorithm,
+		SignerName: "example.com.",
+		KeyTag:     k.KeyTag(),
+		Inception:  uint32(time.Now().Add(-time.Hour).Unix()),
+		Expiration: uint32(time.Now().Add(time.Hour).Unix()),
+	}
+
+	if err := sig.Sign(k.priv, rrset); err != nil {
+		t.Fatal(err)
+	}
+
+	return sig
+}
+
+// testSignedZone returns example.com with an NSEC or NSEC3 chain, signed with the
+// key returned by signer. The DNSKEY rrset has keys.
+func testSignedZone(t *testing.T, nsec3 bool, keys []testKey, signer func(rrsetKey) testKey) []dns.RR {
+	t.Helper()
+
+	var rrs []dns.RR
+
+	for _, s := range []string{
+		"example.com. 3600 IN SOA ns1.example.com. hostmaster.example.com. 1 7200 900 1209600 3600",
+		"example.com. 3600 IN NS ns1.example.com.",
+		"ns1.example.com. 3600 IN A 192.0.2.1",
+		"www.a.example.com. 3600 IN A 192.0.2.80",
+		"sub.example.com. 3600 IN NS ns.example.net.",
+	} {
+		rr, err := dns.NewRR(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rrs = append(rrs, rr)
+	}
+
+	for _, k := range keys {
+		rrs = append(rrs, k.DNSKEY)
+	}
+
+	// types of the names, without NSEC and RRSIG
+	types := map[string][]uint16{
+		"example.com.":       {dns.TypeSOA, dns.TypeNS, dns.TypeDNSKEY},
+		"ns1.example.com.":   {dns.TypeA},
+		"www.a.example.com.": {dns.TypeA},
+		"sub.example.com.":   {dns.TypeNS},
+	}
+
+	// in canonical order
+	names := []string{"example.com.", "www.a.example.com.", "ns1.example.com.", "sub.example.com."}
+
+	if !nsec3 {
+		for i, name := range names {
+			rrs = append(rrs, &dns.NSEC{
+				Hdr:        dns.RR_Header{Name: name, Rrtype: dns.TypeNSEC, Class: dns.ClassINET, Ttl: 3600},
+				NextDomain: names[(i+1)%len(names)],
+				TypeBitMap: sortedTypes(types[name], dns.TypeNSEC, dns.TypeRRSIG),
+			})
+		}
+	} else {
+		param := &dns.NSEC3PARAM{
+			Hdr:  dns.RR_Header{Name: "example.com.", Rrtype: dns.TypeNSEC3PARAM, Class: dns.ClassINET},
+			Hash: dns.SHA1, Iterations: 0, Salt: "",
+		}
+		types["example.com."] = append(types["example.com."], dns.TypeNSEC3PARAM)
+		// the empty non-terminal has an NSEC3 record too
+		names = append(names, "a.example.com.")
+
+		hashes := make(map[string]string)
+		for _, name := range names {
+			hashes[dns.HashName(name, dns.SHA1, 0, "")] = name
+		}
+
+		sorted := make([]string, 0, len(hashes))
+		for h := range hashes {
+			sorted = append(sorted, h)
+		}
+
+		sort.Strings(sorted)
+
+		for i, h := range sorted {
+			var bitmap []uint16
+
+			switch name := hashes[h]; {
+			case name == "sub.example.com.":
+				// unsigned delegation
+				bitmap = []uint16{dns.TypeNS}
+			case types[name] != nil:
+				bitmap = sortedTypes(types[name], dns.TypeRRSIG)
+			}
+
+			rrs = append(rrs, &dns.NSEC3{
+				Hdr:        dns.RR_Header{Name: strings.ToLower(h) + ".example.com.", Rrtype: dns.TypeNSEC3, Class: dns.ClassINET, Ttl: 3600},
+				Hash:       dns.SHA1,
+				Salt:       "",
+				SaltLength: 0,
+				HashLength: 20,
+				NextDomain: sorted[(i+1)%len(sorted)],
+				TypeBitMap: bitmap,
+			})
+		}
+
+		rrs = append(rrs, param)
+	}
+
+	z := newZoneData("example.com.", rrs)
+
+	for k, rrset := range z.rrsets {
+		if z.authoritative(k) {
+			rrs = append(rrs, signer(k).sign(t, rrset))
+		}
+	}
+
+	return rrs
+}
+
+// sortedTypes returns types and extra in the order of an NSEC type bitmap.
+func sortedTypes(types []uint16, extra ...uint16) []uint16 {
+	out := append(append([]uint16{}, types...), extra...)
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+
+	return out
+}
+
+// withoutSigs returns rrs without the RRSIGs over the rrset of owner and qtype.
+func withoutSigs(rrs []dns.RR, owner string, qtype uint16) []dns.RR {
+	var out []dns.RR
+
+	for _, rr := range rrs {
+		if sig, ok := rr.(*dns.RRSIG); ok && sig.Hdr.Name == owner && sig.TypeCovered == qtype {
+			continue
+		}
+
+		out = append(out, rr)
+	}
+
+	return out
+}
+
+// withoutRR returns rrs without the records of owner and qtype, and the RRSIGs over them.
+func withoutRR(rrs []dns.RR, owner string, qtype uint16) []dns.RR {
+	var out []dns.RR
+
+	for _, rr := range rrs {
+		t := rr.Header().Rrtype
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			t = sig.TypeCovered
+		}
+
+		if rr.Header().Name == owner && t == qtype {
+			continue
+		}
+
+		out = append(out, rr)
+	}
+
+	return out
+}
+
+func TestVerifyZone(t *testing.T) {
+	ksk := newTestKey(t, 257)
+	revoked := newTestKey(t, 257|dns.REVOKE)
+	removed := newTestKey(t, 257)
+
+	byKSK := func(rrsetKey) testKey { return ksk }
+
+	// www.a.example.com A is only signed by key
+	onlyWWW := func(key testKey) func(rrsetKey) testKey {
+		return func(k rrsetKey) testKey {
+			if k.name == "www.a.example.com." && k.qtype == dns.TypeA {
+				return key
+			}
+
+			return ksk
+		}
+	}
+
+	tests := []struct {
+		name   string
+		rrs    []dns.RR
+		mutate func([]dns.RR) []dns.RR
+		want   []string
+	}{
+		{
+			name: "NSEC",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, byKSK),
+			want: []string{"OK  : All 9 rrsets have a valid signature.", "OK  : NSEC chain is complete and ordered (4 records)."},
+		},
+		{
+			name: "NSEC3",
+			rrs:  testSignedZone(t, true, []testKey{ksk}, byKSK),
+			want: []string{"OK  : All 11 rrsets have a valid signature.", "OK  : NSEC3 chain is complete and ordered (5 records)."},
+		},
+		{
+			name: "unsigned rrset",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				return withoutSigs(rrs, "ns1.example.com.", dns.TypeA)
+			},
+			want: []string{"FAIL: 1 rrsets are not signed: [ns1.example.com./A]"},
+		},
+		{
+			name: "bogus rrset",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				rrs[2].(*dns.A).A = []byte{192, 0, 2, 2}
+				return rrs
+			},
+			want: []string{"FAIL: 1 rrsets have no valid signature: [ns1.example.com./A]"},
+		},
+		{
+			name: "signed by a revoked key",
+			rrs:  testSignedZone(t, false, []testKey{ksk, revoked}, onlyWWW(revoked)),
+			want: []string{"WARN: 1 rrsets are only signed by retired or revoked keys: [www.a.example.com./A]"},
+		},
+		{
+			name: "signed by a removed key",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, onlyWWW(removed)),
+			want: []string{"WARN: 1 rrsets are only signed by retired or revoked keys: [www.a.example.com./A]"},
+		},
+		{
+			name: "missing NSEC",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				return withoutRR(rrs, "sub.example.com.", dns.TypeNSEC)
+			},
+			want: []string{"FAIL: 1 names have no NSEC record: [sub.example.com.]", "FAIL: NSEC chain is broken"},
+		},
+		{
+			name: "NSEC out of order",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				for _, rr := range rrs {
+					if nsec, ok := rr.(*dns.NSEC); ok && nsec.Hdr.Name == "ns1.example.com." {
+						nsec.NextDomain = "www.a.example.com."
+					}
+				}
+
+				return rrs
+			},
+			want: []string{"FAIL: NSEC chain is broken or not in canonical order: [ns1.example.com. -> www.a.example.com. (expected sub.example.com.)]"},
+		},
+		{
+			name: "NSEC bitmap",
+			rrs:  testSignedZone(t, false, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				for _, rr := range rrs {
+					if nsec, ok := rr.(*dns.NSEC); ok && nsec.Hdr.Name == "ns1.example.com." {
+						nsec.TypeBitMap = append(nsec.TypeBitMap, dns.TypeAAAA)
+					}
+				}
+
+				return rrs
+			},
+			want: []string{"FAIL: NSEC type bitmap does not match the rrsets for: [ns1.example.com.]"},
+		},
+		{
+			name: "missing NSEC3 for an empty non-terminal",
+			rrs:  testSignedZone(t, true, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				owner := strings.ToLower(dns.HashName("a.example.com.", dns.SHA1, 0, "")) + ".example.com."
+				return withoutRR(rrs, owner, dns.TypeNSEC3)
+			},
+			want: []string{"FAIL: 1 names have no NSEC3 record: [a.example.com.]", "FAIL: NSEC3 chain is broken"},
+		},
+		{
+			name: "NSEC3 chain",
+			rrs:  testSignedZone(t, true, []testKey{ksk}, byKSK),
+			mutate: func(rrs []dns.RR) []dns.RR {
+				for _, rr := range rrs {
+					if n, ok := rr.(*dns.NSEC3); ok {
+						n.NextDomain = strings.ToUpper(dns.SplitDomainName(n.Hdr.Name)[0])
+						break
+					}
+				}
+
+				return rrs
+			},
+			want: []string{"FAIL: NSEC3 chain is broken or not in hash order"},
+		},
+	}
+
+	for _, tt := range tests {
+		rrs := tt.rrs
+		if tt.mutate != nil {
+			rrs = tt.mutate(rrs)
+		}
+
+		z := newZoneData("example.com.", rrs)
+		results := append(z.verifySigs(), z.verifyNSEC()...)
+
+		for _, want := range tt.want {
+			found := false
+
+			for _, res := range results {
+				if strings.HasPrefix(res.Result, want) {
+					found = true
+				}
+			}
+
+			if !found {
+				t.Errorf("%s: no result %q in %v", tt.name, want, results)
+			}
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
@@ -19,7 +20,9 @@ import (
 
 var (
 	flagScan, flagDebug, flagShowFail, flagJSON *bool
+	flagZoneVerify, flagSMTP, flagDNSBLSPF      *bool
 	flagQPS                                     *int
+	flagDKIM, flagDNSBL, flagSOATimers          *string
 	log                                         = logrus.New()
 	IPv6Guess                                   bool
 )
@@ -27,11 +30,23 @@ var (
 func printHelp() {
 	fmt.Println("Usage:")
 	fmt.Println("\tdt [FLAGS] domain")
+	fmt.Println("\tdt [FLAGS] rollover domain")
+	fmt.Println("\tdt [FLAGS] graph domain")
+	fmt.Println("\tdt [FLAGS] lint zonefile [origin]")
+	fmt.Println("\tdt [FLAGS] spf-test domain ip [helo] [sender]")
+	fmt.Println("\tdt [FLAGS] watch domain [interval] [timeout]")
+	fmt.Println("\tdt [FLAGS] trace domain [type]")
 	fmt.Println()
 	fmt.Println("Example:")
 	fmt.Println("\tdt icann.org")
 	fmt.Println("\tdt -debug ripe.net")
 	fmt.Println("\tdt -debug -scan yourdomain.com")
+	fmt.Println("\tdt rollover ripe.net")
+	fmt.Println("\tdt graph ripe.net | dot -Tsvg > ripe.svg")
+	fmt.Println("\tdt lint db.example.com example.com")
+	fmt.Println("\tdt spf-test example.com 203.0.113.5 mail.example.com user@example.com")
+	fmt.Println("\tdt watch example.com 5s 30m")
+	fmt.Println("\tdt trace www.example.com AAAA")
 	fmt.Println()
 	fmt.Println("Flags:")
 	flag.PrintDefaults()
@@ -45,6 +60,12 @@ func initScan() *scan.Scan {
 	flagQPS = flag.Int("qps", 10, "queries per seconds (per nameserver)")
 	flagShowFail = flag.Bool("showfail", false, "only show checks that fail or warn")
 	flagJSON = flag.Bool("json", false, "output in JSON")
+	flagZoneVerify = flag.Bool("zoneverify", false, "verify DNSSEC signatures and NSEC chain of the zone if AXFR is allowed")
+	flagSMTP = flag.Bool("smtp", false, "connect to the MX servers on port 25 to probe them and verify their certificates against DANE")
+	flagDKIM = flag.String("dkim", "", "comma separated list of extra DKIM selectors to check")
+	flagDNSBL = flag.String("dnsbl", "", "comma separated list of DNSBL zones to check the MX ips against (default built-in list)")
+	flagDNSBLSPF = flag.Bool("dnsblspf", false, "also check the ips authorized by SPF against the DNSBL zones")
+	flagSOATimers = flag.String("soatimers", "", "SOA timer limits, e.g. refresh=1200-86400,retry=120-7200,expire=604800-3600000,minimum=300-86400,ratio=7")
 	flag.StringVar(&resolver, "resolver", "8.8.8.8", "use this resolver for initial domain lookup")
 	flag.Parse()
 
@@ -57,7 +78,16 @@ func initScan() *scan.Scan {
 		log.Level = logrus.DebugLevel
 	}
 
-	if !*flagJSON {
+	timers, err := check.ParseSOATimers(check.SOATimerLimits, *flagSOATimers)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	check.SOATimerLimits = timers
+
+	// graph output is meant to be piped into dot, lint doesn't use the network
+	if !*flagJSON && flag.Arg(0) != "graph" && flag.Arg(0) != "lint" {
 		fmt.Printf("using %s as resolver\n", resolver)
 	}
 
@@ -74,6 +104,29 @@ func main() {
 	s := initScan()
 	domain := flag.Arg(0)
 
+	if len(flag.Args()) > 1 {
+		switch flag.Arg(0) {
+		case "rollover":
+			doRollover(s, flag.Arg(1))
+			return
+		case "graph":
+			doGraph(s, flag.Arg(1))
+			return
+		case "lint":
+			doLint(flag.Arg(1), flag.Arg(2))
+			return
+		case "spf-test":
+			doSPFTest(s, flag.Arg(1), flag.Arg(2), flag.Arg(3), flag.Arg(4))
+			return
+		case "watch":
+			doWatch(s, flag.Arg(1), flag.Arg(2), flag.Arg(3))
+			return
+		case "trace":
+			doTrace(s, flag.Arg(1), flag.Arg(2))
+			return
+		}
+	}
+
 	nsdatas, err := s.FindNS(dns.Fqdn(domain))
 	if len(nsdatas) == 0 {
 		fmt.Println("no nameservers found for", domain)
@@ -92,14 +145,51 @@ func main() {
 }
 
 func execCheckers(s *scan.Scan, domain string, nsdatas []structs.NSData, domainReport *check.DomainReport) {
+	parent := check.NewDelegationScan(s)
+
+	ns := check.NewNS(s, nsdatas)
+	ns.ParentScan = parent
+
+	lame := check.NewLame(s, nsdatas)
+	lame.ParentScan = parent
+
+	glue := check.NewGlue(s, nsdatas)
+	glue.ParentScan = parent
+
+	ttl := check.NewTTL(s, nsdatas)
+	ttl.ParentScan = parent
+
+	mx := check.NewMX(s, nsdatas)
+	mx.Probe = *flagSMTP
+	mx.Scan(domain)
+
+	dane := check.NewDANE(s, nsdatas, *flagSMTP)
+	dane.MXScan = mx
+
+	spam := check.NewSpam(s, nsdatas)
+	spam.MXScan = mx
+
+	dnsbl := check.NewDNSBL(s, nsdatas, *flagDNSBLSPF, strings.Split(*flagDNSBL, ",")...)
+	dnsbl.MXScan = mx
+
 	checkers := []check.Checker{
-		check.NewNS(s, nsdatas),
-		check.NewGlue(s, nsdatas),
+		ns,
+		lame,
+		glue,
 		check.NewSOA(s, nsdatas),
-		check.NewMX(s, nsdatas),
+		mx,
+		dane,
 		check.NewWeb(s, nsdatas),
-		check.NewSpam(s, nsdatas),
+		spam,
+		check.NewDKIM(s, nsdatas, strings.Split(*flagDKIM, ",")...),
+		dnsbl,
 		check.NewDNSSEC(s, nsdatas),
+		check.NewCDS(s, nsdatas),
+		ttl,
+	}
+
+	if *flagZoneVerify {
+		checkers = append(checkers, check.NewZone(s, nsdatas))
 	}
 
 	// TODO concurrency
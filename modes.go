@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/42wim/dt/check"
+	"github.com/42wim/dt/scan"
+	"github.com/miekg/dns"
+)
+
+func printJSON(v interface{}) {
+	res, err := json.Marshal(v)
+	if err != nil {
+		fmt.Printf("encoding failed: %v\n", err)
+	}
+
+	fmt.Println(string(res))
+}
+
+func printReport(report check.Report, flagShowFail bool) {
+	printDomainReport(&check.DomainReport{Report: []check.Report{report}}, flagShowFail)
+}
+
+func doRollover(s *scan.Scan, domain string) {
+	nsdatas, err := s.FindNS(dns.Fqdn(domain))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	c := check.NewRollover(s, nsdatas)
+	report := c.CreateReport(dns.Fqdn(domain))
+
+	if *flagJSON {
+		printJSON(c)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Keytag\tAlg\tRole\tState\tDS\tSigns DNSKEY\tSigns zone\n")
+
+	for _, ks := range c.Keys {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%v\t%v\t%v\n", ks.KeyTag, dns.AlgorithmToString[ks.Algorithm], ks.Role, ks.State, ks.DS, ks.SignsKeys, ks.SignsZone)
+	}
+
+	w.Flush()
+
+	fmt.Printf("\nTTL: DNSKEY %ds, DS %ds, RRSIG %ds\n", c.DNSKEYTTL, c.DSTTL, c.SigTTL)
+
+	printReport(report, *flagShowFail)
+}
+
+// doWatch waits until the serials of all nameservers of domain converge and shows
+// how long every server took.
+func doWatch(s *scan.Scan, domain, interval, timeout string) {
+	durations := []time.Duration{10 * time.Second, time.Hour}
+
+	for i, arg := range []string{interval, timeout} {
+		if arg == "" {
+			continue
+		}
+
+		d, err := time.ParseDuration(arg)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		durations[i] = d
+	}
+
+	nsdatas, err := s.FindNS(dns.Fqdn(domain))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	c := check.NewSOA(s, nsdatas)
+
+	if !*flagJSON {
+		fmt.Printf("watching serials of %s every %s for %s\n", domain, durations[0], durations[1])
+	}
+
+	w := c.Watch(dns.Fqdn(domain), durations[0], durations[1], func(server string, serial uint32, lag time.Duration) {
+		if !*flagJSON {
+			fmt.Printf("%s %s has serial %d after %s\n", time.Now().Format("15:04:05"), server, serial, lag.Round(time.Second))
+		}
+	})
+
+	if *flagJSON {
+		printJSON(w)
+		return
+	}
+
+	for _, server := range w.Rollback {
+		fmt.Printf("%s rolled back its serial\n", server)
+	}
+
+	if !w.Done {
+		fmt.Printf("serials didn't converge on %d within %s\n", w.Serial, durations[1])
+		os.Exit(1)
+	}
+
+	var lags []time.Duration
+	for _, lag := range w.Converged {
+		lags = append(lags, lag)
+	}
+
+	sort.Slice(lags, func(i, j int) bool { return lags[i] < lags[j] })
+
+	fmt.Printf("all %d servers converged on serial %d in %s\n", len(lags), w.Serial, lags[len(lags)-1].Round(time.Second))
+}
+
+func doGraph(s *scan.Scan, domain string) {
+	g := s.ChainGraph(domain)
+
+	if *flagJSON {
+		printJSON(g)
+		return
+	}
+
+	fmt.Print(g.DOT())
+}
+
+// doTrace shows every referral from the root to the answer for domain.
+func doTrace(s *scan.Scan, domain, qtype string) {
+	t := dns.TypeA
+
+	if qtype != "" {
+		var ok bool
+
+		t, ok = dns.StringToType[strings.ToUpper(qtype)]
+		if !ok {
+			fmt.Printf("unknown type %s\n", qtype)
+			os.Exit(1)
+		}
+	}
+
+	trace := s.Trace(domain, t)
+
+	if *flagJSON {
+		printJSON(trace)
+		return
+	}
+
+	for _, hop := range trace.Hops {
+		fmt.Printf("\n%s (DNSSEC: %s)\n", hop.Zone, hop.DNSSEC)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', tabwriter.Debug)
+
+		for _, srv := range hop.Servers {
+			ip := srv.IP
+			if srv.Glue {
+				ip += " (glue)"
+			}
+
+			fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", srv.Name, ip, srv.Rtt.Round(time.Millisecond), traceResult(srv))
+		}
+
+		w.Flush()
+
+		for _, err := range hop.Errors {
+			fmt.Printf("  ! %s\n", err)
+		}
+
+		for _, diff := range hop.Diff {
+			fmt.Printf("  ! %s\n", diff)
+		}
+
+		if hop.Referral != "" {
+			ds := "no DS"
+			if hop.DS {
+				ds = "DS"
+			}
+
+			fmt.Printf("  -> %s (%s)\n", hop.Referral, ds)
+		}
+	}
+
+	if len(trace.Hops) == 0 {
+		return
+	}
+
+	for _, srv := range trace.Hops[len(trace.Hops)-1].Servers {
+		if len(srv.Answer) > 0 {
+			fmt.Printf("\nAnswer from %s (%s):\n", srv.Name, srv.IP)
+
+			for _, rr := range srv.Answer {
+				fmt.Printf("  %s\n", rr)
+			}
+
+			return
+		}
+	}
+}
+
+// traceResult summarizes the answer of a trace server in one line.
+func traceResult(srv scan.TraceServer) string {
+	aa := ""
+	if srv.Authoritative {
+		aa = " aa"
+	}
+
+	switch {
+	case srv.Error != "":
+		return srv.Error
+	case srv.Referral != "":
+		return fmt.Sprintf("%s%s, referral to %s: %d NS, %d glue, DS %v", srv.Rcode, aa, srv.Referral, len(srv.NS), len(srv.GlueRR), srv.DS)
+	default:
+		return fmt.Sprintf("%s%s, %d answer records", srv.Rcode, aa, len(srv.Answer))
+	}
+}
+
+func doLint(file, origin string) {
+	f, err := os.Open(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	defer f.Close()
+
+	rrs, err := check.ParseZone(f, origin, file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	domainReport := check.Lint(origin, rrs)
+
+	if *flagJSON {
+		printJSON(domainReport)
+		return
+	}
+
+	printDomainReport(domainReport, *flagShowFail)
+}
+
+func doSPFTest(s *scan.Scan, domain, ip, helo, sender string) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		fmt.Println("invalid ip address", ip)
+		os.Exit(1)
+	}
+
+	if helo == "" {
+		helo = domain
+	}
+
+	if sender == "" {
+		sender = "postmaster@" + domain
+	}
+
+	res := check.NewSPF(s).CheckHost(addr, domain, sender, helo)
+
+	if *flagJSON {
+		printJSON(res)
+		return
+	}
+
+	fmt.Printf("\nResult: %s\n", res.Result)
+
+	if res.Mechanism != "" {
+		fmt.Printf("Matched: %s (in %s)\n", res.Mechanism, res.Domain)
+	}
+
+	if res.Explanation != "" {
+		fmt.Printf("Explanation: %s\n", res.Explanation)
+	}
+
+	if res.Error != "" {
+		fmt.Printf("Error: %s (in %s)\n", res.Error, res.Domain)
+	}
+
+	fmt.Printf("\nDNS queries (%d):\n", len(res.Queries))
+
+	for _, q := range res.Queries {
+		fmt.Printf("\t%s\n", q)
+	}
+}
@@ -41,8 +41,7 @@ func outputter(wc chan structs.NSInfo, done chan struct{}) {
 			if ns.Rtt == 0 {
 				failed = true
 			}
-			// TODO output somewhere else
-			// LAME servers
+			// lame servers, the Lame check has the details
 			auth := ""
 
 			if ns.Msg != nil && !ns.Msg.Authoritative {
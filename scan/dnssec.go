@@ -1,7 +1,11 @@
 package scan
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/42wim/dt/structs"
@@ -86,30 +90,119 @@ func explicitValid(rr *dns.RRSIG) (int64, int64) {
 	return ti, te
 }
 
+// ChainResult is the DNSSEC validation result of a zone on a single server.
+type ChainResult struct {
+	Zone        string
+	Name        string
+	IP          string
+	Type        string // DNSKEY (RRSIG on the DNSKEY rrset) or DS (DS at the parent)
+	Valid       bool
+	Unreachable bool // the server didn't answer, this says nothing about the chain
+	Error       string
+}
+
+// chainEntry is the cached chain of a domain, validated once.
+type chainEntry struct {
+	once    sync.Once
+	results []ChainResult
+}
+
+// unreachable returns true if err is a transport error: timeouts, refused
+// connections and unreachable networks.
+func unreachable(err error) bool {
+	var netErr net.Error
+
+	return errors.As(err, &netErr)
+}
+
 func (s *Scan) ValidateChain(domain string) (bool, error) {
+	return chainState(s.validateChain(domain))
+}
+
+// ValidateChainResults returns the validation results of every server in the chain of domain.
+func (s *Scan) ValidateChainResults(domain string) []ChainResult {
 	return s.validateChain(domain)
 }
 
-func (s *Scan) validateChain(domain string) (bool, error) {
-	for {
-		log.Debugf("Validating %s", domain)
+// chainState returns false and the first error found in results. Unreachable
+// servers are skipped, but every zone needs a server that answered.
+func chainState(results []ChainResult) (bool, error) {
+	answered := make(map[string]bool)
+
+	for _, res := range results {
+		if res.Unreachable {
+			if _, ok := answered[res.Zone+" "+res.Type]; !ok {
+				answered[res.Zone+" "+res.Type] = false
+			}
 
-		valid, err := s.validateDomain(domain)
-		if err != nil {
-			return false, err
+			continue
 		}
 
-		if !valid {
-			return false, fmt.Errorf("validateChain failed. Run with -debug for more information")
+		if !res.Valid {
+			return false, fmt.Errorf("validation failed. %s", res.Error)
 		}
 
-		parent := getParentDomain(domain)
-		if parent == "." {
-			return true, nil
+		answered[res.Zone+" "+res.Type] = true
+	}
+
+	for k, ok := range answered {
+		if !ok {
+			return false, fmt.Errorf("validation failed. No nameserver answered for %s", k)
 		}
+	}
 
-		domain = parent
+	if len(results) == 0 {
+		return false, fmt.Errorf("validateChain failed. Run with -debug or use graph for more information")
 	}
+
+	return true, nil
+}
+
+// chainValid returns true if the chain of domain validates for the nameserver ip.
+// Failures of other nameservers of domain itself are ignored.
+func (s *Scan) chainValid(domain string, ip net.IP) bool {
+	results := s.validateChain(domain)
+
+	var others []ChainResult
+
+	for _, res := range results {
+		if res.Zone == dns.Fqdn(domain) && res.Type == "DNSKEY" && res.IP != ip.String() {
+			continue
+		}
+
+		others = append(others, res)
+	}
+
+	valid, _ := chainState(others)
+
+	return valid
+}
+
+// validateChain validates domain and all its parents up to the root on every server.
+// The results are cached per domain, concurrent callers for the same domain wait for
+// the first one.
+func (s *Scan) validateChain(domain string) []ChainResult {
+	domain = dns.Fqdn(domain)
+
+	s.chainMu.Lock()
+
+	entry, ok := s.chainCache[domain]
+	if !ok {
+		entry = &chainEntry{}
+		s.chainCache[domain] = entry
+	}
+
+	s.chainMu.Unlock()
+
+	entry.once.Do(func() {
+		for zone := domain; zone != "."; zone = getParentDomain(zone) {
+			log.Debugf("Validating %s", zone)
+
+			entry.results = append(entry.results, s.validateDomain(zone)...)
+		}
+	})
+
+	return entry.results
 }
 
 func (s *Scan) LookupDNSKEY(domain string, nsip string, keyMap map[uint16]*dns.DNSKEY) (structs.Response, error) {
@@ -119,7 +212,7 @@ func (s *Scan) LookupDNSKEY(domain string, nsip string, keyMap map[uint16]*dns.D
 	if err != nil {
 		log.Debugf("error %s", err)
 
-		return res, nil
+		return res, err
 	}
 	// map DNSKEYs
 	for _, a := range res.Msg.Answer {
@@ -144,65 +237,93 @@ func (s *Scan) LookupDNSKEY(domain string, nsip string, keyMap map[uint16]*dns.D
 	return res, nil
 }
 
-func (s *Scan) validateParentDS(domain string, keyMap map[uint16]*dns.DNSKEY) (bool, error) {
+func (s *Scan) ValidateParentDS(domain string, keyMap map[uint16]*dns.DNSKEY) (bool, error) {
+	return chainState(s.validateParentDS(domain, keyMap))
+}
+
+// validateParentDS asks every parent nameserver for the DS records of domain and
+// compares them with the DNSKEYs in keyMap.
+func (s *Scan) validateParentDS(domain string, keyMap map[uint16]*dns.DNSKEY) []ChainResult {
 	// get auth servers of parent
 	log.Debugf("Finding NS of parent: %s", dns.Fqdn(getParentDomain(domain)))
 
+	var results []ChainResult
+
 	nsdata, err := s.FindNS(getParentDomain(domain))
 	if err != nil {
 		log.Debugf("ValidateDomain() error: %#v", err)
+
+		return []ChainResult{{Zone: dns.Fqdn(domain), Type: "DS", Error: fmt.Sprintf("no NS found for %s", getParentDomain(domain))}}
 	}
 
 	// asking parent about DS
-	foundKeyTag := false
-
 	for _, ns := range nsdata {
-		for _, nsip := range ns.IP {
+		for _, nsip := range s.UsableIPs(ns.IP) {
 			log.Debugf("Asking parent %s (%s) DS of %s", ns.Name, nsip.String(), domain)
 
-			res, err := query(domain, dns.TypeDS, nsip.String(), true)
-			if err == nil && len(res.Msg.Answer) == 0 {
-				return false, fmt.Errorf("validation failed. No DS records found for %s on %v", domain, nsip.String())
+			results = append(results, s.validateServerDS(domain, ns.Name, nsip.String(), keyMap))
+		}
+	}
+
+	return results
+}
+
+func (s *Scan) validateServerDS(domain, name, nsip string, keyMap map[uint16]*dns.DNSKEY) ChainResult {
+	result := ChainResult{Zone: dns.Fqdn(domain), Name: name, IP: nsip, Type: "DS"}
+
+	res, err := query(domain, dns.TypeDS, nsip, true)
+	if err != nil {
+		log.Debugf("error %s", err)
+
+		result.Error = fmt.Sprintf("DS query for %s failed on %s: %s", domain, nsip, err)
+		result.Unreachable = unreachable(err)
+
+		return result
+	}
+
+	if len(extractRR(res.Msg.Answer, dns.TypeDS)) == 0 {
+		result.Error = fmt.Sprintf("No DS records found for %s on %v", domain, nsip)
+
+		return result
+	}
+
+	foundKeyTag := false
+
+	// look for all parent DS and compare digests
+	for _, a := range res.Msg.Answer {
+		switch parentDS := a.(type) {
+		case *dns.DS:
+			// does the child has a DNSKEY with the found KeyTag ?
+			key := keyMap[parentDS.KeyTag]
+			if key == nil {
+				log.Debugf("No DNSKEY (keytag %v) in %s found that matches DS (keytag %v) in %s", parentDS.KeyTag, domain, parentDS.KeyTag, nsip)
+				continue
 			}
 
-			if err != nil {
-				log.Debugf("error %s", err)
-				break
+			if parentDS.DigestType == 3 {
+				// no support for GOST for now
+				continue
 			}
-			// look for all parent DS and compare digests
-			for _, a := range res.Msg.Answer {
-				switch parentDS := a.(type) {
-				case *dns.DS:
-					// does the child has a DNSKEY with the found KeyTag ?
-					key := keyMap[parentDS.KeyTag]
-					if key == nil {
-						log.Debugf("No DNSKEY (keytag %v) in %s found that matches DS (keytag %v) in %s", parentDS.KeyTag, domain, parentDS.KeyTag, nsip.String())
-						continue
-					}
-
-					if parentDS.DigestType == 3 {
-						// no support for GOST for now
-						break
-					}
-
-					foundKeyTag = true
-					// create the child digest based on the parentDS digesttype
-					childDS := key.ToDS(parentDS.DigestType)
-					// if this doesn't fail (shouldn't be happening?)
-					if childDS != nil {
-						log.Debugf("parent DS digest: %s (keytag %v, type %v)", parentDS.Digest, parentDS.KeyTag, parentDS.DigestType)
-						log.Debugf("child DS digest %s (keytag %v, type %v)", childDS.Digest, childDS.KeyTag, childDS.DigestType)
-
-						if parentDS.Digest == childDS.Digest {
-							log.Debugf("%s validated", domain)
-						} else {
-							log.Debugf("%s failure", domain)
-							return false, nil
-						}
-					} else {
-						log.Debugf("childDS is nil ? shouldn't be happening %v %v %v", parentDS.KeyTag, key.PublicKey, parentDS.DigestType)
-					}
+
+			foundKeyTag = true
+			// create the child digest based on the parentDS digesttype
+			childDS := key.ToDS(parentDS.DigestType)
+			// if this doesn't fail (shouldn't be happening?)
+			if childDS != nil {
+				log.Debugf("parent DS digest: %s (keytag %v, type %v)", parentDS.Digest, parentDS.KeyTag, parentDS.DigestType)
+				log.Debugf("child DS digest %s (keytag %v, type %v)", childDS.Digest, childDS.KeyTag, childDS.DigestType)
+
+				if strings.EqualFold(parentDS.Digest, childDS.Digest) {
+					log.Debugf("%s validated", domain)
+				} else {
+					log.Debugf("%s failure", domain)
+
+					result.Error = fmt.Sprintf("DS digest of keytag %v for %s on %s does not match the DNSKEY", parentDS.KeyTag, domain, nsip)
+
+					return result
 				}
+			} else {
+				log.Debugf("childDS is nil ? shouldn't be happening %v %v %v", parentDS.KeyTag, key.PublicKey, parentDS.DigestType)
 			}
 		}
 	}
@@ -210,17 +331,23 @@ func (s *Scan) validateParentDS(domain string, keyMap map[uint16]*dns.DNSKEY) (b
 	if !foundKeyTag {
 		log.Debugf("Validation failed. No DNSKEY in %s found that matches DS in %s", domain, getParentDomain(domain))
 
-		return false, fmt.Errorf("validation failed. No DNSKEY in %s found that matches DS in %s", domain, getParentDomain(domain))
+		result.Error = fmt.Sprintf("No DNSKEY in %s found that matches DS in %s on %s", domain, getParentDomain(domain), nsip)
+
+		return result
 	}
 
-	return true, nil
+	result.Valid = true
+
+	return result
 }
 
 func (s *Scan) ValidateDomain(domain string) (bool, error) {
-	return s.validateDomain(domain)
+	return chainState(s.validateDomain(domain))
 }
 
-func (s *Scan) validateDomain(domain string) (bool, error) {
+// validateDomain validates the DNSKEY rrset of domain on every nameserver and
+// the DS records on every parent nameserver.
+func (s *Scan) validateDomain(domain string) []ChainResult {
 	// TODO concurrency
 	// get DNSKEY domain.
 	// validate RRSIG on DNSKEY
@@ -229,6 +356,8 @@ func (s *Scan) validateDomain(domain string) (bool, error) {
 	// compare digest (parent) with child (RRSig digest)
 	keyMap := make(map[uint16]*dns.DNSKEY)
 
+	var results []ChainResult
+
 	// get auth servers
 	nsdata, err := s.FindNS(domain)
 	if err != nil {
@@ -236,39 +365,38 @@ func (s *Scan) validateDomain(domain string) (bool, error) {
 	}
 
 	for _, ns := range nsdata {
-		for _, nsip := range ns.IP {
-			var res structs.Response
+		for _, nsip := range s.UsableIPs(ns.IP) {
+			result := ChainResult{Zone: dns.Fqdn(domain), Name: ns.Name, IP: nsip.String(), Type: "DNSKEY"}
 
 			log.Debugf("Asking NS %s (%s) DNSKEY of %s", ns.Name, nsip.String(), domain)
 
-			res, err = s.LookupDNSKEY(domain, nsip.String(), keyMap)
-			if err != nil {
-				return false, err
-			}
+			res, err := s.LookupDNSKEY(domain, nsip.String(), keyMap)
 
-			if res.Msg == nil {
-				continue
-			}
+			switch {
+			case err != nil:
+				result.Error = err.Error()
+				result.Unreachable = unreachable(err)
+			case res.Msg == nil:
+				result.Error = fmt.Sprintf("DNSKEY query for %s failed on %s", domain, nsip.String())
+			default:
+				valid, info, _ := validateDNSKEY(res.Msg.Answer)
+				if valid {
+					log.Debugf("RRSIG validated (%s -> %s)", time.Unix(info.Start, 0), time.Unix(info.End, 0))
 
-			valid, info, _ := validateDNSKEY(res.Msg.Answer)
-			if valid {
-				log.Debugf("RRSIG validated (%s -> %s)", time.Unix(info.Start, 0), time.Unix(info.End, 0))
-			} else {
-				log.Debugf("RRSIG not validated")
-				return false, fmt.Errorf("validation failed. RRSIG on DNSKEY could not be validated by any DNSKEY for %s", domain)
+					result.Valid = true
+				} else {
+					log.Debugf("RRSIG not validated")
+
+					result.Error = fmt.Sprintf("RRSIG on DNSKEY could not be validated by any DNSKEY for %s on %s", domain, nsip.String())
+				}
 			}
+
+			results = append(results, result)
 		}
 	}
 
 	log.Debugf("Found %v valid DNSKEY for %s", len(keyMap), domain)
-	// get auth servers of parent
-	log.Debugf("Finding NS of parent: %s", dns.Fqdn(getParentDomain(domain)))
-
-	_, err = s.FindNS(getParentDomain(domain))
-	if err != nil {
-		log.Debugf("ValidateDomain() error: %#v", err)
-	}
 
 	// asking parent about DS
-	return s.validateParentDS(domain, keyMap)
+	return append(results, s.validateParentDS(domain, keyMap)...)
 }
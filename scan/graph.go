@@ -0,0 +1,347 @@
+package scan
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Graph is the DNSSEC chain of trust of a domain, from the domain up to the root.
+type Graph struct {
+	Domain string
+	Nodes  []*GraphNode
+	Edges  []GraphEdge
+	nodes  map[string]*GraphNode
+}
+
+type GraphNode struct {
+	ID         string
+	Zone       string
+	Type       string // zone, DNSKEY or DS
+	KeyTag     uint16 `json:",omitempty"`
+	Algorithm  uint8  `json:",omitempty"`
+	Flags      uint16 `json:",omitempty"`
+	DigestType uint8  `json:",omitempty"`
+	Errors     []string
+}
+
+type GraphEdge struct {
+	From   string
+	To     string
+	Type   string // RRSIG, digest or delegation
+	Server string
+	Valid  bool
+	Error  string `json:",omitempty"`
+}
+
+// ChainGraph walks the chain of trust of domain like validateChain does, but keeps
+// every zone, key, DS and signature with the result per server.
+func (s *Scan) ChainGraph(domain string) *Graph {
+	g := &Graph{
+		Domain: dns.Fqdn(domain),
+		nodes:  make(map[string]*GraphNode),
+	}
+
+	var zones []string
+
+	for zone := dns.Fqdn(domain); ; zone = getParentDomain(zone) {
+		zones = append(zones, zone)
+
+		if zone == "." {
+			break
+		}
+	}
+
+	keys := make(map[string]map[uint16]*dns.DNSKEY)
+
+	for _, zone := range zones {
+		keys[zone] = g.addKeys(s, zone)
+	}
+
+	for _, zone := range zones[:len(zones)-1] {
+		g.addDS(s, zone, keys[zone], keys[getParentDomain(zone)])
+	}
+
+	return g
+}
+
+func (g *Graph) node(n GraphNode) *GraphNode {
+	if exist, ok := g.nodes[n.ID]; ok {
+		return exist
+	}
+
+	g.nodes[n.ID] = &n
+	g.Nodes = append(g.Nodes, &n)
+
+	return &n
+}
+
+func zoneID(zone string) string {
+	return zone
+}
+
+func keyID(zone string, keyTag uint16) string {
+	return fmt.Sprintf("%s/DNSKEY/%d", zone, keyTag)
+}
+
+func dsID(zone string, ds *dns.DS) string {
+	return fmt.Sprintf("%s/DS/%d/%d", zone, ds.KeyTag, ds.DigestType)
+}
+
+// addKeys asks every nameserver of zone for the DNSKEY rrset and adds the keys
+// and the signatures on the rrset to the graph.
+func (g *Graph) addKeys(s *Scan, zone string) map[uint16]*dns.DNSKEY {
+	keyMap := make(map[uint16]*dns.DNSKEY)
+	zn := g.node(GraphNode{ID: zoneID(zone), Zone: zone, Type: "zone"})
+
+	nsdata, err := s.FindNS(zone)
+	if err != nil {
+		zn.Errors = append(zn.Errors, err.Error())
+		return keyMap
+	}
+
+	for _, ns := range nsdata {
+		for _, nsip := range s.UsableIPs(ns.IP) {
+			server := fmt.Sprintf("%s (%s)", ns.Name, nsip.String())
+
+			res, err := query(zone, dns.TypeDNSKEY, nsip.String(), true)
+			if err != nil {
+				zn.Errors = append(zn.Errors, fmt.Sprintf("DNSKEY query failed on %s: %s", server, err))
+				continue
+			}
+
+			rrset := extractRR(res.Msg.Answer, dns.TypeDNSKEY)
+			if len(rrset) == 0 {
+				zn.Errors = append(zn.Errors, fmt.Sprintf("no DNSKEY found on %s", server))
+				continue
+			}
+
+			for _, rr := range rrset {
+				key := rr.(*dns.DNSKEY)
+				keyMap[key.KeyTag()] = key
+				g.node(GraphNode{
+					ID: keyID(zone, key.KeyTag()), Zone: zone, Type: "DNSKEY",
+					KeyTag: key.KeyTag(), Algorithm: key.Algorithm, Flags: key.Flags,
+				})
+			}
+
+			g.addSigs(keyMap, zone, rrset, extractRR(res.Msg.Answer, dns.TypeRRSIG), server, func(rr dns.RR) string {
+				return keyID(zone, rr.(*dns.DNSKEY).KeyTag())
+			})
+		}
+	}
+
+	return keyMap
+}
+
+// addDS asks every nameserver of the parent of zone for the DS rrset and adds the DS
+// records, their digest links to the keys of zone and the parent signatures.
+func (g *Graph) addDS(s *Scan, zone string, keyMap, parentKeys map[uint16]*dns.DNSKEY) {
+	parent := getParentDomain(zone)
+
+	nsdata, err := s.FindNS(parent)
+	if err != nil {
+		zn := g.node(GraphNode{ID: zoneID(parent), Zone: parent, Type: "zone"})
+		zn.Errors = append(zn.Errors, err.Error())
+
+		return
+	}
+
+	for _, ns := range nsdata {
+		for _, nsip := range s.UsableIPs(ns.IP) {
+			server := fmt.Sprintf("%s (%s)", ns.Name, nsip.String())
+			edge := GraphEdge{From: zoneID(parent), To: zoneID(zone), Type: "delegation", Server: server}
+
+			res, err := query(zone, dns.TypeDS, nsip.String(), true)
+			if err != nil {
+				edge.Error = err.Error()
+				g.Edges = append(g.Edges, edge)
+
+				continue
+			}
+
+			rrset := extractRR(res.Msg.Answer, dns.TypeDS)
+			if len(rrset) == 0 {
+				edge.Error = "no DS records (insecure delegation)"
+				g.Edges = append(g.Edges, edge)
+
+				continue
+			}
+
+			edge.Valid = true
+			g.Edges = append(g.Edges, edge)
+
+			for _, rr := range rrset {
+				ds := rr.(*dns.DS)
+				g.node(GraphNode{
+					ID: dsID(zone, ds), Zone: zone, Type: "DS",
+					KeyTag: ds.KeyTag, Algorithm: ds.Algorithm, DigestType: ds.DigestType,
+				})
+
+				digest := GraphEdge{From: dsID(zone, ds), To: keyID(zone, ds.KeyTag), Type: "digest", Server: server}
+
+				key, ok := keyMap[ds.KeyTag]
+
+				switch {
+				case !ok:
+					digest.Error = "no DNSKEY with this keytag"
+				case key.ToDS(ds.DigestType) == nil:
+					digest.Error = fmt.Sprintf("unsupported digest type %d", ds.DigestType)
+				case strings.EqualFold(key.ToDS(ds.DigestType).Digest, ds.Digest):
+					digest.Valid = true
+				default:
+					digest.Error = "digest mismatch"
+				}
+
+				if !ok {
+					g.node(GraphNode{ID: keyID(zone, ds.KeyTag), Zone: zone, Type: "DNSKEY", KeyTag: ds.KeyTag, Errors: []string{"not published"}})
+				}
+
+				g.Edges = append(g.Edges, digest)
+			}
+
+			g.addSigs(parentKeys, parent, rrset, extractRR(res.Msg.Answer, dns.TypeRRSIG), server, func(rr dns.RR) string {
+				return dsID(zone, rr.(*dns.DS))
+			})
+		}
+	}
+}
+
+// addSigs adds an RRSIG edge from the signing key to every record in rrset.
+func (g *Graph) addSigs(keyMap map[uint16]*dns.DNSKEY, signer string, rrset, sigs []dns.RR, server string, id func(dns.RR) string) {
+	for _, rr := range sigs {
+		sig := rr.(*dns.RRSIG)
+		if sig.TypeCovered != rrset[0].Header().Rrtype {
+			continue
+		}
+
+		edge := GraphEdge{From: keyID(signer, sig.KeyTag), Type: "RRSIG", Server: server}
+
+		key, ok := keyMap[sig.KeyTag]
+
+		switch {
+		case !ok:
+			edge.Error = "signing DNSKEY not found"
+
+			g.node(GraphNode{ID: keyID(signer, sig.KeyTag), Zone: signer, Type: "DNSKEY", KeyTag: sig.KeyTag, Errors: []string{"not published"}})
+		case !sig.ValidityPeriod(time.Now()):
+			edge.Error = "signature expired or not yet valid"
+		default:
+			if err := sig.Verify(key, rrset); err != nil {
+				edge.Error = err.Error()
+			} else {
+				edge.Valid = true
+			}
+		}
+
+		for _, rr := range rrset {
+			e := edge
+			e.To = id(rr)
+			g.Edges = append(g.Edges, e)
+		}
+	}
+}
+
+// DOT returns the graph in Graphviz DOT format. Edges that are the same on every
+// server are merged, failing edges are red and list the servers they fail on.
+func (g *Graph) DOT() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "digraph %q {\n", g.Domain)
+	sb.WriteString("\trankdir=BT;\n")
+
+	var zones []string
+
+	byZone := make(map[string][]*GraphNode)
+
+	for _, n := range g.Nodes {
+		if _, ok := byZone[n.Zone]; !ok {
+			zones = append(zones, n.Zone)
+		}
+
+		byZone[n.Zone] = append(byZone[n.Zone], n)
+	}
+
+	for _, zone := range zones {
+		fmt.Fprintf(&sb, "\tsubgraph %q {\n\t\tlabel=%q;\n", "cluster_"+zone, zone)
+
+		for _, n := range byZone[zone] {
+			fmt.Fprintf(&sb, "\t\t%q [%s];\n", n.ID, n.dotAttr())
+		}
+
+		sb.WriteString("\t}\n")
+	}
+
+	type edgeKey struct {
+		from, to, typ string
+		valid         bool
+	}
+
+	var keys []edgeKey
+
+	servers := make(map[edgeKey][]string)
+
+	for _, e := range g.Edges {
+		k := edgeKey{e.From, e.To, e.Type, e.Valid}
+		if _, ok := servers[k]; !ok {
+			keys = append(keys, k)
+		}
+
+		server := e.Server
+		if e.Error != "" {
+			server += ": " + e.Error
+		}
+
+		servers[k] = append(servers[k], server)
+	}
+
+	for _, k := range keys {
+		if k.valid {
+			fmt.Fprintf(&sb, "\t%q -> %q [label=%q, color=green];\n", k.from, k.to, k.typ)
+			continue
+		}
+
+		sort.Strings(servers[k])
+		fmt.Fprintf(&sb, "\t%q -> %q [label=%q, color=red, style=dashed];\n", k.from, k.to, k.typ+"\n"+strings.Join(servers[k], "\n"))
+	}
+
+	sb.WriteString("}\n")
+
+	return sb.String()
+}
+
+func (n *GraphNode) dotAttr() string {
+	var label string
+
+	switch n.Type {
+	case "zone":
+		label = n.Zone
+	case "DNSKEY":
+		role := "ZSK"
+		if n.Flags&dns.SEP != 0 {
+			role = "KSK"
+		}
+
+		label = fmt.Sprintf("DNSKEY %d\n%s alg %d", n.KeyTag, role, n.Algorithm)
+	case "DS":
+		label = fmt.Sprintf("DS %d\ndigest %d", n.KeyTag, n.DigestType)
+	}
+
+	attr := fmt.Sprintf("label=%q", strings.Join(append([]string{label}, n.Errors...), "\n"))
+
+	switch {
+	case n.Type == "zone":
+		attr += ", shape=box"
+	case n.Type == "DS":
+		attr += ", shape=diamond"
+	}
+
+	if len(n.Errors) > 0 {
+		attr += ", color=red"
+	}
+
+	return attr
+}
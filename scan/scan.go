@@ -5,6 +5,7 @@ import (
 	"net"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/42wim/dt/structs"
@@ -52,12 +53,18 @@ type Config struct {
 type Scan struct {
 	*Config
 	nsdataCache map[string][]structs.NSData
+	chainCache  map[string]*chainEntry
+	cacheMu     sync.Mutex
+	chainMu     sync.Mutex
+	ipv6Once    sync.Once
+	ipv6        bool
 }
 
 func New(cfg *Config, resolver string) *Scan {
 	s := &Scan{
 		Config:      cfg,
 		nsdataCache: make(map[string][]structs.NSData),
+		chainCache:  make(map[string]*chainEntry),
 	}
 
 	if *cfg.Debug {
@@ -69,8 +76,8 @@ func New(cfg *Config, resolver string) *Scan {
 	return s
 }
 
-func (s *Scan) zoneTransfer(domain, server string) []string {
-	var records []string
+func (s *Scan) zoneTransfer(domain, server string) []dns.RR {
+	var records []dns.RR
 
 	t := new(dns.Transfer)
 	req := prepMsg()
@@ -91,16 +98,29 @@ func (s *Scan) zoneTransfer(domain, server string) []string {
 			break
 		}
 
-		for _, rr := range res.RR {
-			records = append(records, rr.String())
-		}
+		records = append(records, res.RR...)
 	}
 
-	sort.Strings(records)
-
 	return records
 }
 
+// ZoneTransfer tries an AXFR of domain on every nameserver and returns the records
+// of the first transfer that succeeds. The trailing SOA of the transfer is removed.
+func (s *Scan) ZoneTransfer(domain string) ([]dns.RR, error) {
+	for _, ip := range s.FindNSIP(domain) {
+		records := s.zoneTransfer(domain, ip.String())
+		if len(records) > 1 {
+			if records[len(records)-1].Header().Rrtype == dns.TypeSOA {
+				records = records[:len(records)-1]
+			}
+
+			return records, nil
+		}
+	}
+
+	return nil, fmt.Errorf("AXFR denied")
+}
+
 func (s *Scan) GetNSInfo(domain, name string, IP net.IP) (structs.NSInfo, error) {
 	var newnsinfo structs.NSInfo
 
@@ -119,7 +139,7 @@ func (s *Scan) GetNSInfo(domain, name string, IP net.IP) (structs.NSInfo, error)
 	res, err := Query(domain, dns.TypeNS, IP.String(), true)
 	if err == nil {
 		valid, keyinfo, _ := s.ValidateRRSIG(keys, res.Msg.Answer)
-		newnsinfo.DNSSECInfo = structs.DNSSECInfo{Valid: valid, KeyInfo: keyinfo, ChainValid: false}
+		newnsinfo.DNSSECInfo = structs.DNSSECInfo{Valid: valid, KeyInfo: keyinfo, ChainValid: valid && s.chainValid(domain, IP)}
 
 		if keyinfo.Start == 0 && len(keys) == 0 {
 			newnsinfo.Disabled = true
@@ -150,10 +170,17 @@ func (s *Scan) DomainScan(domain string) []Response {
 
 func (s *Scan) doZoneTransfer(domain string, ips []net.IP) ([]Response, error) {
 	for _, ip := range ips {
-		res := s.zoneTransfer(domain, ip.String())
+		var res []string
+
+		for _, rr := range s.zoneTransfer(domain, ip.String()) {
+			res = append(res, rr.String())
+		}
+
 		if len(res) > 0 {
 			zt := ""
 
+			sort.Strings(res)
+
 			for _, rr := range res {
 				zt += fmt.Sprintln(rr)
 			}
@@ -352,6 +379,41 @@ func (s *Scan) Resolver() string {
 	return s.resolver
 }
 
+// IPv6 returns true if the host has an IPv6 route. A UDP dial sends nothing, it
+// only fails when there is no route to the address (a.root-servers.net).
+func (s *Scan) IPv6() bool {
+	s.ipv6Once.Do(func() {
+		conn, err := net.Dial("udp", "[2001:503:ba3e::2:30]:53")
+		if err != nil {
+			log.Debugf("IPv6 not available: %s", err)
+			return
+		}
+
+		conn.Close()
+
+		s.ipv6 = true
+	})
+
+	return s.ipv6
+}
+
+// UsableIPs returns ips without the IPv6 addresses if IPv6 isn't available.
+func (s *Scan) UsableIPs(ips []net.IP) []net.IP {
+	if s.IPv6() {
+		return ips
+	}
+
+	var usable []net.IP
+
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			usable = append(usable, ip)
+		}
+	}
+
+	return usable
+}
+
 func (s *Scan) NSData() map[string][]structs.NSData {
 	return s.nsdataCache
 }
@@ -0,0 +1,428 @@
+package scan
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/42wim/dt/structs"
+	"github.com/miekg/dns"
+)
+
+// DNSSEC status of a zone on the trace.
+const (
+	TraceSecure        = "secure"
+	TraceInsecure      = "insecure"
+	TraceBogus         = "bogus"
+	TraceIndeterminate = "indeterminate" // no server of the zone answered the DNSKEY query
+)
+
+// Trace is the path of referrals from the root to the answer for a name.
+type Trace struct {
+	Name string
+	Type string
+	Hops []TraceHop
+}
+
+// TraceHop is a zone on the path with the answer of every server of the zone.
+type TraceHop struct {
+	Zone     string
+	Servers  []TraceServer
+	Referral string   `json:",omitempty"` // zone the servers refer to, empty for the last hop
+	DS       bool     // the referral to the next zone has DS records
+	DNSSEC   string   // secure, insecure, bogus or indeterminate
+	Errors   []string `json:",omitempty"` // DNSSEC failures of the zone
+	Diff     []string `json:",omitempty"` // inconsistencies between the servers of the zone
+}
+
+// TraceServer is the answer of one nameserver ip of a zone on the path.
+type TraceServer struct {
+	Name          string
+	IP            string
+	Glue          bool // the address came from glue of the parent
+	Rtt           time.Duration
+	Rcode         string
+	Authoritative bool
+	Answer        []string `json:",omitempty"`
+	Referral      string   `json:",omitempty"`
+	NS            []string `json:",omitempty"` // NS names of the referral
+	GlueRR        []string `json:",omitempty"` // glue in the referral
+	DS            bool     // the referral has DS records
+	DSRR          []string `json:",omitempty"` // DS records in the referral
+	Error         string   `json:",omitempty"`
+}
+
+// traceMaxHops stops referral loops.
+const traceMaxHops = 32
+
+// Trace follows the referrals for name and qtype from the root servers down, asking
+// every server of every zone on the path.
+func (s *Scan) Trace(name string, qtype uint16) *Trace {
+	t := &Trace{Name: dns.Fqdn(name), Type: dns.TypeToString[qtype]}
+
+	zone := "."
+	secure := true
+
+	nsdata, err := s.FindNS(zone)
+	if err != nil {
+		t.Hops = append(t.Hops, TraceHop{Zone: zone, Errors: []string{err.Error()}})
+		return t
+	}
+
+	glue := make(map[string]bool)
+
+	// DS records of the referral to zone, the root has none
+	var ds []dns.RR
+
+	for len(t.Hops) < traceMaxHops {
+		hop := TraceHop{Zone: zone, Servers: s.traceServers(t.Name, qtype, nsdata, glue)}
+		hop.Referral, hop.DS = traceReferral(hop.Servers)
+		hop.Diff = traceDiff(hop.Servers)
+
+		hop.DNSSEC, hop.Errors = s.traceDNSSEC(zone, secure, nsdata, ds)
+		secure = hop.DNSSEC == TraceSecure && hop.DS
+
+		if hop.Referral != "" && !(dns.IsSubDomain(zone, hop.Referral) && hop.Referral != zone) {
+			hop.Diff = append(hop.Diff, fmt.Sprintf("referral to %s is not below %s", hop.Referral, zone))
+			hop.Referral = ""
+		}
+
+		t.Hops = append(t.Hops, hop)
+
+		if hop.Referral == "" {
+			break
+		}
+
+		zone = hop.Referral
+		ds = traceDS(hop.Servers, zone)
+		nsdata, glue = s.traceNext(hop.Servers, zone)
+	}
+
+	return t
+}
+
+// traceServers asks every ip of nsdata for name, concurrently.
+func (s *Scan) traceServers(name string, qtype uint16, nsdata []structs.NSData, glue map[string]bool) []TraceServer {
+	var servers []TraceServer
+
+	for _, ns := range nsdata {
+		for _, nsip := range s.UsableIPs(ns.IP) {
+			servers = append(servers, TraceServer{Name: ns.Name, IP: nsip.String(), Glue: glue[nsip.String()]})
+		}
+	}
+
+	var wg sync.WaitGroup
+
+	for i := range servers {
+		wg.Add(1)
+
+		go func(srv *TraceServer) {
+			defer wg.Done()
+
+			traceQuery(srv, name, qtype)
+		}(&servers[i])
+	}
+
+	wg.Wait()
+
+	return servers
+}
+
+// traceQuery asks srv for name and keeps the answer or the referral.
+func traceQuery(srv *TraceServer, name string, qtype uint16) {
+	res, err := query(name, qtype, srv.IP, true)
+	srv.Rtt = res.Rtt
+
+	if err != nil {
+		if strings.HasPrefix(err.Error(), "failure: ") {
+			srv.Rcode = strings.TrimPrefix(err.Error(), "failure: ")
+		} else {
+			srv.Error = err.Error()
+		}
+
+		return
+	}
+
+	msg := res.Msg
+	srv.Rcode = dns.RcodeToString[msg.Rcode]
+	srv.Authoritative = msg.Authoritative
+
+	for _, rr := range msg.Answer {
+		srv.Answer = append(srv.Answer, rr.String())
+	}
+
+	if len(msg.Answer) > 0 {
+		return
+	}
+
+	for _, rr := range extractRR(msg.Ns, dns.TypeNS) {
+		srv.Referral = strings.ToLower(rr.Header().Name)
+		srv.NS = append(srv.NS, strings.ToLower(rr.(*dns.NS).Ns))
+	}
+
+	for _, rr := range extractRR(msg.Ns, dns.TypeDS) {
+		srv.DSRR = append(srv.DSRR, rr.String())
+	}
+
+	srv.DS = len(srv.DSRR) > 0
+
+	for _, rr := range extractRR(msg.Extra, dns.TypeA, dns.TypeAAAA) {
+		for _, ip := range extractIP([]dns.RR{rr}) {
+			srv.GlueRR = append(srv.GlueRR, strings.ToLower(rr.Header().Name)+" "+ip.String())
+		}
+	}
+
+	sort.Strings(srv.NS)
+	sort.Strings(srv.GlueRR)
+}
+
+// traceReferral returns the referral most servers of the hop send and whether it
+// has DS records.
+func traceReferral(servers []TraceServer) (string, bool) {
+	count := make(map[string]int)
+	ds := make(map[string]bool)
+
+	var (
+		referral string
+		best     int
+	)
+
+	for _, srv := range servers {
+		if srv.Referral == "" {
+			continue
+		}
+
+		count[srv.Referral]++
+		ds[srv.Referral] = ds[srv.Referral] || srv.DS
+
+		if count[srv.Referral] > best {
+			referral, best = srv.Referral, count[srv.Referral]
+		}
+	}
+
+	return referral, ds[referral]
+}
+
+// traceDiff returns the differences between the answers of the servers of a hop.
+func traceDiff(servers []TraceServer) []string {
+	var diff []string
+
+	keys := []struct {
+		what string
+		key  func(TraceServer) string
+	}{
+		{"rcode", func(srv TraceServer) string { return srv.Rcode }},
+		{"authoritative", func(srv TraceServer) string { return fmt.Sprint(srv.Authoritative) }},
+		{"answer", func(srv TraceServer) string { return traceAnswerKey(srv.Answer) }},
+		{"referral", func(srv TraceServer) string { return srv.Referral }},
+		{"NS", func(srv TraceServer) string { return strings.Join(srv.NS, " ") }},
+		{"glue", func(srv TraceServer) string { return strings.Join(srv.GlueRR, ", ") }},
+		{"DS", func(srv TraceServer) string { return fmt.Sprint(srv.DS) }},
+	}
+
+	for _, k := range keys {
+		var values []string
+
+		m := make(map[string][]string)
+
+		for _, srv := range servers {
+			if srv.Error != "" {
+				continue
+			}
+
+			v := k.key(srv)
+			if m[v] == nil {
+				values = append(values, v)
+			}
+
+			m[v] = append(m[v], fmt.Sprintf("%s(%s)", srv.Name, srv.IP))
+		}
+
+		if len(m) < 2 {
+			continue
+		}
+
+		for _, v := range values {
+			value := v
+			if value == "" {
+				value = "none"
+			}
+
+			diff = append(diff, fmt.Sprintf("%s differs: %v: %s", k.what, m[v], value))
+		}
+	}
+
+	return diff
+}
+
+// traceAnswerKey returns the answer records without TTL and signatures, sorted.
+func traceAnswerKey(answer []string) string {
+	var key []string
+
+	for _, s := range answer {
+		rr, err := dns.NewRR(s)
+		if err != nil || rr.Header().Rrtype == dns.TypeRRSIG {
+			continue
+		}
+
+		rdata := strings.TrimPrefix(rr.String(), rr.Header().String())
+		key = append(key, fmt.Sprintf("%s %s %s", rr.Header().Name, dns.TypeToString[rr.Header().Rrtype], rdata))
+	}
+
+	sort.Strings(key)
+
+	return strings.Join(key, ", ")
+}
+
+// traceDNSSEC returns the DNSSEC status of zone. A zone is only secure if its parent
+// is secure and has DS records for it. Every server of the zone must send a DNSKEY
+// rrset that validates and, below the root, matches a DS record of the referral.
+// Servers that don't answer are skipped.
+func (s *Scan) traceDNSSEC(zone string, secure bool, nsdata []structs.NSData, ds []dns.RR) (string, []string) {
+	var errors []string
+
+	if !secure {
+		return TraceInsecure, errors
+	}
+
+	answered := false
+
+	for _, ns := range nsdata {
+		for _, nsip := range s.UsableIPs(ns.IP) {
+			keyMap := make(map[uint16]*dns.DNSKEY)
+
+			res, err := s.LookupDNSKEY(zone, nsip.String(), keyMap)
+
+			switch {
+			case err != nil && unreachable(err):
+				continue
+			case err != nil:
+				answered = true
+
+				errors = append(errors, fmt.Sprintf("DNSKEY query for %s failed on %s: %s", zone, nsip, err))
+
+				continue
+			}
+
+			answered = true
+
+			if valid, _, _ := validateDNSKEY(res.Msg.Answer); !valid {
+				errors = append(errors, fmt.Sprintf("RRSIG on DNSKEY could not be validated by any DNSKEY for %s on %s", zone, nsip))
+				continue
+			}
+
+			if zone != "." && !traceDSMatch(ds, keyMap) {
+				errors = append(errors, fmt.Sprintf("No DNSKEY for %s on %s matches a DS record of the referral", zone, nsip))
+			}
+		}
+	}
+
+	switch {
+	case !answered:
+		return TraceIndeterminate, []string{fmt.Sprintf("no nameserver of %s answered the DNSKEY query", zone)}
+	case len(errors) > 0:
+		return TraceBogus, errors
+	}
+
+	return TraceSecure, errors
+}
+
+// traceDS returns the DS records for zone in the referrals of servers.
+func traceDS(servers []TraceServer, zone string) []dns.RR {
+	var ds []dns.RR
+
+	seen := make(map[string]bool)
+
+	for _, srv := range servers {
+		if srv.Referral != zone {
+			continue
+		}
+
+		for _, s := range srv.DSRR {
+			rr, err := dns.NewRR(s)
+			if err != nil {
+				continue
+			}
+
+			key := strings.TrimPrefix(rr.String(), rr.Header().String())
+			if !seen[key] {
+				seen[key] = true
+				ds = append(ds, rr)
+			}
+		}
+	}
+
+	return ds
+}
+
+// traceDSMatch returns true if a DS record has the digest of a key in keyMap.
+func traceDSMatch(ds []dns.RR, keyMap map[uint16]*dns.DNSKEY) bool {
+	for _, rr := range ds {
+		d, ok := rr.(*dns.DS)
+		if !ok {
+			continue
+		}
+
+		key := keyMap[d.KeyTag]
+		if key == nil {
+			continue
+		}
+
+		if childDS := key.ToDS(d.DigestType); childDS != nil && strings.EqualFold(childDS.Digest, d.Digest) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// traceNext returns the nameservers of the referral to zone, with the addresses from
+// the glue or, without glue, from the resolver.
+func (s *Scan) traceNext(servers []TraceServer, zone string) ([]structs.NSData, map[string]bool) {
+	var names []string
+
+	ips := make(map[string][]net.IP)
+	glue := make(map[string]bool)
+
+	for _, srv := range servers {
+		if srv.Referral != zone {
+			continue
+		}
+
+		for _, name := range srv.NS {
+			if _, ok := ips[name]; !ok {
+				names = append(names, name)
+				ips[name] = nil
+			}
+		}
+
+		for _, g := range srv.GlueRR {
+			fields := strings.Fields(g)
+			if len(fields) != 2 || glue[fields[1]] {
+				continue
+			}
+
+			glue[fields[1]] = true
+			ips[fields[0]] = append(ips[fields[0]], net.ParseIP(fields[1]))
+		}
+	}
+
+	var nsdata []structs.NSData
+
+	for _, name := range names {
+		if len(ips[name]) == 0 {
+			ips[name] = append(getIP(name, dns.TypeA, s.resolver), getIP(name, dns.TypeAAAA, s.resolver)...)
+		}
+
+		nsdata = append(nsdata, structs.NSData{Name: name, IP: ips[name]})
+	}
+
+	if len(nsdata) == 0 {
+		nsdata, _ = s.FindNS(zone)
+	}
+
+	return nsdata, glue
+}
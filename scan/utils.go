@@ -86,6 +86,8 @@ func QueryClass(q string, qtype uint16, server string, sec bool, class uint16) (
 	}
 
 	if in.Rcode != 0 {
+		// keep the message, the authority section of an NXDOMAIN has the SOA of the zone
+		resp.Msg = in
 		resp.Rtt = rtt
 		return resp, fmt.Errorf("failure: %s", dns.RcodeToString[in.Rcode])
 	}
@@ -116,7 +118,11 @@ func queryRRset(q string, qtype uint16, server string, sec bool) ([]dns.RR, time
 }
 
 func (s *Scan) FindNS(domain string) ([]structs.NSData, error) {
-	if nsdatas, ok := s.nsdataCache[domain]; ok {
+	s.cacheMu.Lock()
+	nsdatas, ok := s.nsdataCache[domain]
+	s.cacheMu.Unlock()
+
+	if ok {
 		return nsdatas, nil
 	}
 
@@ -125,8 +131,6 @@ func (s *Scan) FindNS(domain string) ([]structs.NSData, error) {
 		return []structs.NSData{}, err
 	}
 
-	var nsdatas []structs.NSData
-
 	for _, rr := range rrset {
 		var ips []net.IP
 
@@ -152,7 +156,9 @@ func (s *Scan) FindNS(domain string) ([]structs.NSData, error) {
 		return nsdatas, fmt.Errorf("no NS found")
 	}
 
+	s.cacheMu.Lock()
 	s.nsdataCache[domain] = nsdatas
+	s.cacheMu.Unlock()
 
 	return nsdatas, nil
 }